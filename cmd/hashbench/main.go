@@ -0,0 +1,65 @@
+// Command hashbench times argon2id on the current host and recommends parameters
+// that keep a single password hash close to the target duration.
+//
+//	go run ./cmd/hashbench -target 250ms -memory 65536
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/cbrookscode/chirpy/internal/auth"
+)
+
+func main() {
+	target := flag.Duration("target", 500*time.Millisecond, "how long one hash should take")
+	memory := flag.Uint("memory", uint(auth.DefaultHashParams.Memory), "memory to use in KiB")
+	parallelism := flag.Uint("parallelism", uint(auth.DefaultHashParams.Parallelism), "number of threads")
+	maxIterations := flag.Uint("max-iterations", 20, "stop searching past this many iterations")
+	flag.Parse()
+
+	params := auth.DefaultHashParams
+	params.Memory = uint32(*memory)
+	params.Parallelism = uint8(*parallelism)
+
+	var elapsed time.Duration
+	for params.Iterations = 1; params.Iterations <= uint32(*maxIterations); params.Iterations++ {
+		var err error
+		elapsed, err = timeHash(params)
+		if err != nil {
+			log.Fatalf("issue hashing with %+v: %v", params, err)
+		}
+		fmt.Printf("memory=%dKiB iterations=%d parallelism=%d: %v\n",
+			params.Memory, params.Iterations, params.Parallelism, elapsed.Round(time.Millisecond))
+		if elapsed >= *target {
+			break
+		}
+	}
+	if params.Iterations > uint32(*maxIterations) {
+		params.Iterations = uint32(*maxIterations)
+		fmt.Printf("\ntarget not reached within %d iterations, consider raising -memory\n", *maxIterations)
+	}
+
+	fmt.Printf("\nrecommended settings (%v per hash):\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("ARGON2_MEMORY_KIB=%d\n", params.Memory)
+	fmt.Printf("ARGON2_ITERATIONS=%d\n", params.Iterations)
+	fmt.Printf("ARGON2_PARALLELISM=%d\n", params.Parallelism)
+}
+
+// timeHash returns the fastest of a few runs so one-off scheduling noise doesn't skew the result.
+func timeHash(params argon2id.Params) (time.Duration, error) {
+	best := time.Duration(0)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if _, err := argon2id.CreateHash("hashbench-password", &params); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); best == 0 || d < best {
+			best = d
+		}
+	}
+	return best, nil
+}
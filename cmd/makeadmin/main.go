@@ -0,0 +1,52 @@
+// Command makeadmin sets the role of an existing user. Use it to bootstrap the first admin,
+// who can then manage everyone else through the /admin routes.
+//
+//	go run ./cmd/makeadmin -email admin@example.com
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the user to update")
+	role := flag.String("role", auth.RoleAdmin, "role to give the user: user, moderator or admin")
+	flag.Parse()
+
+	if *email == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !auth.ValidRole(*role) {
+		log.Fatalf("unknown role %q", *role)
+	}
+
+	godotenv.Load()
+	db, err := sql.Open("postgres", os.Getenv("DB_URL"))
+	if err != nil {
+		log.Fatalf("error opening db: %v", err)
+	}
+	defer db.Close()
+
+	dbUser, err := database.New(db).SetUserRoleByEmail(context.Background(), database.SetUserRoleByEmailParams{
+		Email: sql.NullString{String: *email, Valid: true},
+		Role:  *role,
+	})
+	if err == sql.ErrNoRows {
+		log.Fatalf("no user with email %s, create the account first", *email)
+	}
+	if err != nil {
+		log.Fatalf("issue updating role: %v", err)
+	}
+	fmt.Printf("%s (%v) is now %s. Log in again to get a token with the new role.\n", dbUser.Email.String, dbUser.ID, dbUser.Role)
+}
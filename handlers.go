@@ -1,34 +1,94 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"sort"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/cbrookscode/chirpy/internal/auth"
 	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/entitlement"
+	"github.com/cbrookscode/chirpy/internal/linkpreview"
+	"github.com/cbrookscode/chirpy/internal/loginguard"
+	"github.com/cbrookscode/chirpy/internal/mail"
+	"github.com/cbrookscode/chirpy/internal/media"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/cbrookscode/chirpy/internal/outbound"
+	"github.com/cbrookscode/chirpy/internal/password"
+	"github.com/cbrookscode/chirpy/internal/ratelimit"
+	"github.com/cbrookscode/chirpy/internal/realtime"
+	"github.com/cbrookscode/chirpy/internal/stream"
 	"github.com/google/uuid"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	secret         string
-	polkaKey       string
+	fileserverHits  atomic.Int32
+	db              *database.Queries
+	dbConn          *sql.DB
+	platform        string
+	secret          string
+	polkaKey        string
+	loginGuard      *loginguard.Guard
+	passwordChecker *password.Checker
+	mailer          mail.Sender
+	magicLinkURL    string
+	entitlements    entitlement.Config
+	chirpLimiter    *ratelimit.Limiter
+	mediaLimiter    *ratelimit.Limiter
+	webhookLimiter  *ratelimit.Limiter
+	webhookSender   *outbound.Sender
+	chirpBroker     *stream.Broker
+	realtimeHub     *realtime.Hub
+	realtimeBus     realtime.Bus
+	mediaStore      media.Store
+	linkPreviews    *linkpreview.Fetcher
+	// webhookLogRetention is how long incoming webhooks are kept; zero keeps them forever
+	webhookLogRetention time.Duration
+	// magicLinkLimiter is keyed by both client address and email
+	magicLinkLimiter *ratelimit.Limiter
 }
 
+const refreshTokenTTL = time.Hour * 1440
+
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ReplyToID *uuid.UUID `json:"reply_to_id,omitempty"`
+	Pinned    bool       `json:"pinned,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Poll      *Poll      `json:"poll,omitempty"`
+	Media     []Media    `json:"media,omitempty"`
+	// LinkPreviews are filled in once they've been fetched, some time after the chirp is posted
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty"`
+}
+
+// chirpFromDB adjusts a stored chirp to customize its json tags.
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt.Time,
+		UpdatedAt: dbChirp.UpdatedAt.Time,
+		Body:      dbChirp.Body.String,
+		UserID:    dbChirp.UserID.UUID,
+	}
+	if dbChirp.ReplyToID.Valid {
+		chirp.ReplyToID = &dbChirp.ReplyToID.UUID
+	}
+	if dbChirp.PublishAt.Valid {
+		chirp.PublishAt = &dbChirp.PublishAt.Time
+	}
+	return chirp
 }
 
 type User struct {
@@ -39,6 +99,8 @@ type User struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
+	Badges       []string  `json:"badges"`
 }
 
 func (a *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -79,6 +141,9 @@ func (a *apiConfig) handlerCreateUser(resWriter http.ResponseWriter, req *http.R
 		respondWithError(resWriter, "Provided an empty string for username or password", 400, nil)
 		return
 	}
+	if !a.checkPassword(resWriter, userinfo.Password, userinfo.Email) {
+		return
+	}
 
 	hash, err := auth.HashPassword(userinfo.Password)
 	if err != nil {
@@ -104,37 +169,54 @@ func (a *apiConfig) handlerCreateUser(resWriter http.ResponseWriter, req *http.R
 		UpdatedAt:   dbUser.UpdatedAt.Time,
 		Email:       dbUser.Email.String,
 		IsChirpyRed: dbUser.IsChirpyRed.Bool,
+		Role:        dbUser.Role,
+		Badges:      a.entitlements.Badges(dbUser.IsChirpyRed.Bool),
 	})
 }
 
+// handlerGetChirps lists chirps, oldest first unless sort=desc, optionally for one author_id.
+// An author's pinned chirps come first, in their pinned order.
+// Signed in callers don't see chirps from users they muted or who are on either side of a
+// block with them.
 func (a *apiConfig) handlerGetChirps(resWriter http.ResponseWriter, req *http.Request) {
-	chirps, err := a.db.GetChirpsAscByCreated(req.Context())
+	authorID := uuid.NullUUID{}
+	if auth_id := req.URL.Query().Get("author_id"); auth_id != "" {
+		parsed, err := uuid.Parse(auth_id)
+		if err != nil {
+			respondWithError(resWriter, "author id provided is not a valid UUID", http.StatusBadRequest, nil)
+			return
+		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	chirps, err := a.db.ListChirps(req.Context(), database.ListChirpsParams{
+		AuthorID:    authorID,
+		ViewerID:    viewerFrom(req.Context()),
+		NewestFirst: req.URL.Query().Get("sort") == "desc",
+	})
 	if err != nil {
-		respondWithError(resWriter, "Couldn't grab users in ascending order from database", http.StatusInternalServerError, err)
+		respondWithError(resWriter, "Couldn't grab chirps from database", http.StatusInternalServerError, err)
 		return
 	}
 
 	listOfChirps := []Chirp{}
-	auth_id := req.URL.Query().Get("author_id")
-	for _, chirp := range chirps {
-		if auth_id != "" && chirp.UserID.UUID.String() != auth_id {
-			continue // only include matches if an author id exists
-		}
-		listOfChirps = append(listOfChirps, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt.Time,
-			UpdatedAt: chirp.UpdatedAt.Time,
-			Body:      chirp.Body.String,
-			UserID:    chirp.UserID.UUID,
-		})
+	for _, row := range chirps {
+		chirp := chirpFromDB(row.Chirp)
+		chirp.Pinned = row.Pinned
+		listOfChirps = append(listOfChirps, chirp)
 	}
-	sortType := req.URL.Query().Get("sort")
-	if sortType == "desc" {
-		sort.Slice(listOfChirps, func(i, j int) bool {
-			return listOfChirps[i].CreatedAt.After(listOfChirps[j].CreatedAt)
-		})
+	if err := a.attachPolls(req.Context(), listOfChirps, viewerFrom(req.Context())); err != nil {
+		respondWithError(resWriter, "Couldn't load polls", http.StatusInternalServerError, err)
+		return
+	}
+	if err := a.attachMedia(req.Context(), listOfChirps); err != nil {
+		respondWithError(resWriter, "Couldn't load media", http.StatusInternalServerError, err)
+		return
+	}
+	if err := a.attachLinkPreviews(req.Context(), listOfChirps); err != nil {
+		respondWithError(resWriter, "Couldn't load link previews", http.StatusInternalServerError, err)
+		return
 	}
-
 	respondWithJson(resWriter, http.StatusOK, listOfChirps)
 }
 
@@ -145,19 +227,29 @@ func (a *apiConfig) handlerGetSingleChirp(resWriter http.ResponseWriter, req *ht
 		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
 		return
 	}
-	dbChirp, err := a.db.GetSingleChirp(req.Context(), convertedID)
+	dbChirp, err := a.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       convertedID,
+		ViewerID: viewerFrom(req.Context()),
+	})
 	if err != nil {
 		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, err)
 		return
 	}
 
-	respondWithJson(resWriter, http.StatusOK, Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt.Time,
-		UpdatedAt: dbChirp.UpdatedAt.Time,
-		Body:      dbChirp.Body.String,
-		UserID:    dbChirp.UserID.UUID,
-	})
+	chirps := []Chirp{chirpFromDB(dbChirp)}
+	if err := a.attachPolls(req.Context(), chirps, viewerFrom(req.Context())); err != nil {
+		respondWithError(resWriter, "Couldn't load polls", http.StatusInternalServerError, err)
+		return
+	}
+	if err := a.attachMedia(req.Context(), chirps); err != nil {
+		respondWithError(resWriter, "Couldn't load media", http.StatusInternalServerError, err)
+		return
+	}
+	if err := a.attachLinkPreviews(req.Context(), chirps); err != nil {
+		respondWithError(resWriter, "Couldn't load link previews", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, chirps[0])
 }
 
 func (a *apiConfig) handlerReset(reswrit http.ResponseWriter, req *http.Request) {
@@ -171,6 +263,96 @@ func (a *apiConfig) handlerReset(reswrit http.ResponseWriter, req *http.Request)
 		respondWithError(reswrit, "Failed to refresh token records", 500, err)
 		return
 	}
+	err = a.db.DeleteOAuthCodes(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete oauth code records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteOAuthClients(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete oauth client records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteMagicLinkTokens(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete magic link records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteProcessedWebhookEvents(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete webhook event records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteWebhookLogEntries(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete webhook log records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteWebhookSubscriptions(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete webhook subscription records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteChirpLikes(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete like records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteFollows(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete follow records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteNotifications(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete notification records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteNotificationPreferences(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete notification preferences", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteDrafts(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete drafts", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteMediaFiles(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete media records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeletePinnedChirps(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete pinned chirps", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteBookmarks(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete bookmark records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteBookmarkFolders(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete bookmark folders", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteConversations(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete conversation records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteUserBlocks(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete block records", http.StatusInternalServerError, err)
+		return
+	}
+	err = a.db.DeleteUserMutes(req.Context())
+	if err != nil {
+		respondWithError(reswrit, "Failed to delete mute records", http.StatusInternalServerError, err)
+		return
+	}
 	err = a.db.DeleteUsers(req.Context())
 	if err != nil {
 		log.Printf("issue deleting user records: %v", err)
@@ -200,55 +382,192 @@ func handlerReadiness(resWriter http.ResponseWriter, req *http.Request) {
 
 func (cfg *apiConfig) handlerChirps(resWriter http.ResponseWriter, req *http.Request) {
 	type incoming struct {
-		Body   string `json:"body"`
-		UserID string `json:"user_id"`
+		Body      string     `json:"body"`
+		UserID    string     `json:"user_id"`
+		ReplyToID string     `json:"reply_to_id"`
+		PublishAt *time.Time `json:"publish_at"`
+		Poll      *newPoll   `json:"poll"`
+		Media     []newMedia `json:"media"`
 	}
 
-	tokenString, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(resWriter, "Token not provied", http.StatusUnauthorized, nil)
-		return
-	}
-	userUUID, err := auth.ValidateJWT(tokenString, cfg.secret)
-	if err != nil {
-		respondWithError(resWriter, "Token invalid", http.StatusUnauthorized, nil)
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow posting chirps", http.StatusForbidden, nil)
 		return
 	}
 
 	chirp := incoming{}
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&chirp)
+	err := decoder.Decode(&chirp)
 	if err != nil {
 		log.Printf("Error decoding json data in POST request: %v\n", err)
 		respondWithError(resWriter, "Something went wrong", 500, err)
 		return
 	}
 
-	// Filter profanity and make sure chirp is less than or equal to 140 characters
-	filteredChirp := filterProfanity(chirp.Body)
-	if len(filteredChirp) <= 140 { // valid
-		dbChirp, err := cfg.db.CreateChirp(req.Context(), database.CreateChirpParams{ // store chirp in db
-			Body: sql.NullString{
-				String: filteredChirp,
-				Valid:  true},
-			UserID: uuid.NullUUID{UUID: userUUID, Valid: true},
+	replyToID := uuid.NullUUID{}
+	if chirp.ReplyToID != "" {
+		parentID, err := uuid.Parse(chirp.ReplyToID)
+		if err != nil {
+			respondWithError(resWriter, "reply_to_id is not a valid UUID", http.StatusBadRequest, nil)
+			return
+		}
+		replyToID = uuid.NullUUID{UUID: parentID, Valid: true}
+	}
+
+	cfg.postChirp(resWriter, req, newChirp{
+		Body:      chirp.Body,
+		ReplyToID: replyToID,
+		PublishAt: chirp.PublishAt,
+		Poll:      chirp.Poll,
+		Media:     chirp.Media,
+	}, nil)
+}
+
+// newChirp is a chirp about to be posted, whether sent to handlerChirps or published from a draft.
+type newChirp struct {
+	Body      string
+	ReplyToID uuid.NullUUID
+	PublishAt *time.Time
+	Poll      *newPoll
+	Media     []newMedia
+}
+
+// postChirp validates chirp, stores it as the caller's and announces it. inTx, if given, runs
+// in the same transaction just before the chirp is stored; it responds and returns false to
+// abandon the chirp.
+func (cfg *apiConfig) postChirp(resWriter http.ResponseWriter, req *http.Request, chirp newChirp, inTx func(qtx *database.Queries) bool) {
+	userUUID := accessTokenFrom(req.Context()).UserID
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), userUUID)
+	if err != nil {
+		respondWithError(resWriter, "Couldn't find user", http.StatusUnauthorized, err)
+		return
+	}
+	isRed := dbUser.IsChirpyRed.Bool
+
+	var parent database.Chirp
+	if chirp.ReplyToID.Valid {
+		// Chirps hidden by a block can't be replied to either
+		parent, err = cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+			ID:       chirp.ReplyToID.UUID,
+			ViewerID: uuid.NullUUID{UUID: userUUID, Valid: true},
 		})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(resWriter, "Chirp being replied to not found", http.StatusNotFound, nil)
+			return
+		}
 		if err != nil {
-			respondWithError(resWriter, "Error storing chrip in database", http.StatusInternalServerError, err)
+			respondWithError(resWriter, "issue finding chirp being replied to", http.StatusInternalServerError, err)
 			return
 		}
+	}
+
+	// Filter profanity and make sure chirp fits within the user's length limit, links counting
+	// the same however long they are
+	filteredChirp := filterProfanity(chirp.Body)
+	if !cfg.checkChirpBody(resWriter, isRed, filteredChirp) {
+		return
+	}
+	mentioned, ok := cfg.mentionedUsers(resWriter, req, userUUID, filteredChirp)
+	if !ok {
+		return
+	}
+	publishAt := sql.NullTime{}
+	if chirp.PublishAt != nil {
+		if !validPublishAt(resWriter, *chirp.PublishAt) {
+			return
+		}
+		publishAt = sql.NullTime{Time: chirp.PublishAt.UTC(), Valid: true}
+	}
+	if chirp.Poll != nil {
+		// A scheduled chirp's poll opens when the chirp is published
+		opens := time.Now()
+		if publishAt.Valid {
+			opens = publishAt.Time
+		}
+		checked, ok := checkNewPoll(resWriter, *chirp.Poll, opens)
+		if !ok {
+			return
+		}
+		chirp.Poll = &checked
+	}
+	if !checkNewMedia(resWriter, chirp.Media) {
+		return
+	}
+
+	if wait, ok := cfg.chirpLimiter.Allow(userUUID.String(), cfg.entitlements.For(isRed).ChirpsPerHour); !ok {
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(resWriter, "Chirp limit reached, try again later", http.StatusTooManyRequests, nil)
+		return
+	}
+
+	// The chirp and its outgoing webhooks are stored together so neither exists without the other
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
 
-		payload := Chirp{ // adjust returned struct to customize json tags
-			ID:        dbChirp.ID,
-			CreatedAt: dbChirp.CreatedAt.Time,
-			UpdatedAt: dbChirp.UpdatedAt.Time,
-			Body:      dbChirp.Body.String,
-			UserID:    dbChirp.UserID.UUID,
+	if inTx != nil && !inTx(qtx) {
+		return
+	}
+	dbChirp, err := qtx.CreateChirp(req.Context(), database.CreateChirpParams{ // store chirp in db
+		Body: sql.NullString{
+			String: filteredChirp,
+			Valid:  true},
+		UserID:    uuid.NullUUID{UUID: userUUID, Valid: true},
+		ReplyToID: uuid.NullUUID{UUID: parent.ID, Valid: parent.ID != uuid.Nil},
+		PublishAt: publishAt,
+	})
+	if err != nil {
+		respondWithError(resWriter, "Error storing chrip in database", http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := queueLinkPreviews(req.Context(), qtx, dbChirp.ID, filteredChirp); err != nil {
+		respondWithError(resWriter, "issue queueing link previews", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Scheduled chirps are announced by publishScheduledChirps once they're due
+	payload := chirpFromDB(dbChirp)
+	if chirp.Poll != nil {
+		payload.Poll, err = storePoll(req.Context(), qtx, dbChirp.ID, *chirp.Poll)
+		if err != nil {
+			respondWithError(resWriter, "issue storing poll", http.StatusInternalServerError, err)
+			return
 		}
-		respondWithJson(resWriter, http.StatusCreated, payload)
-	} else { // is not valid
-		respondWithError(resWriter, "Chrip is too long", 400, nil)
 	}
+	if len(chirp.Media) > 0 {
+		var ok bool
+		payload.Media, ok, err = storeMedia(req.Context(), qtx, userUUID, dbChirp.ID, chirp.Media)
+		if err != nil {
+			respondWithError(resWriter, "issue attaching media", http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			respondWithError(resWriter, "Media must be your own uploads not attached to another chirp", http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if !publishAt.Valid {
+		if err := enqueueWebhookEvent(req.Context(), qtx, userUUID, outbound.EventChirpCreated, payload); err != nil {
+			respondWithError(resWriter, "issue queueing webhooks", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "Error storing chrip in database", http.StatusInternalServerError, err)
+		return
+	}
+	if !publishAt.Valid {
+		cfg.publishChirpEvent(req.Context(), stream.EventChirpCreated, userUUID, payload)
+		cfg.notifyChirpCreated(req.Context(), dbChirp, parent, mentioned)
+	}
+	respondWithJson(resWriter, http.StatusCreated, payload)
 }
 
 func (cfg *apiConfig) handlerValidateUser(resWriter http.ResponseWriter, req *http.Request) {
@@ -270,40 +589,80 @@ func (cfg *apiConfig) handlerValidateUser(resWriter http.ResponseWriter, req *ht
 		return
 	}
 
-	dbUser, err := cfg.db.GetUserByEmail(req.Context(), sql.NullString{String: userinfo.Email, Valid: true})
-	if err != nil {
-		respondWithError(resWriter, "No user found", http.StatusUnauthorized, err)
+	dbUser, err := cfg.checkCredentials(req.Context(), userinfo.Email, userinfo.Password, clientIP(req))
+	var locked *lockedOutError
+	if errors.As(err, &locked) {
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.wait.Seconds()))))
+		respondWithError(resWriter, "Too many failed login attempts, try again later", http.StatusTooManyRequests, nil)
 		return
 	}
-
-	match, err := auth.CheckPasswordHash(userinfo.Password, dbUser.HashedPassword.String)
 	if err != nil {
-		respondWithError(resWriter, "Issue checking password hash match", http.StatusInternalServerError, err)
+		respondWithError(resWriter, "Incorrect email or password", http.StatusUnauthorized, nil)
 		return
 	}
-	if !match {
-		respondWithError(resWriter, "Invalid password", http.StatusUnauthorized, nil)
-		return
+
+	cfg.respondWithSession(resWriter, req, dbUser)
+}
+
+var errBadCredentials = errors.New("incorrect email or password")
+
+type lockedOutError struct {
+	wait time.Duration
+}
+
+func (e *lockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, locked for %v", e.wait)
+}
+
+// checkCredentials verifies an email and password for any login form. It applies the login
+// lockouts, takes the same time for unknown emails as wrong passwords, and upgrades stale
+// password hashes. Failures are a *lockedOutError or errBadCredentials.
+func (cfg *apiConfig) checkCredentials(ctx context.Context, email, pw, ip string) (database.User, error) {
+	if wait, ok := cfg.loginGuard.Check(email, ip); !ok {
+		return database.User{}, &lockedOutError{wait: wait}
 	}
 
-	tokenString, err := auth.MakeJWT(dbUser.ID, cfg.secret)
+	dbUser, err := cfg.db.GetUserByEmail(ctx, sql.NullString{String: email, Valid: true})
 	if err != nil {
-		respondWithError(resWriter, "Issue generating token", http.StatusInternalServerError, err)
-		return
+		auth.CompareDummyHash(pw)
+		cfg.loginGuard.Fail(email, ip)
+		return database.User{}, errBadCredentials
 	}
-	refreshString, err := auth.MakeRefreshToken()
+
+	match, err := auth.CheckPasswordHash(pw, dbUser.HashedPassword.String)
 	if err != nil {
-		respondWithError(resWriter, "Issue generating refresh token", http.StatusInternalServerError, err)
-		return
+		log.Printf("issue checking password hash for user %v: %v", dbUser.ID, err)
 	}
-	later := time.Now().UTC().Add(time.Hour * 1440)
-	_, err = cfg.db.StoreRefreshToken(req.Context(), database.StoreRefreshTokenParams{
-		Token:     refreshString,
-		UserID:    dbUser.ID,
-		ExpiresAt: sql.NullTime{Time: later, Valid: true},
-	})
+	if !match {
+		cfg.loginGuard.Fail(email, ip)
+		return database.User{}, errBadCredentials
+	}
+	cfg.loginGuard.Succeed(email)
+
+	// Upgrade hashes made with outdated argon2id params now that we have the plaintext. Only
+	// the hash we checked is replaced, so a password changed in the meantime is left alone
+	if stale, err := auth.NeedsRehash(dbUser.HashedPassword.String); err == nil && stale {
+		newHash, err := auth.HashPassword(pw)
+		if err == nil {
+			err = cfg.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+				NewHash: sql.NullString{String: newHash, Valid: true},
+				ID:      dbUser.ID,
+				OldHash: dbUser.HashedPassword,
+			})
+		}
+		if err != nil {
+			log.Printf("issue rehashing password for user %v: %v", dbUser.ID, err)
+		}
+	}
+	return dbUser, nil
+}
+
+// respondWithSession issues a new access and refresh token pair for dbUser and sends them back
+// with the user's info. Every bearer token login method ends here.
+func (cfg *apiConfig) respondWithSession(resWriter http.ResponseWriter, req *http.Request, dbUser database.User) {
+	tokenString, refreshString, err := cfg.createSession(req.Context(), dbUser)
 	if err != nil {
-		respondWithError(resWriter, "Issue storing refresh token in database", http.StatusInternalServerError, err)
+		respondWithError(resWriter, "Issue creating session", http.StatusInternalServerError, err)
 		return
 	}
 	respondWithJson(resWriter, http.StatusOK, User{
@@ -312,33 +671,59 @@ func (cfg *apiConfig) handlerValidateUser(resWriter http.ResponseWriter, req *ht
 		UpdatedAt:    dbUser.UpdatedAt.Time,
 		Email:        dbUser.Email.String,
 		IsChirpyRed:  dbUser.IsChirpyRed.Bool,
+		Role:         dbUser.Role,
+		Badges:       cfg.entitlements.Badges(dbUser.IsChirpyRed.Bool),
 		Token:        tokenString,
 		RefreshToken: refreshString,
 	})
 }
 
-func (cfg *apiConfig) handlerRefreshToken(resWriter http.ResponseWriter, req *http.Request) {
-	refTokenString, err := auth.GetBearerToken(req.Header)
+// createSession makes an access token and stores a new refresh token for dbUser.
+func (cfg *apiConfig) createSession(ctx context.Context, dbUser database.User) (string, string, error) {
+	tokenString, err := auth.MakeJWT(dbUser.ID, dbUser.Role, cfg.secret)
 	if err != nil {
-		respondWithError(resWriter, "Refresh token not provied", http.StatusUnauthorized, nil)
-		return
+		return "", "", fmt.Errorf("issue generating token: %v", err)
+	}
+	refreshString, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", fmt.Errorf("issue generating refresh token: %v", err)
 	}
+	later := time.Now().UTC().Add(refreshTokenTTL)
+	_, err = cfg.db.StoreRefreshToken(ctx, database.StoreRefreshTokenParams{
+		Token:     refreshString,
+		UserID:    dbUser.ID,
+		ExpiresAt: sql.NullTime{Time: later, Valid: true},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("issue storing refresh token in database: %v", err)
+	}
+	return tokenString, refreshString, nil
+}
 
-	dbRefToken, err := cfg.db.GrabRefreshToken(req.Context(), refTokenString)
+// refreshAccessToken makes a new access token for userID, looking the user up again so role
+// changes take effect on the next refresh.
+func (cfg *apiConfig) refreshAccessToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	dbUser, err := cfg.db.GetUserByID(ctx, userID)
 	if err != nil {
-		respondWithError(resWriter, "Refresh token not valid", http.StatusUnauthorized, nil)
-		return
+		return "", fmt.Errorf("issue finding user: %v", err)
 	}
-	if dbRefToken.ExpiresAt.Time.Before(time.Now().UTC()) {
-		respondWithError(resWriter, "Refresh token expired", http.StatusUnauthorized, nil)
+	return auth.MakeJWT(dbUser.ID, dbUser.Role, cfg.secret)
+}
+
+func (cfg *apiConfig) handlerRefreshToken(resWriter http.ResponseWriter, req *http.Request) {
+	refTokenString, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		respondWithError(resWriter, authHeaderErrorMsg(err), http.StatusUnauthorized, nil)
 		return
 	}
-	if dbRefToken.RevokedAt.Valid {
-		respondWithError(resWriter, "Refresh token revoked", http.StatusUnauthorized, nil)
+
+	dbRefToken, err := cfg.validRefreshToken(req.Context(), refTokenString)
+	if err != nil {
+		respondWithError(resWriter, err.Error(), http.StatusUnauthorized, nil)
 		return
 	}
 
-	tokenstring, err := auth.MakeJWT(dbRefToken.UserID, cfg.secret)
+	tokenstring, err := cfg.refreshAccessToken(req.Context(), dbRefToken.UserID)
 	if err != nil {
 		respondWithError(resWriter, "Failed to get new token", http.StatusInternalServerError, err)
 		return
@@ -351,10 +736,29 @@ func (cfg *apiConfig) handlerRefreshToken(resWriter http.ResponseWriter, req *ht
 	})
 }
 
+// validRefreshToken looks up a first-party refresh token. The error explains why it can't be used
+// and is safe to send back to the client.
+func (cfg *apiConfig) validRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	dbRefToken, err := cfg.db.GrabRefreshToken(ctx, token)
+	if err != nil {
+		return database.RefreshToken{}, errors.New("Refresh token not valid")
+	}
+	if dbRefToken.ExpiresAt.Time.Before(time.Now().UTC()) {
+		return database.RefreshToken{}, errors.New("Refresh token expired")
+	}
+	if dbRefToken.RevokedAt.Valid {
+		return database.RefreshToken{}, errors.New("Refresh token revoked")
+	}
+	if dbRefToken.ClientID.Valid { // oauth client tokens are refreshed through /oauth/token
+		return database.RefreshToken{}, errors.New("Refresh token not valid")
+	}
+	return dbRefToken, nil
+}
+
 func (cfg *apiConfig) handlerRevokeRefToken(resWriter http.ResponseWriter, req *http.Request) {
 	refTokenString, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(resWriter, "Refresh token not provided", http.StatusUnauthorized, nil)
+		respondWithError(resWriter, authHeaderErrorMsg(err), http.StatusUnauthorized, nil)
 		return
 	}
 
@@ -367,17 +771,12 @@ func (cfg *apiConfig) handlerRevokeRefToken(resWriter http.ResponseWriter, req *
 }
 
 func (cfg *apiConfig) handlerUpdateUser(resWriter http.ResponseWriter, req *http.Request) {
-	TokenString, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(resWriter, "token not provided", http.StatusUnauthorized, nil)
-		return
-	}
-
-	userUUID, err := auth.ValidateJWT(TokenString, cfg.secret)
-	if err != nil {
-		respondWithError(resWriter, "Invalid token", http.StatusUnauthorized, nil)
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't change account details", http.StatusForbidden, nil)
 		return
 	}
+	userUUID := accessToken.UserID
 
 	type incoming struct {
 		Password string `json:"password"`
@@ -386,7 +785,7 @@ func (cfg *apiConfig) handlerUpdateUser(resWriter http.ResponseWriter, req *http
 
 	userinfo := incoming{}
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&userinfo)
+	err := decoder.Decode(&userinfo)
 	if err != nil {
 		log.Printf("Error decoding json data in request: %v\n", err)
 		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
@@ -396,6 +795,9 @@ func (cfg *apiConfig) handlerUpdateUser(resWriter http.ResponseWriter, req *http
 		respondWithError(resWriter, "Provided an empty string for username or password", 400, nil)
 		return
 	}
+	if !cfg.checkPassword(resWriter, userinfo.Password, userinfo.Email) {
+		return
+	}
 
 	hashPW, err := auth.HashPassword(userinfo.Password)
 	if err != nil {
@@ -418,21 +820,18 @@ func (cfg *apiConfig) handlerUpdateUser(resWriter http.ResponseWriter, req *http
 		UpdatedAt:   updatedUser.UpdatedAt.Time,
 		Email:       updatedUser.Email.String,
 		IsChirpyRed: updatedUser.IsChirpyRed.Bool,
+		Role:        updatedUser.Role,
+		Badges:      cfg.entitlements.Badges(updatedUser.IsChirpyRed.Bool),
 	})
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(resWriter http.ResponseWriter, req *http.Request) {
-	TokenString, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		respondWithError(resWriter, "token not provided", http.StatusUnauthorized, nil)
-		return
-	}
-
-	userUUID, err := auth.ValidateJWT(TokenString, cfg.secret)
-	if err != nil {
-		respondWithError(resWriter, "Invalid token", http.StatusUnauthorized, nil)
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsDelete) {
+		respondWithError(resWriter, "Token does not allow deleting chirps", http.StatusForbidden, nil)
 		return
 	}
+	userUUID := accessToken.UserID
 
 	stringid := req.PathValue("chirpID")
 	convertedID, err := uuid.Parse(stringid)
@@ -452,60 +851,161 @@ func (cfg *apiConfig) handlerDeleteChirp(resWriter http.ResponseWriter, req *htt
 		return
 	}
 
-	err = cfg.db.DeleteSingleChirp(req.Context(), convertedID)
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
 	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteSingleChirp(req.Context(), convertedID)
+	if err != nil {
+		respondWithError(resWriter, "issue deleting provided chirp", http.StatusInternalServerError, err)
+		return
+	}
+	deleted := struct {
+		ID     uuid.UUID `json:"id"`
+		UserID uuid.UUID `json:"user_id"`
+	}{
+		ID:     convertedID,
+		UserID: userUUID,
+	}
+	// Nobody was told about a chirp that was still scheduled, so there's nothing to retract
+	announced := !dbChirp.PublishAt.Valid
+	if announced {
+		err = enqueueWebhookEvent(req.Context(), qtx, userUUID, outbound.EventChirpDeleted, deleted)
+		if err != nil {
+			respondWithError(resWriter, "issue queueing webhooks", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		respondWithError(resWriter, "issue deleting provided chirp", http.StatusInternalServerError, err)
 		return
 	}
+	if announced {
+		cfg.publishChirpEvent(req.Context(), stream.EventChirpDeleted, userUUID, deleted)
+	}
 	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
 }
 
-func (cfg *apiConfig) handlerChirpyRed(resWriter http.ResponseWriter, req *http.Request) {
-	apikey, err := auth.GetAPIKey(req.Header)
+func (cfg *apiConfig) handlerEditChirp(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow editing chirps", http.StatusForbidden, nil)
+		return
+	}
+	userUUID := accessToken.UserID
+
+	stringid := req.PathValue("chirpID")
+	convertedID, err := uuid.Parse(stringid)
 	if err != nil {
-		log.Printf("no key provided or issue with getting key: %v", err)
-		respondWithError(resWriter, "No key provided", http.StatusUnauthorized, nil)
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
 		return
 	}
-	if apikey != cfg.polkaKey {
-		respondWithError(resWriter, "Invalid key provided", http.StatusUnauthorized, nil)
+
+	dbChirp, err := cfg.db.GetSingleChirp(req.Context(), convertedID)
+	if err != nil {
+		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, err)
 		return
 	}
-	type incoming struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
+
+	if userUUID != dbChirp.UserID.UUID {
+		respondWithError(resWriter, "You are not the author of this chirp", http.StatusForbidden, nil)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), userUUID)
+	if err != nil {
+		respondWithError(resWriter, "Couldn't find user", http.StatusUnauthorized, err)
+		return
+	}
+	isRed := dbUser.IsChirpyRed.Bool
+	if err := cfg.entitlements.CheckEditChirps(isRed); err != nil {
+		respondWithEntitlementError(resWriter, err)
+		return
 	}
 
-	webhookInfo := incoming{}
+	type incoming struct {
+		Body string `json:"body"`
+	}
+	chirp := incoming{}
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&webhookInfo)
+	err = decoder.Decode(&chirp)
 	if err != nil {
 		log.Printf("Error decoding json data in request: %v\n", err)
 		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
 		return
 	}
 
-	if webhookInfo.Event != "user.upgraded" {
-		log.Printf("Not a user.upgraded event. %v is the event", webhookInfo.Event)
-		respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+	filteredChirp := filterProfanity(chirp.Body)
+	if !cfg.checkChirpBody(resWriter, isRed, filteredChirp) {
+		return
+	}
+	if _, ok := cfg.mentionedUsers(resWriter, req, userUUID, filteredChirp); !ok {
 		return
 	}
 
-	convertedID, err := uuid.Parse(webhookInfo.Data.UserID)
+	// The old link previews go with the old body
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
 	if err != nil {
-		log.Printf("%v is provided string that could not be parsed into UUID", webhookInfo.Data.UserID)
-		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
 
-	err = cfg.db.UpdateUserChirpyRedStatus(req.Context(), database.UpdateUserChirpyRedStatusParams{ID: convertedID, IsChirpyRed: sql.NullBool{Bool: true, Valid: true}})
+	updatedChirp, err := qtx.UpdateChirpBody(req.Context(), database.UpdateChirpBodyParams{
+		ID:   convertedID,
+		Body: sql.NullString{String: filteredChirp, Valid: true},
+	})
 	if err != nil {
-		log.Printf("Failed to update db with red status. %v is the event", webhookInfo.Event)
-		respondWithError(resWriter, "issue updating chirpy red status", http.StatusNotFound, err)
+		respondWithError(resWriter, "issue updating chirp", http.StatusInternalServerError, err)
 		return
 	}
-	log.Printf("%v is the event. and updating db was successful", webhookInfo.Event)
-	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+	if err := queueLinkPreviews(req.Context(), qtx, convertedID, filteredChirp); err != nil {
+		respondWithError(resWriter, "issue queueing link previews", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue updating chirp", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, chirpFromDB(updatedChirp))
+}
+
+func (cfg *apiConfig) handlerUnlockAccount(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	target := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&target)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if target.Email == "" && target.IP == "" {
+		respondWithError(resWriter, "Provide an email or ip to unlock", http.StatusBadRequest, nil)
+		return
+	}
+
+	unlocked := false
+	if target.Email != "" {
+		unlocked = cfg.loginGuard.Unlock(target.Email) || unlocked
+	}
+	if target.IP != "" {
+		unlocked = cfg.loginGuard.UnlockIP(target.IP) || unlocked
+	}
+	log.Printf("admin %v unlocked email %q ip %q, cleared: %v", accessTokenFrom(req.Context()).UserID, target.Email, target.IP, unlocked)
+
+	respondWithJson(resWriter, http.StatusOK, struct {
+		Unlocked bool `json:"unlocked"`
+	}{
+		Unlocked: unlocked,
+	})
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/notify"
+	"github.com/google/uuid"
+)
+
+// handlerBlockUser hides the caller and the user in the path from each other: neither sees,
+// replies to, mentions, follows or messages the other. Existing follows between them end.
+func (cfg *apiConfig) handlerBlockUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	blockedID, ok := cfg.otherUserFromPath(resWriter, req, "block")
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.BlockUser(req.Context(), database.BlockUserParams{
+		BlockerID: accessToken.UserID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue blocking user", http.StatusInternalServerError, err)
+		return
+	}
+	for _, follow := range []database.UnfollowUserParams{
+		{FollowerID: accessToken.UserID, FolloweeID: blockedID},
+		{FollowerID: blockedID, FolloweeID: accessToken.UserID},
+	} {
+		if err := qtx.UnfollowUser(req.Context(), follow); err != nil {
+			respondWithError(resWriter, "issue removing follows", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue blocking user", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUnblockUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	blockedID, ok := cfg.otherUserFromPath(resWriter, req, "block")
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnblockUser(req.Context(), database.UnblockUserParams{
+		BlockerID: accessToken.UserID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue unblocking user", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// handlerMuteUser hides the user's chirps from the caller's listings without them knowing.
+func (cfg *apiConfig) handlerMuteUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	mutedID, ok := cfg.otherUserFromPath(resWriter, req, "mute")
+	if !ok {
+		return
+	}
+
+	err := cfg.db.MuteUser(req.Context(), database.MuteUserParams{
+		MuterID: accessToken.UserID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue muting user", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUnmuteUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	mutedID, ok := cfg.otherUserFromPath(resWriter, req, "mute")
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnmuteUser(req.Context(), database.UnmuteUserParams{
+		MuterID: accessToken.UserID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue unmuting user", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// mentionedUsers finds the users body mentions, refusing the chirp if any of them is on either
+// side of a block with its author.
+func (cfg *apiConfig) mentionedUsers(resWriter http.ResponseWriter, req *http.Request, authorID uuid.UUID, body string) ([]uuid.UUID, bool) {
+	ids := notify.Mentions(body)
+	if len(ids) == 0 {
+		return nil, true
+	}
+	mentioned, err := cfg.db.GetExistingUserIDs(req.Context(), ids)
+	if err != nil {
+		respondWithError(resWriter, "issue finding mentioned users", http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if len(mentioned) == 0 {
+		return nil, true
+	}
+	blocked, err := cfg.db.IsBlockedBetween(req.Context(), database.IsBlockedBetweenParams{
+		UserID:   authorID,
+		OtherIds: mentioned,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue checking blocks", http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if blocked {
+		respondWithError(resWriter, "You can't mention a user you have blocked or who has blocked you", http.StatusForbidden, nil)
+		return nil, false
+	}
+	return mentioned, true
+}
+
+// hiddenAuthorSet loads the authors whose chirps userID's feeds leave out.
+func (cfg *apiConfig) hiddenAuthorSet(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]struct{}, error) {
+	ids, err := cfg.db.ListHiddenAuthorIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set, nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxBookmarkFolderName = 50
+
+type Bookmark struct {
+	ChirpID      uuid.UUID  `json:"chirp_id"`
+	FolderID     *uuid.UUID `json:"folder_id,omitempty"`
+	BookmarkedAt time.Time  `json:"bookmarked_at"`
+	Chirp        *Chirp     `json:"chirp,omitempty"`
+	// Cursor is passed as ?before= for the page after this bookmark.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type BookmarkFolder struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func bookmarkFolderFromDB(dbFolder database.BookmarkFolder) BookmarkFolder {
+	return BookmarkFolder{
+		ID:        dbFolder.ID,
+		Name:      dbFolder.Name,
+		CreatedAt: dbFolder.CreatedAt,
+	}
+}
+
+func nullableID(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+// handlerBookmarkChirp privately bookmarks the chirp in the path. Chirpy Red users can file it
+// in one of their folders with {"folder_id": ...}; bookmarking it again moves it, and leaving
+// the folder out takes it back out of its folder.
+func (cfg *apiConfig) handlerBookmarkChirp(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		FolderID *uuid.UUID `json:"folder_id"`
+	}
+
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+
+	target := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err = decoder.Decode(&target)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: accessToken.UserID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding chirp", http.StatusInternalServerError, err)
+		return
+	}
+
+	folderID := uuid.NullUUID{}
+	if target.FolderID != nil {
+		dbUser, err := cfg.db.GetUserByID(req.Context(), accessToken.UserID)
+		if err != nil {
+			respondWithError(resWriter, "Couldn't find user", http.StatusUnauthorized, err)
+			return
+		}
+		if err := cfg.entitlements.CheckBookmarkFolders(dbUser.IsChirpyRed.Bool); err != nil {
+			respondWithEntitlementError(resWriter, err)
+			return
+		}
+		dbFolder, ok := cfg.bookmarkFolder(resWriter, req, *target.FolderID)
+		if !ok {
+			return
+		}
+		folderID = uuid.NullUUID{UUID: dbFolder.ID, Valid: true}
+	}
+
+	dbBookmark, err := cfg.db.SaveBookmark(req.Context(), database.SaveBookmarkParams{
+		UserID:   accessToken.UserID,
+		ChirpID:  chirpID,
+		FolderID: folderID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue saving bookmark", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, Bookmark{
+		ChirpID:      dbBookmark.ChirpID,
+		FolderID:     nullableID(dbBookmark.FolderID),
+		BookmarkedAt: dbBookmark.CreatedAt,
+	})
+}
+
+func (cfg *apiConfig) handlerUnbookmarkChirp(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+
+	_, err = cfg.db.DeleteBookmark(req.Context(), database.DeleteBookmarkParams{
+		UserID:  accessToken.UserID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue removing bookmark", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// handlerListBookmarks pages through the caller's bookmarks, newest bookmark first. Pass the
+// last bookmark's cursor as ?before= for the next page and ?folder_id= to list one folder.
+// Bookmarks of deleted chirps are gone with the chirp.
+func (cfg *apiConfig) handlerListBookmarks(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+	limit, ok := pageLimit(resWriter, req)
+	if !ok {
+		return
+	}
+	query := req.URL.Query()
+
+	folderID := uuid.NullUUID{}
+	if raw := query.Get("folder_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			respondWithError(resWriter, "folder_id is not a valid UUID", http.StatusBadRequest, nil)
+			return
+		}
+		if _, ok := cfg.bookmarkFolder(resWriter, req, id); !ok {
+			return
+		}
+		folderID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	beforeCreatedAt, beforeChirpID := sql.NullTime{}, uuid.NullUUID{}
+	if raw := query.Get("before"); raw != "" {
+		createdAt, chirpID, err := decodeBookmarkCursor(raw)
+		if err != nil {
+			respondWithError(resWriter, "before is not a valid cursor", http.StatusBadRequest, nil)
+			return
+		}
+		beforeCreatedAt = sql.NullTime{Time: createdAt, Valid: true}
+		beforeChirpID = uuid.NullUUID{UUID: chirpID, Valid: true}
+	}
+
+	rows, err := cfg.db.ListBookmarks(req.Context(), database.ListBookmarksParams{
+		UserID:          accessToken.UserID,
+		FolderID:        folderID,
+		BeforeCreatedAt: beforeCreatedAt,
+		BeforeChirpID:   beforeChirpID,
+		MaxResults:      int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing bookmarks", http.StatusInternalServerError, err)
+		return
+	}
+	bookmarks := make([]Bookmark, 0, len(rows))
+	for _, row := range rows {
+		chirp := chirpFromDB(row.Chirp)
+		bookmarks = append(bookmarks, Bookmark{
+			ChirpID:      row.Chirp.ID,
+			FolderID:     nullableID(row.FolderID),
+			BookmarkedAt: row.BookmarkedAt,
+			Chirp:        &chirp,
+			Cursor:       encodeBookmarkCursor(row.BookmarkedAt, row.Chirp.ID),
+		})
+	}
+	respondWithJson(resWriter, http.StatusOK, bookmarks)
+}
+
+// encodeBookmarkCursor makes the cursor of a bookmark from where it sorts, so the next page
+// still starts in the right place once the bookmark itself has been removed.
+func encodeBookmarkCursor(bookmarkedAt time.Time, chirpID uuid.UUID) string {
+	raw := strconv.FormatInt(bookmarkedAt.UnixMicro(), 10) + "." + chirpID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeBookmarkCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	micros, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return time.Time{}, uuid.Nil, errors.New("malformed cursor")
+	}
+	usec, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	chirpID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	return time.UnixMicro(usec).UTC(), chirpID, nil
+}
+
+func (cfg *apiConfig) handlerCreateBookmarkFolder(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Name string `json:"name"`
+	}
+
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	folderInfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&folderInfo)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	name := strings.TrimSpace(folderInfo.Name)
+	if name == "" || len(name) > maxBookmarkFolderName {
+		respondWithError(resWriter, "Folder name must be between 1 and 50 characters", http.StatusBadRequest, nil)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "Couldn't find user", http.StatusUnauthorized, err)
+		return
+	}
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Concurrent creates by the same user are serialised so they can't go over the cap together.
+	if err := qtx.LockBookmarkFolders(req.Context(), accessToken.UserID); err != nil {
+		respondWithError(resWriter, "issue creating bookmark folder", http.StatusInternalServerError, err)
+		return
+	}
+	existing, err := qtx.CountBookmarkFolders(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue counting bookmark folders", http.StatusInternalServerError, err)
+		return
+	}
+	if err := cfg.entitlements.CheckBookmarkFolderCount(dbUser.IsChirpyRed.Bool, int(existing)); err != nil {
+		respondWithEntitlementError(resWriter, err)
+		return
+	}
+
+	dbFolder, err := qtx.CreateBookmarkFolder(req.Context(), database.CreateBookmarkFolderParams{
+		UserID: accessToken.UserID,
+		Name:   name,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(resWriter, "You already have a folder with that name", http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue creating bookmark folder", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue creating bookmark folder", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusCreated, bookmarkFolderFromDB(dbFolder))
+}
+
+func (cfg *apiConfig) handlerListBookmarkFolders(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	dbFolders, err := cfg.db.ListBookmarkFolders(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue listing bookmark folders", http.StatusInternalServerError, err)
+		return
+	}
+	folders := make([]BookmarkFolder, 0, len(dbFolders))
+	for _, dbFolder := range dbFolders {
+		folders = append(folders, bookmarkFolderFromDB(dbFolder))
+	}
+	respondWithJson(resWriter, http.StatusOK, folders)
+}
+
+// handlerDeleteBookmarkFolder deletes a folder; its bookmarks are kept outside any folder.
+func (cfg *apiConfig) handlerDeleteBookmarkFolder(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := bookmarksToken(resWriter, req)
+	if !ok {
+		return
+	}
+	folderID, err := uuid.Parse(req.PathValue("folderID"))
+	if err != nil {
+		respondWithError(resWriter, "folder id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteBookmarkFolder(req.Context(), database.DeleteBookmarkFolderParams{
+		ID:     folderID,
+		UserID: accessToken.UserID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue deleting bookmark folder", http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(resWriter, "Bookmark folder not found", http.StatusNotFound, nil)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// bookmarkFolder loads one of the caller's folders. Other users' folders are reported as not
+// found so their ids can't be probed.
+func (cfg *apiConfig) bookmarkFolder(resWriter http.ResponseWriter, req *http.Request, folderID uuid.UUID) (database.BookmarkFolder, bool) {
+	dbFolder, err := cfg.db.GetBookmarkFolder(req.Context(), database.GetBookmarkFolderParams{
+		ID:     folderID,
+		UserID: accessTokenFrom(req.Context()).UserID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Bookmark folder not found", http.StatusNotFound, nil)
+		return database.BookmarkFolder{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding bookmark folder", http.StatusInternalServerError, err)
+		return database.BookmarkFolder{}, false
+	}
+	return dbFolder, true
+}
+
+// bookmarksToken returns the caller's token if it may use bookmarks, which are private to the
+// user and so not exposed to third-party apps.
+func bookmarksToken(resWriter http.ResponseWriter, req *http.Request) (auth.AccessToken, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't access bookmarks", http.StatusForbidden, nil)
+		return auth.AccessToken{}, false
+	}
+	return accessToken, true
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxDraftLength is generous so a draft can be saved while it's still too long to post.
+	maxDraftLength = 2000
+	maxDrafts      = 100
+)
+
+type Draft struct {
+	ID        uuid.UUID  `json:"id"`
+	Body      string     `json:"body"`
+	ReplyToID *uuid.UUID `json:"reply_to_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func draftFromDB(dbDraft database.Draft) Draft {
+	return Draft{
+		ID:        dbDraft.ID,
+		Body:      dbDraft.Body,
+		ReplyToID: nullableID(dbDraft.ReplyToID),
+		CreatedAt: dbDraft.CreatedAt,
+		UpdatedAt: dbDraft.UpdatedAt,
+	}
+}
+
+// draftContent is the body of a create or autosave request. Drafts aren't checked against the
+// chirp rules until they're published.
+type draftContent struct {
+	Body      string     `json:"body"`
+	ReplyToID *uuid.UUID `json:"reply_to_id"`
+}
+
+func decodeDraft(resWriter http.ResponseWriter, req *http.Request) (draftContent, bool) {
+	content := draftContent{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&content)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return draftContent{}, false
+	}
+	if len(content.Body) > maxDraftLength {
+		respondWithError(resWriter, fmt.Sprintf("Drafts can be at most %d characters", maxDraftLength), http.StatusBadRequest, nil)
+		return draftContent{}, false
+	}
+	return content, true
+}
+
+// checkDraftReply makes sure the chirp a draft replies to, if any, is one the caller can see.
+func (cfg *apiConfig) checkDraftReply(resWriter http.ResponseWriter, req *http.Request, content draftContent) bool {
+	if content.ReplyToID == nil {
+		return true
+	}
+	_, err := cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       *content.ReplyToID,
+		ViewerID: uuid.NullUUID{UUID: accessTokenFrom(req.Context()).UserID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Chirp being replied to not found", http.StatusNotFound, nil)
+		return false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding chirp being replied to", http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func (content draftContent) replyTo() uuid.NullUUID {
+	if content.ReplyToID == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: *content.ReplyToID, Valid: true}
+}
+
+func (cfg *apiConfig) handlerCreateDraft(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := draftsToken(resWriter, req)
+	if !ok {
+		return
+	}
+	content, ok := decodeDraft(resWriter, req)
+	if !ok || !cfg.checkDraftReply(resWriter, req, content) {
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Concurrent saves by the same user are serialised so they can't go over the limit together.
+	if err := qtx.LockDrafts(req.Context(), accessToken.UserID); err != nil {
+		respondWithError(resWriter, "issue saving draft", http.StatusInternalServerError, err)
+		return
+	}
+	existing, err := qtx.CountDrafts(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue counting drafts", http.StatusInternalServerError, err)
+		return
+	}
+	if existing >= maxDrafts {
+		respondWithError(resWriter, fmt.Sprintf("You can have at most %d drafts", maxDrafts), http.StatusBadRequest, nil)
+		return
+	}
+
+	dbDraft, err := qtx.CreateDraft(req.Context(), database.CreateDraftParams{
+		UserID:    accessToken.UserID,
+		Body:      content.Body,
+		ReplyToID: content.replyTo(),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue saving draft", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue saving draft", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusCreated, draftFromDB(dbDraft))
+}
+
+// handlerListDrafts lists the caller's drafts, most recently saved first.
+func (cfg *apiConfig) handlerListDrafts(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := draftsToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	dbDrafts, err := cfg.db.ListDrafts(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue listing drafts", http.StatusInternalServerError, err)
+		return
+	}
+	drafts := make([]Draft, 0, len(dbDrafts))
+	for _, dbDraft := range dbDrafts {
+		drafts = append(drafts, draftFromDB(dbDraft))
+	}
+	respondWithJson(resWriter, http.StatusOK, drafts)
+}
+
+func (cfg *apiConfig) handlerGetDraft(resWriter http.ResponseWriter, req *http.Request) {
+	if _, ok := draftsToken(resWriter, req); !ok {
+		return
+	}
+	dbDraft, ok := cfg.draftFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, draftFromDB(dbDraft))
+}
+
+// handlerUpdateDraft replaces a draft's content. Clients autosave by sending the whole draft
+// as it's edited; the last save wins.
+func (cfg *apiConfig) handlerUpdateDraft(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := draftsToken(resWriter, req)
+	if !ok {
+		return
+	}
+	draftID, err := uuid.Parse(req.PathValue("draftID"))
+	if err != nil {
+		respondWithError(resWriter, "draft id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+	content, ok := decodeDraft(resWriter, req)
+	if !ok || !cfg.checkDraftReply(resWriter, req, content) {
+		return
+	}
+
+	dbDraft, err := cfg.db.UpdateDraft(req.Context(), database.UpdateDraftParams{
+		ID:        draftID,
+		UserID:    accessToken.UserID,
+		Body:      content.Body,
+		ReplyToID: content.replyTo(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Draft not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue saving draft", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, draftFromDB(dbDraft))
+}
+
+func (cfg *apiConfig) handlerDeleteDraft(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := draftsToken(resWriter, req)
+	if !ok {
+		return
+	}
+	draftID, err := uuid.Parse(req.PathValue("draftID"))
+	if err != nil {
+		respondWithError(resWriter, "draft id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteDraft(req.Context(), database.DeleteDraftParams{
+		ID:     draftID,
+		UserID: accessToken.UserID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue deleting draft", http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(resWriter, "Draft not found", http.StatusNotFound, nil)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// handlerPublishDraft posts a draft as a chirp, with the same checks as handlerChirps, and
+// deletes the draft in the same transaction so it's published exactly once. An optional
+// {"publish_at": ...} schedules the chirp instead.
+func (cfg *apiConfig) handlerPublishDraft(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	accessToken, ok := draftsToken(resWriter, req)
+	if !ok {
+		return
+	}
+	dbDraft, ok := cfg.draftFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	options := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&options)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	cfg.postChirp(resWriter, req, newChirp{
+		Body:      dbDraft.Body,
+		ReplyToID: dbDraft.ReplyToID,
+		PublishAt: options.PublishAt,
+	}, func(qtx *database.Queries) bool {
+		deleted, err := qtx.DeleteDraft(req.Context(), database.DeleteDraftParams{
+			ID:     dbDraft.ID,
+			UserID: accessToken.UserID,
+		})
+		if err != nil {
+			respondWithError(resWriter, "issue deleting draft", http.StatusInternalServerError, err)
+			return false
+		}
+		if deleted == 0 {
+			respondWithError(resWriter, "Draft was already published or deleted", http.StatusConflict, nil)
+			return false
+		}
+		return true
+	})
+}
+
+// draftFromPath loads the caller's draft named in the path. Other users' drafts are reported
+// as not found.
+func (cfg *apiConfig) draftFromPath(resWriter http.ResponseWriter, req *http.Request) (database.Draft, bool) {
+	draftID, err := uuid.Parse(req.PathValue("draftID"))
+	if err != nil {
+		respondWithError(resWriter, "draft id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.Draft{}, false
+	}
+	dbDraft, err := cfg.db.GetDraft(req.Context(), database.GetDraftParams{
+		ID:     draftID,
+		UserID: accessTokenFrom(req.Context()).UserID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Draft not found", http.StatusNotFound, nil)
+		return database.Draft{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding draft", http.StatusInternalServerError, err)
+		return database.Draft{}, false
+	}
+	return dbDraft, true
+}
+
+// draftsToken returns the caller's token if it may write chirps, which drafts become.
+func draftsToken(resWriter http.ResponseWriter, req *http.Request) (auth.AccessToken, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow managing drafts", http.StatusForbidden, nil)
+		return auth.AccessToken{}, false
+	}
+	return accessToken, true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/notify"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerFollowUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	followeeID, ok := cfg.otherUserFromPath(resWriter, req, "follow")
+	if !ok {
+		return
+	}
+	blocked, err := cfg.db.IsBlockedBetween(req.Context(), database.IsBlockedBetweenParams{
+		UserID:   accessToken.UserID,
+		OtherIds: []uuid.UUID{followeeID},
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue checking blocks", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		respondWithError(resWriter, "You can't follow a user you have blocked or who has blocked you", http.StatusForbidden, nil)
+		return
+	}
+
+	err = cfg.db.FollowUser(req.Context(), database.FollowUserParams{
+		FollowerID: accessToken.UserID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue following user", http.StatusInternalServerError, err)
+		return
+	}
+	cfg.notify(req.Context(), followeeID, notify.TypeFollow, accessToken.UserID, uuid.Nil)
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	followeeID, ok := cfg.otherUserFromPath(resWriter, req, "follow")
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnfollowUser(req.Context(), database.UnfollowUserParams{
+		FollowerID: accessToken.UserID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue unfollowing user", http.StatusInternalServerError, err)
+		return
+	}
+	cfg.unnotify(req.Context(), followeeID, notify.TypeFollow, accessToken.UserID, uuid.Nil)
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// otherUserFromPath checks the caller may change who they follow or block (verb) and that the
+// user in the path exists and isn't them.
+func (cfg *apiConfig) otherUserFromPath(resWriter http.ResponseWriter, req *http.Request, verb string) (uuid.UUID, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't change who you "+verb, http.StatusForbidden, nil)
+		return uuid.Nil, false
+	}
+
+	otherID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(resWriter, "user id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return uuid.Nil, false
+	}
+	if otherID == accessToken.UserID {
+		respondWithError(resWriter, "You can't "+verb+" yourself", http.StatusBadRequest, nil)
+		return uuid.Nil, false
+	}
+	_, err = cfg.db.GetUserByID(req.Context(), otherID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "User not found", http.StatusNotFound, nil)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding user", http.StatusInternalServerError, err)
+		return uuid.Nil, false
+	}
+	return otherID, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/notify"
+	"github.com/google/uuid"
+)
+
+type ChirpLikes struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	LikeCount int64     `json:"like_count"`
+	Liked     bool      `json:"liked"`
+}
+
+func (cfg *apiConfig) handlerLikeChirp(resWriter http.ResponseWriter, req *http.Request) {
+	cfg.setChirpLike(resWriter, req, true)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(resWriter http.ResponseWriter, req *http.Request) {
+	cfg.setChirpLike(resWriter, req, false)
+}
+
+// setChirpLike likes or unlikes the chirp in the path and, if that changed its count, pushes
+// the new count to realtime subscribers of the chirp.
+func (cfg *apiConfig) setChirpLike(resWriter http.ResponseWriter, req *http.Request, liked bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't like chirps", http.StatusForbidden, nil)
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+	dbChirp, err := cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: accessToken.UserID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding chirp", http.StatusInternalServerError, err)
+		return
+	}
+
+	var changed int64
+	if liked {
+		changed, err = cfg.db.LikeChirp(req.Context(), database.LikeChirpParams{ChirpID: chirpID, UserID: accessToken.UserID})
+	} else {
+		changed, err = cfg.db.UnlikeChirp(req.Context(), database.UnlikeChirpParams{ChirpID: chirpID, UserID: accessToken.UserID})
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue updating like", http.StatusInternalServerError, err)
+		return
+	}
+	count, err := cfg.db.CountChirpLikes(req.Context(), chirpID)
+	if err != nil {
+		respondWithError(resWriter, "issue counting likes", http.StatusInternalServerError, err)
+		return
+	}
+
+	payload := ChirpLikes{ChirpID: chirpID, LikeCount: count, Liked: liked}
+	if changed > 0 {
+		if liked {
+			cfg.notify(req.Context(), dbChirp.UserID.UUID, notify.TypeLike, accessToken.UserID, chirpID)
+		} else {
+			cfg.unnotify(req.Context(), dbChirp.UserID.UUID, notify.TypeLike, accessToken.UserID, chirpID)
+		}
+		cfg.publishRealtime(req.Context(), likesTopic(chirpID), realtimeEventLikes, uuid.Nil, struct {
+			ChirpID   uuid.UUID `json:"chirp_id"`
+			LikeCount int64     `json:"like_count"`
+		}{
+			ChirpID:   chirpID,
+			LikeCount: count,
+		})
+	}
+	respondWithJson(resWriter, http.StatusOK, payload)
+}
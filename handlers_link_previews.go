@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/linkpreview"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxLinkPreviews is how many of a chirp's links get a card.
+	maxLinkPreviews = 4
+
+	previewPending = "pending"
+	previewFailed  = "failed"
+
+	// linkPreviewBatchSize is how many due previews one worker pass claims.
+	linkPreviewBatchSize = 20
+	// linkPreviewLease hides claimed previews from other instances while they're fetched.
+	linkPreviewLease       = time.Minute
+	linkPreviewMaxAttempts = 3
+	linkPreviewBackoff     = 5 * time.Minute
+)
+
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// queueLinkPreviews replaces the preview cards of chirpID with ones for the links in body,
+// to be fetched by fetchLinkPreviews. Call it in the transaction storing the body.
+func queueLinkPreviews(ctx context.Context, qtx *database.Queries, chirpID uuid.UUID, body string) error {
+	if err := qtx.DeleteLinkPreviews(ctx, chirpID); err != nil {
+		return err
+	}
+	urls := linkpreview.URLs(body)
+	if len(urls) == 0 {
+		return nil
+	}
+	if len(urls) > maxLinkPreviews {
+		urls = urls[:maxLinkPreviews]
+	}
+	return qtx.QueueLinkPreviews(ctx, database.QueueLinkPreviewsParams{
+		ChirpID: chirpID,
+		Urls:    urls,
+	})
+}
+
+// attachLinkPreviews fills in the LinkPreviews of every chirp in chirps. Cards still being
+// fetched, or that couldn't be, are left out.
+func (cfg *apiConfig) attachLinkPreviews(ctx context.Context, chirps []Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	rows, err := cfg.db.ListLinkPreviews(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byChirp := make(map[uuid.UUID][]LinkPreview)
+	for _, row := range rows {
+		byChirp[row.ChirpID] = append(byChirp[row.ChirpID], LinkPreview{
+			URL:         row.Url,
+			Title:       row.Title.String,
+			Description: row.Description.String,
+			ImageURL:    row.ImageUrl.String,
+			SiteName:    row.SiteName.String,
+		})
+	}
+	for i := range chirps {
+		chirps[i].LinkPreviews = byChirp[chirps[i].ID]
+	}
+	return nil
+}
+
+// fetchLinkPreviews claims a batch of queued preview cards and fetches them concurrently.
+func (cfg *apiConfig) fetchLinkPreviews(ctx context.Context) error {
+	now := time.Now().UTC()
+	due, err := cfg.db.ClaimDueLinkPreviews(ctx, database.ClaimDueLinkPreviewsParams{
+		LeaseUntil: now.Add(linkPreviewLease),
+		Now:        now,
+		MaxResults: linkPreviewBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, preview := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cfg.fetchLinkPreview(ctx, preview); err != nil {
+				log.Printf("issue recording link preview for chirp %v: %v", preview.ChirpID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// fetchLinkPreview makes one attempt at a claimed preview card. Links that are blocked or have
+// nothing to show fail at once; anything else is tried again up to linkPreviewMaxAttempts.
+func (cfg *apiConfig) fetchLinkPreview(ctx context.Context, claimed database.ClaimDueLinkPreviewsRow) error {
+	preview, err := cfg.linkPreviews.Fetch(ctx, claimed.Url)
+	if err == nil {
+		return cfg.db.RecordLinkPreview(ctx, database.RecordLinkPreviewParams{
+			ChirpID:     claimed.ChirpID,
+			Position:    claimed.Position,
+			Url:         claimed.Url,
+			Title:       sql.NullString{String: preview.Title, Valid: true},
+			Description: sql.NullString{String: preview.Description, Valid: preview.Description != ""},
+			ImageUrl:    sql.NullString{String: preview.ImageURL, Valid: preview.ImageURL != ""},
+			SiteName:    sql.NullString{String: preview.SiteName, Valid: preview.SiteName != ""},
+		})
+	}
+
+	attempts := int(claimed.Attempts) + 1
+	status := previewPending
+	if errors.Is(err, linkpreview.ErrBlocked) || errors.Is(err, linkpreview.ErrNoPreview) || attempts >= linkPreviewMaxAttempts {
+		status = previewFailed
+	}
+	return cfg.db.MarkLinkPreviewFailed(ctx, database.MarkLinkPreviewFailedParams{
+		ChirpID:       claimed.ChirpID,
+		Position:      claimed.Position,
+		Url:           claimed.Url,
+		Status:        status,
+		NextAttemptAt: time.Now().UTC().Add(time.Duration(attempts) * linkPreviewBackoff),
+	})
+}
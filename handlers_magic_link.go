@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/mail"
+)
+
+const (
+	magicLinkTTL = 15 * time.Minute
+	// Login links each address or client can ask for per hour, so the endpoint can't be used
+	// to flood someone's inbox.
+	magicLinksPerEmail = 5
+	magicLinksPerIP    = 20
+)
+
+func (cfg *apiConfig) handlerRequestMagicLink(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Email    string `json:"email"`
+		DeviceID string `json:"device_id"`
+	}
+
+	linkReq := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&linkReq)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if linkReq.Email == "" || linkReq.DeviceID == "" {
+		respondWithError(resWriter, "Provided an empty string for email or device id", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Limited whether or not the email has an account, so being refused doesn't tell either
+	wait, ok := cfg.magicLinkLimiter.Allow("ip:"+clientIP(req), magicLinksPerIP)
+	if ok {
+		wait, ok = cfg.magicLinkLimiter.Allow("email:"+strings.ToLower(linkReq.Email), magicLinksPerEmail)
+	}
+	if !ok {
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(resWriter, "Too many login links requested, try again later", http.StatusTooManyRequests, nil)
+		return
+	}
+
+	// Respond the same way whether or not the email belongs to a user
+	accepted := struct {
+		Message string `json:"message"`
+	}{
+		Message: "If that email has an account, a login link is on its way",
+	}
+
+	dbUser, err := cfg.db.GetUserByEmail(req.Context(), sql.NullString{String: linkReq.Email, Valid: true})
+	if err != nil {
+		log.Printf("magic link requested for unknown email: %v", err)
+		respondWithJson(resWriter, http.StatusAccepted, accepted)
+		return
+	}
+
+	id, token, err := auth.MakeMagicLinkToken(cfg.secret)
+	if err != nil {
+		respondWithError(resWriter, "Issue generating login link", http.StatusInternalServerError, err)
+		return
+	}
+	err = cfg.db.CreateMagicLinkToken(req.Context(), database.CreateMagicLinkTokenParams{
+		ID:          id,
+		UserID:      dbUser.ID,
+		Fingerprint: auth.DeviceFingerprint(linkReq.DeviceID, req.UserAgent()),
+		ExpiresAt:   time.Now().UTC().Add(magicLinkTTL),
+	})
+	if err != nil {
+		respondWithError(resWriter, "Issue storing login link", http.StatusInternalServerError, err)
+		return
+	}
+
+	link := cfg.magicLinkURL + "?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      dbUser.Email.String,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Use this link to log in to Chirpy. It expires in %v and only works once, on the device that requested it:\n\n%s\n",
+			magicLinkTTL, link),
+	}
+	// Send in the background so response time doesn't reveal whether the email exists
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			log.Printf("issue sending magic link to user %v: %v", dbUser.ID, err)
+		}
+	}()
+
+	respondWithJson(resWriter, http.StatusAccepted, accepted)
+}
+
+func (cfg *apiConfig) handlerVerifyMagicLink(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Token    string `json:"token"`
+		DeviceID string `json:"device_id"`
+	}
+
+	verifyReq := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&verifyReq)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := auth.ValidateMagicLinkToken(verifyReq.Token, cfg.secret)
+	if err != nil {
+		respondWithError(resWriter, "Login link invalid or expired", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// Marking the token used is a single conditional update, so a link can't be redeemed twice
+	dbToken, err := cfg.db.UseMagicLinkToken(req.Context(), database.UseMagicLinkTokenParams{
+		ID:          id,
+		Fingerprint: auth.DeviceFingerprint(verifyReq.DeviceID, req.UserAgent()),
+		Now:         time.Now().UTC(),
+	})
+	if err != nil {
+		respondWithError(resWriter, "Login link invalid or expired", http.StatusUnauthorized, err)
+		return
+	}
+
+	dbUser, err := cfg.db.GetUserByID(req.Context(), dbToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "Login link invalid or expired", http.StatusUnauthorized, err)
+		return
+	}
+
+	cfg.respondWithSession(resWriter, req, dbUser)
+}
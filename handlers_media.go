@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/media"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const (
+	maxChirpMedia    = 4
+	maxAltTextLength = 1000
+	// mediaUploadsPerHour caps each user's uploads, which are decoded and stored whether or
+	// not they're ever attached.
+	mediaUploadsPerHour = 60
+	// unattachedMediaTTL is how long an upload may wait to be attached to a chirp before it's
+	// pruned. Media of deleted chirps is pruned the same way.
+	unattachedMediaTTL  = 24 * time.Hour
+	mediaPruneBatchSize = 100
+)
+
+type Media struct {
+	ID           uuid.UUID `json:"id"`
+	ContentType  string    `json:"content_type"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	AltText      string    `json:"alt_text"`
+}
+
+func mediaFromDB(dbMedia database.MediaFile) Media {
+	m := Media{
+		ID:          dbMedia.ID,
+		ContentType: dbMedia.ContentType,
+		URL:         "/api/media/" + dbMedia.ID.String(),
+		Width:       int(dbMedia.Width),
+		Height:      int(dbMedia.Height),
+		AltText:     dbMedia.AltText,
+	}
+	if dbMedia.ThumbnailKey.Valid {
+		m.ThumbnailURL = m.URL + "/thumbnail"
+	}
+	return m
+}
+
+// newMedia is an upload to attach to a chirp being posted.
+type newMedia struct {
+	ID      uuid.UUID `json:"id"`
+	AltText string    `json:"alt_text"`
+}
+
+// handlerUploadMedia stores the image in the multipart field "file" for the caller to attach
+// to a chirp. Anything not attached within unattachedMediaTTL is thrown away.
+func (cfg *apiConfig) handlerUploadMedia(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow uploading media", http.StatusForbidden, nil)
+		return
+	}
+	if wait, ok := cfg.mediaLimiter.Allow(accessToken.UserID.String(), mediaUploadsPerHour); !ok {
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(resWriter, "Upload limit reached, try again later", http.StatusTooManyRequests, nil)
+		return
+	}
+
+	// Leave room for the rest of the multipart body around the file
+	req.Body = http.MaxBytesReader(resWriter, req.Body, media.MaxUploadSize+64<<10)
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(resWriter, media.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+		respondWithError(resWriter, "Upload the image as the multipart field \"file\"", http.StatusBadRequest, nil)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, media.MaxUploadSize+1))
+	if err != nil {
+		respondWithError(resWriter, "issue reading upload", http.StatusInternalServerError, err)
+		return
+	}
+
+	img, err := media.Process(data)
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		respondWithError(resWriter, err.Error(), http.StatusRequestEntityTooLarge, nil)
+		return
+	case errors.Is(err, media.ErrUnsupportedType):
+		respondWithError(resWriter, err.Error(), http.StatusUnsupportedMediaType, nil)
+		return
+	case err != nil:
+		respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	id := uuid.New()
+	storageKey := id.String()
+	if err := cfg.mediaStore.Put(req.Context(), storageKey, img.ContentType, img.Data); err != nil {
+		respondWithError(resWriter, "issue storing media", http.StatusInternalServerError, err)
+		return
+	}
+	thumbnailKey := sql.NullString{}
+	thumbnailType := sql.NullString{}
+	if img.Thumbnail != nil {
+		thumbnailKey = sql.NullString{String: storageKey + "_thumb", Valid: true}
+		thumbnailType = sql.NullString{String: img.ThumbnailType, Valid: true}
+		if err := cfg.mediaStore.Put(req.Context(), thumbnailKey.String, img.ThumbnailType, img.Thumbnail); err != nil {
+			cfg.deleteStoredMedia(req.Context(), storageKey, sql.NullString{})
+			respondWithError(resWriter, "issue storing media", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	dbMedia, err := cfg.db.CreateMediaFile(req.Context(), database.CreateMediaFileParams{
+		ID:            id,
+		UserID:        accessToken.UserID,
+		ContentType:   img.ContentType,
+		SizeBytes:     int32(len(img.Data)),
+		Width:         int32(img.Width),
+		Height:        int32(img.Height),
+		StorageKey:    storageKey,
+		ThumbnailKey:  thumbnailKey,
+		ThumbnailType: thumbnailType,
+	})
+	if err != nil {
+		cfg.deleteStoredMedia(req.Context(), storageKey, thumbnailKey)
+		respondWithError(resWriter, "issue storing media", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusCreated, mediaFromDB(dbMedia))
+}
+
+// handlerGetMedia serves the image in the path to anyone who can see the chirp it's attached to.
+func (cfg *apiConfig) handlerGetMedia(resWriter http.ResponseWriter, req *http.Request) {
+	dbMedia, ok := cfg.mediaFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	cfg.serveMedia(resWriter, req, dbMedia.StorageKey, dbMedia.ContentType)
+}
+
+func (cfg *apiConfig) handlerGetMediaThumbnail(resWriter http.ResponseWriter, req *http.Request) {
+	dbMedia, ok := cfg.mediaFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	if !dbMedia.ThumbnailKey.Valid {
+		respondWithError(resWriter, "Media has no thumbnail", http.StatusNotFound, nil)
+		return
+	}
+	cfg.serveMedia(resWriter, req, dbMedia.ThumbnailKey.String, dbMedia.ThumbnailType.String)
+}
+
+// mediaFromPath finds the media in the path if the caller may see it: media attached to a
+// chirp is as visible as the chirp, media not attached yet only to whoever uploaded it.
+func (cfg *apiConfig) mediaFromPath(resWriter http.ResponseWriter, req *http.Request) (database.MediaFile, bool) {
+	mediaID, err := uuid.Parse(req.PathValue("mediaID"))
+	if err != nil {
+		respondWithError(resWriter, "media id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.MediaFile{}, false
+	}
+	dbMedia, err := cfg.db.GetMediaFile(req.Context(), mediaID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Media not found", http.StatusNotFound, nil)
+		return database.MediaFile{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding media", http.StatusInternalServerError, err)
+		return database.MediaFile{}, false
+	}
+
+	viewer := viewerFrom(req.Context())
+	if !dbMedia.ChirpID.Valid {
+		if !viewer.Valid || viewer.UUID != dbMedia.UserID {
+			respondWithError(resWriter, "Media not found", http.StatusNotFound, nil)
+			return database.MediaFile{}, false
+		}
+		return dbMedia, true
+	}
+	_, err = cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       dbMedia.ChirpID.UUID,
+		ViewerID: viewer,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Media not found", http.StatusNotFound, nil)
+		return database.MediaFile{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding media", http.StatusInternalServerError, err)
+		return database.MediaFile{}, false
+	}
+	return dbMedia, true
+}
+
+func (cfg *apiConfig) serveMedia(resWriter http.ResponseWriter, req *http.Request, key, contentType string) {
+	file, err := cfg.mediaStore.Open(req.Context(), key)
+	if errors.Is(err, media.ErrNotFound) {
+		respondWithError(resWriter, "Media not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue loading media", http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+
+	// Stored files never change, but who may see them can
+	resWriter.Header().Set("Content-Type", contentType)
+	resWriter.Header().Set("X-Content-Type-Options", "nosniff")
+	resWriter.Header().Set("Cache-Control", "private, max-age=86400")
+	resWriter.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(resWriter, file); err != nil {
+		log.Printf("issue sending media %s: %v", key, err)
+	}
+}
+
+// checkNewMedia validates the media of a chirp being posted, responding with a 400 if it's not
+// allowed.
+func checkNewMedia(resWriter http.ResponseWriter, items []newMedia) bool {
+	if len(items) > maxChirpMedia {
+		respondWithError(resWriter, fmt.Sprintf("Chirps can have at most %d media", maxChirpMedia), http.StatusBadRequest, nil)
+		return false
+	}
+	seen := make(map[uuid.UUID]bool, len(items))
+	for _, item := range items {
+		if seen[item.ID] {
+			respondWithError(resWriter, "Media can only be attached once", http.StatusBadRequest, nil)
+			return false
+		}
+		seen[item.ID] = true
+		if utf8.RuneCountInString(item.AltText) > maxAltTextLength {
+			respondWithError(resWriter, fmt.Sprintf("Alt text can be at most %d characters", maxAltTextLength), http.StatusBadRequest, nil)
+			return false
+		}
+	}
+	return true
+}
+
+// storeMedia attaches the caller's uploads to chirpID in the order given. Call it in the
+// transaction storing the chirp. It reports false if any of them isn't the caller's or is
+// already attached to another chirp.
+func storeMedia(ctx context.Context, qtx *database.Queries, userID, chirpID uuid.UUID, items []newMedia) ([]Media, bool, error) {
+	ids := make([]uuid.UUID, 0, len(items))
+	altTexts := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+		altTexts = append(altTexts, item.AltText)
+	}
+	attached, err := qtx.AttachMediaFiles(ctx, database.AttachMediaFilesParams{
+		ChirpID:  uuid.NullUUID{UUID: chirpID, Valid: true},
+		Ids:      ids,
+		AltTexts: altTexts,
+		UserID:   userID,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if attached != int64(len(items)) {
+		return nil, false, nil
+	}
+
+	rows, err := qtx.ListChirpMediaFiles(ctx, []uuid.UUID{chirpID})
+	if err != nil {
+		return nil, false, err
+	}
+	stored := make([]Media, 0, len(rows))
+	for _, row := range rows {
+		stored = append(stored, mediaFromDB(row))
+	}
+	return stored, true, nil
+}
+
+// attachMedia fills in the Media of every chirp in chirps.
+func (cfg *apiConfig) attachMedia(ctx context.Context, chirps []Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	rows, err := cfg.db.ListChirpMediaFiles(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byChirp := make(map[uuid.UUID][]Media)
+	for _, row := range rows {
+		byChirp[row.ChirpID.UUID] = append(byChirp[row.ChirpID.UUID], mediaFromDB(row))
+	}
+	for i := range chirps {
+		chirps[i].Media = byChirp[chirps[i].ID]
+	}
+	return nil
+}
+
+// pruneUnattachedMedia deletes uploads that were never attached to a chirp, or whose chirp was
+// deleted, along with their stored files.
+func (cfg *apiConfig) pruneUnattachedMedia(ctx context.Context) error {
+	stale, err := cfg.db.ListStaleMediaFiles(ctx, database.ListStaleMediaFilesParams{
+		CreatedAt: time.Now().UTC().Add(-unattachedMediaTTL),
+		Limit:     mediaPruneBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+	for _, dbMedia := range stale {
+		// Files go first, so a failure leaves the row to be tried again next time
+		if err := cfg.mediaStore.Delete(ctx, dbMedia.StorageKey); err != nil {
+			return err
+		}
+		if dbMedia.ThumbnailKey.Valid {
+			if err := cfg.mediaStore.Delete(ctx, dbMedia.ThumbnailKey.String); err != nil {
+				return err
+			}
+		}
+		if err := cfg.db.DeleteMediaFile(ctx, dbMedia.ID); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("pruned %d unattached media", len(stale))
+	}
+	return nil
+}
+
+// deleteStoredMedia cleans up the files of an upload that couldn't be recorded.
+func (cfg *apiConfig) deleteStoredMedia(ctx context.Context, storageKey string, thumbnailKey sql.NullString) {
+	if err := cfg.mediaStore.Delete(ctx, storageKey); err != nil {
+		log.Printf("issue deleting media %s: %v", storageKey, err)
+	}
+	if thumbnailKey.Valid {
+		if err := cfg.mediaStore.Delete(ctx, thumbnailKey.String); err != nil {
+			log.Printf("issue deleting media %s: %v", thumbnailKey.String, err)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxMessageLength     = 2000
+	maxConversationTitle = 100
+	maxGroupParticipants = 20
+	defaultMessagePage   = 50
+	maxMessagePage       = 200
+
+	dmThirdPartyForbidden = "Third-party apps can't access direct messages"
+)
+
+type Conversation struct {
+	ID             uuid.UUID   `json:"id"`
+	IsGroup        bool        `json:"is_group"`
+	Title          string      `json:"title,omitempty"`
+	CreatedBy      uuid.UUID   `json:"created_by"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	ParticipantIDs []uuid.UUID `json:"participant_ids"`
+	UnreadCount    int64       `json:"unread_count"`
+}
+
+type Message struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func messageFromDB(dbMessage database.Message) Message {
+	return Message{
+		ID:             dbMessage.ID,
+		ConversationID: dbMessage.ConversationID,
+		SenderID:       dbMessage.SenderID,
+		Body:           dbMessage.Body,
+		CreatedAt:      dbMessage.CreatedAt,
+	}
+}
+
+func conversationFromDB(dbConversation database.Conversation, participantIDs []uuid.UUID) Conversation {
+	return Conversation{
+		ID:             dbConversation.ID,
+		IsGroup:        dbConversation.IsGroup,
+		Title:          dbConversation.Title.String,
+		CreatedBy:      dbConversation.CreatedBy,
+		CreatedAt:      dbConversation.CreatedAt,
+		UpdatedAt:      dbConversation.UpdatedAt,
+		ParticipantIDs: participantIDs,
+	}
+}
+
+// handlerCreateConversation starts a conversation between the caller and participant_ids. With
+// one other participant it's a one-to-one conversation, and an existing one is returned rather
+// than starting a second; with more it's a group, which may have a title.
+func (cfg *apiConfig) handlerCreateConversation(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		ParticipantIDs []uuid.UUID `json:"participant_ids"`
+		Title          string      `json:"title"`
+	}
+
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, dmThirdPartyForbidden, http.StatusForbidden, nil)
+		return
+	}
+
+	convoInfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&convoInfo)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	others := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{accessToken.UserID: true}
+	for _, id := range convoInfo.ParticipantIDs {
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		respondWithError(resWriter, "A conversation needs at least one other participant", http.StatusBadRequest, nil)
+		return
+	}
+	if len(others)+1 > maxGroupParticipants {
+		respondWithError(resWriter, "Conversations can have at most "+strconv.Itoa(maxGroupParticipants)+" participants", http.StatusBadRequest, nil)
+		return
+	}
+	isGroup := len(others) > 1
+	title := strings.TrimSpace(convoInfo.Title)
+	if title != "" && !isGroup {
+		respondWithError(resWriter, "Only group conversations can have a title", http.StatusBadRequest, nil)
+		return
+	}
+	if len(title) > maxConversationTitle {
+		respondWithError(resWriter, "Conversation title is too long", http.StatusBadRequest, nil)
+		return
+	}
+
+	for _, id := range others {
+		_, err := cfg.db.GetUserByID(req.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(resWriter, "User not found", http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			respondWithError(resWriter, "issue finding user", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	blocked, err := cfg.db.IsBlockedBetween(req.Context(), database.IsBlockedBetweenParams{
+		UserID:   accessToken.UserID,
+		OtherIds: others,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue checking blocks", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		respondWithError(resWriter, "You can't message a user you have blocked or who has blocked you", http.StatusForbidden, nil)
+		return
+	}
+
+	participantIDs := append([]uuid.UUID{accessToken.UserID}, others...)
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if !isGroup {
+		// Holding the pair's lock until commit means two requests racing to start the same
+		// 1:1 conversation can't both miss the other's and create one each
+		pair := database.LockDirectConversationParams{UserID: accessToken.UserID, OtherID: others[0]}
+		if err := qtx.LockDirectConversation(req.Context(), pair); err != nil {
+			respondWithError(resWriter, "issue finding conversation", http.StatusInternalServerError, err)
+			return
+		}
+		dbConversation, err := qtx.FindDirectConversation(req.Context(), database.FindDirectConversationParams{
+			UserID:  accessToken.UserID,
+			OtherID: others[0],
+		})
+		if err == nil {
+			respondWithJson(resWriter, http.StatusOK, conversationFromDB(dbConversation, participantIDs))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(resWriter, "issue finding conversation", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	dbConversation, err := qtx.CreateConversation(req.Context(), database.CreateConversationParams{
+		IsGroup:   isGroup,
+		Title:     sql.NullString{String: title, Valid: title != ""},
+		CreatedBy: accessToken.UserID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue creating conversation", http.StatusInternalServerError, err)
+		return
+	}
+	for _, id := range participantIDs {
+		err := qtx.AddConversationParticipant(req.Context(), database.AddConversationParticipantParams{
+			ConversationID: dbConversation.ID,
+			UserID:         id,
+		})
+		if err != nil {
+			respondWithError(resWriter, "issue adding conversation participant", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue creating conversation", http.StatusInternalServerError, err)
+		return
+	}
+
+	respondWithJson(resWriter, http.StatusCreated, conversationFromDB(dbConversation, participantIDs))
+}
+
+// handlerListConversations lists the caller's conversations, most recently active first, with
+// how many messages in each they haven't read.
+func (cfg *apiConfig) handlerListConversations(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, dmThirdPartyForbidden, http.StatusForbidden, nil)
+		return
+	}
+	limit, ok := pageLimit(resWriter, req)
+	if !ok {
+		return
+	}
+
+	dbConversations, err := cfg.db.ListConversationsForUser(req.Context(), database.ListConversationsForUserParams{
+		UserID: accessToken.UserID,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing conversations", http.StatusInternalServerError, err)
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(dbConversations))
+	for _, dbConversation := range dbConversations {
+		ids = append(ids, dbConversation.ID)
+	}
+	dbParticipants, err := cfg.db.ListConversationParticipants(req.Context(), ids)
+	if err != nil {
+		respondWithError(resWriter, "issue listing conversation participants", http.StatusInternalServerError, err)
+		return
+	}
+	participants := map[uuid.UUID][]uuid.UUID{}
+	for _, dbParticipant := range dbParticipants {
+		participants[dbParticipant.ConversationID] = append(participants[dbParticipant.ConversationID], dbParticipant.UserID)
+	}
+
+	conversations := make([]Conversation, 0, len(dbConversations))
+	for _, row := range dbConversations {
+		conversation := conversationFromDB(database.Conversation{
+			ID:        row.ID,
+			IsGroup:   row.IsGroup,
+			Title:     row.Title,
+			CreatedBy: row.CreatedBy,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+		}, participants[row.ID])
+		conversation.UnreadCount = row.UnreadCount
+		conversations = append(conversations, conversation)
+	}
+	respondWithJson(resWriter, http.StatusOK, conversations)
+}
+
+// handlerUnreadMessages totals the caller's unread messages across conversations.
+func (cfg *apiConfig) handlerUnreadMessages(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, dmThirdPartyForbidden, http.StatusForbidden, nil)
+		return
+	}
+
+	counts, err := cfg.db.CountUnreadMessages(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue counting unread messages", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, struct {
+		UnreadMessages      int64 `json:"unread_messages"`
+		UnreadConversations int64 `json:"unread_conversations"`
+	}{
+		UnreadMessages:      counts.UnreadMessages,
+		UnreadConversations: counts.UnreadConversations,
+	})
+}
+
+// handlerListMessages returns a page of messages, newest first. Pass the last message's id as
+// ?before= to get the page after it.
+func (cfg *apiConfig) handlerListMessages(resWriter http.ResponseWriter, req *http.Request) {
+	dbConversation, ok := cfg.conversationFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	limit, ok := pageLimit(resWriter, req)
+	if !ok {
+		return
+	}
+	before := uuid.NullUUID{}
+	if raw := req.URL.Query().Get("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			respondWithError(resWriter, "before is not a valid message id", http.StatusBadRequest, nil)
+			return
+		}
+		before = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	dbMessages, err := cfg.db.ListMessages(req.Context(), database.ListMessagesParams{
+		ConversationID: dbConversation.ID,
+		BeforeID:       before,
+		MaxResults:     int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing messages", http.StatusInternalServerError, err)
+		return
+	}
+	messages := make([]Message, 0, len(dbMessages))
+	for _, dbMessage := range dbMessages {
+		messages = append(messages, messageFromDB(dbMessage))
+	}
+	respondWithJson(resWriter, http.StatusOK, messages)
+}
+
+// handlerSendMessage posts a message to a conversation and pushes it to realtime subscribers.
+// Nobody can send to a conversation that includes someone they've blocked or who blocked them.
+func (cfg *apiConfig) handlerSendMessage(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Body string `json:"body"`
+	}
+
+	accessToken := accessTokenFrom(req.Context())
+	dbConversation, ok := cfg.conversationFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	msgInfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&msgInfo)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	body := strings.TrimSpace(msgInfo.Body)
+	if body == "" {
+		respondWithError(resWriter, "Message can't be empty", http.StatusBadRequest, nil)
+		return
+	}
+	if len(body) > maxMessageLength {
+		respondWithError(resWriter, "Message is too long", http.StatusBadRequest, nil)
+		return
+	}
+
+	dbParticipants, err := cfg.db.ListConversationParticipants(req.Context(), []uuid.UUID{dbConversation.ID})
+	if err != nil {
+		respondWithError(resWriter, "issue listing conversation participants", http.StatusInternalServerError, err)
+		return
+	}
+	others := []uuid.UUID{}
+	for _, dbParticipant := range dbParticipants {
+		if dbParticipant.UserID != accessToken.UserID {
+			others = append(others, dbParticipant.UserID)
+		}
+	}
+	blocked, err := cfg.db.IsBlockedBetween(req.Context(), database.IsBlockedBetweenParams{
+		UserID:   accessToken.UserID,
+		OtherIds: others,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue checking blocks", http.StatusInternalServerError, err)
+		return
+	}
+	if blocked {
+		respondWithError(resWriter, "You can't message a user you have blocked or who has blocked you", http.StatusForbidden, nil)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	dbMessage, err := qtx.CreateMessage(req.Context(), database.CreateMessageParams{
+		ConversationID: dbConversation.ID,
+		SenderID:       accessToken.UserID,
+		Body:           body,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue sending message", http.StatusInternalServerError, err)
+		return
+	}
+	if err := qtx.TouchConversation(req.Context(), dbConversation.ID); err != nil {
+		respondWithError(resWriter, "issue updating conversation", http.StatusInternalServerError, err)
+		return
+	}
+	// The sender has read everything up to their own message
+	_, err = qtx.MarkConversationRead(req.Context(), database.MarkConversationReadParams{
+		ConversationID: dbConversation.ID,
+		UserID:         accessToken.UserID,
+		MessageID:      dbMessage.ID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue updating read state", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue sending message", http.StatusInternalServerError, err)
+		return
+	}
+
+	message := messageFromDB(dbMessage)
+	cfg.publishRealtime(req.Context(), conversationTopic(dbConversation.ID), realtimeEventMessage, uuid.Nil, message)
+	respondWithJson(resWriter, http.StatusCreated, message)
+}
+
+// handlerMarkConversationRead marks the conversation read by the caller up to and including
+// {"message_id": id}, the newest message they've received. Naming the message rather than
+// marking everything up to now keeps a message that was still being sent from counting as
+// read unseen. The read mark never moves back.
+func (cfg *apiConfig) handlerMarkConversationRead(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		MessageID uuid.UUID `json:"message_id"`
+	}
+
+	accessToken := accessTokenFrom(req.Context())
+	dbConversation, ok := cfg.conversationFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	target := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&target)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if target.MessageID == uuid.Nil {
+		respondWithError(resWriter, "message_id of the newest message read is required", http.StatusBadRequest, nil)
+		return
+	}
+
+	marked, err := cfg.db.MarkConversationRead(req.Context(), database.MarkConversationReadParams{
+		ConversationID: dbConversation.ID,
+		UserID:         accessToken.UserID,
+		MessageID:      target.MessageID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue updating read state", http.StatusInternalServerError, err)
+		return
+	}
+	if marked == 0 {
+		respondWithError(resWriter, "No message found in this conversation", http.StatusNotFound, nil)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// conversationFromPath loads the conversation in the path, making sure the caller is one of
+// its participants.
+func (cfg *apiConfig) conversationFromPath(resWriter http.ResponseWriter, req *http.Request) (database.Conversation, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, dmThirdPartyForbidden, http.StatusForbidden, nil)
+		return database.Conversation{}, false
+	}
+
+	conversationID, err := uuid.Parse(req.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(resWriter, "conversation id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.Conversation{}, false
+	}
+	dbConversation, err := cfg.db.GetConversation(req.Context(), conversationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Conversation not found", http.StatusNotFound, nil)
+		return database.Conversation{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding conversation", http.StatusInternalServerError, err)
+		return database.Conversation{}, false
+	}
+	isParticipant, err := cfg.db.IsConversationParticipant(req.Context(), database.IsConversationParticipantParams{
+		ConversationID: conversationID,
+		UserID:         accessToken.UserID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue checking conversation participants", http.StatusInternalServerError, err)
+		return database.Conversation{}, false
+	}
+	if !isParticipant {
+		respondWithError(resWriter, "You are not a participant in this conversation", http.StatusForbidden, nil)
+		return database.Conversation{}, false
+	}
+	return dbConversation, true
+}
+
+// pageLimit reads ?limit=, defaulting to defaultMessagePage.
+func pageLimit(resWriter http.ResponseWriter, req *http.Request) (int, bool) {
+	limit := defaultMessagePage
+	if raw := req.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > maxMessagePage {
+			respondWithError(resWriter, "limit must be between 1 and "+strconv.Itoa(maxMessagePage), http.StatusBadRequest, nil)
+			return 0, false
+		}
+		limit = v
+	}
+	return limit, true
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/notify"
+	"github.com/google/uuid"
+)
+
+// maxGroupActors caps how many of a group's actors are listed; Count still has them all.
+const maxGroupActors = 5
+
+type NotificationGroup struct {
+	Type     string      `json:"type"`
+	ChirpID  *uuid.UUID  `json:"chirp_id,omitempty"`
+	Count    int64       `json:"count"`
+	Summary  string      `json:"summary"`
+	ActorIDs []uuid.UUID `json:"actor_ids"`
+	IDs      []uuid.UUID `json:"ids"`
+	Unread   bool        `json:"unread"`
+	LatestAt time.Time   `json:"latest_at"`
+}
+
+// notify records a notification for recipient unless it's their own action or they've turned
+// that type off. Notifications are best effort: the action they describe has already happened,
+// so failures are logged rather than failing the request.
+func (cfg *apiConfig) notify(ctx context.Context, recipient uuid.UUID, notifType string, actor uuid.UUID, chirpID uuid.UUID) {
+	err := cfg.db.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:  recipient,
+		Type:    notifType,
+		ActorID: actor,
+		ChirpID: uuid.NullUUID{UUID: chirpID, Valid: chirpID != uuid.Nil},
+	})
+	if err != nil {
+		log.Printf("issue creating %v notification for %v: %v", notifType, recipient, err)
+	}
+}
+
+// unnotify withdraws an unread notification when its action is undone, such as an unlike.
+func (cfg *apiConfig) unnotify(ctx context.Context, recipient uuid.UUID, notifType string, actor uuid.UUID, chirpID uuid.UUID) {
+	err := cfg.db.DeleteUnreadNotification(ctx, database.DeleteUnreadNotificationParams{
+		UserID:  recipient,
+		Type:    notifType,
+		ActorID: actor,
+		ChirpID: uuid.NullUUID{UUID: chirpID, Valid: chirpID != uuid.Nil},
+	})
+	if err != nil {
+		log.Printf("issue removing %v notification for %v: %v", notifType, recipient, err)
+	}
+}
+
+// notifyChirpCreated tells the author of the chirp being replied to, if any, and everyone
+// mentioned in the new chirp.
+func (cfg *apiConfig) notifyChirpCreated(ctx context.Context, dbChirp, parent database.Chirp, mentioned []uuid.UUID) {
+	author := dbChirp.UserID.UUID
+	if parent.UserID.Valid {
+		cfg.notify(ctx, parent.UserID.UUID, notify.TypeReply, author, dbChirp.ID)
+	}
+	for _, userID := range mentioned {
+		cfg.notify(ctx, userID, notify.TypeMention, author, dbChirp.ID)
+	}
+}
+
+// handlerListNotifications returns the caller's notifications, newest first, with those of the
+// notify.GroupedTypes collapsed into one entry per chirp. ?unread=true leaves out read ones.
+func (cfg *apiConfig) handlerListNotifications(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := notificationsToken(resWriter, req)
+	if !ok {
+		return
+	}
+	limit, ok := pageLimit(resWriter, req)
+	if !ok {
+		return
+	}
+
+	dbGroups, err := cfg.db.ListNotificationGroups(req.Context(), database.ListNotificationGroupsParams{
+		UserID:       accessToken.UserID,
+		UnreadOnly:   req.URL.Query().Get("unread") == "true",
+		GroupedTypes: notify.GroupedTypes,
+		MaxResults:   int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing notifications", http.StatusInternalServerError, err)
+		return
+	}
+
+	groups := make([]NotificationGroup, 0, len(dbGroups))
+	for _, dbGroup := range dbGroups {
+		group := NotificationGroup{
+			Type:     dbGroup.Type,
+			Count:    dbGroup.Count,
+			Summary:  notify.Summary(dbGroup.Type, int(dbGroup.Count)),
+			ActorIDs: dbGroup.ActorIds,
+			IDs:      dbGroup.Ids,
+			Unread:   dbGroup.Unread,
+			LatestAt: dbGroup.LatestAt,
+		}
+		if len(group.ActorIDs) > maxGroupActors {
+			group.ActorIDs = group.ActorIDs[:maxGroupActors]
+		}
+		if dbGroup.ChirpID.Valid {
+			group.ChirpID = &dbGroup.ChirpID.UUID
+		}
+		groups = append(groups, group)
+	}
+	respondWithJson(resWriter, http.StatusOK, groups)
+}
+
+func (cfg *apiConfig) handlerUnreadNotifications(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := notificationsToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.db.CountUnreadNotifications(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue counting notifications", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, struct {
+		UnreadCount int64 `json:"unread_count"`
+	}{
+		UnreadCount: count,
+	})
+}
+
+// handlerMarkNotificationsRead marks the notifications in {"ids": [...]} as read, or all of
+// them when no ids are given.
+func (cfg *apiConfig) handlerMarkNotificationsRead(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+
+	accessToken, ok := notificationsToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	target := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&target)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	marked, err := cfg.db.MarkNotificationsRead(req.Context(), database.MarkNotificationsReadParams{
+		UserID: accessToken.UserID,
+		Ids:    target.IDs,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue marking notifications read", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, struct {
+		MarkedRead int64 `json:"marked_read"`
+	}{
+		MarkedRead: marked,
+	})
+}
+
+// handlerGetNotificationPreferences returns whether each notification type is on. Types the
+// user never changed are on.
+func (cfg *apiConfig) handlerGetNotificationPreferences(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := notificationsToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	prefs, err := cfg.notificationPreferences(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue loading notification preferences", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, prefs)
+}
+
+// handlerUpdateNotificationPreferences turns types on or off with a body like
+// {"like": false}. Types left out keep their current setting.
+func (cfg *apiConfig) handlerUpdateNotificationPreferences(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken, ok := notificationsToken(resWriter, req)
+	if !ok {
+		return
+	}
+
+	changes := map[string]bool{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&changes)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	for notifType := range changes {
+		if !notify.ValidType(notifType) {
+			respondWithError(resWriter, "Unknown notification type: "+notifType, http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+	for notifType, enabled := range changes {
+		err := qtx.SetNotificationPreference(req.Context(), database.SetNotificationPreferenceParams{
+			UserID:  accessToken.UserID,
+			Type:    notifType,
+			Enabled: enabled,
+		})
+		if err != nil {
+			respondWithError(resWriter, "issue saving notification preferences", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue saving notification preferences", http.StatusInternalServerError, err)
+		return
+	}
+
+	prefs, err := cfg.notificationPreferences(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue loading notification preferences", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, prefs)
+}
+
+func (cfg *apiConfig) notificationPreferences(ctx context.Context, userID uuid.UUID) (map[string]bool, error) {
+	rows, err := cfg.db.ListNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs := map[string]bool{}
+	for _, notifType := range notify.Types {
+		prefs[notifType] = true
+	}
+	for _, row := range rows {
+		prefs[row.Type] = row.Enabled
+	}
+	return prefs, nil
+}
+
+// notificationsToken returns the caller's token if it may use the notifications center.
+func notificationsToken(resWriter http.ResponseWriter, req *http.Request) (auth.AccessToken, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't access notifications", http.StatusForbidden, nil)
+		return auth.AccessToken{}, false
+	}
+	return accessToken, true
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const oauthCodeTTL = 10 * time.Minute
+
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Secret       string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handlerCreateOAuthClient(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't register clients", http.StatusForbidden, nil)
+		return
+	}
+
+	type incoming struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	clientInfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&clientInfo)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if clientInfo.Name == "" || len(clientInfo.RedirectURIs) == 0 {
+		respondWithError(resWriter, "A name and at least one redirect uri are required", http.StatusBadRequest, nil)
+		return
+	}
+	for _, uri := range clientInfo.RedirectURIs {
+		if err := oauth.ValidateRedirectURI(uri); err != nil {
+			respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	// Public clients such as mobile apps can't keep a secret and rely on PKCE alone
+	secret := ""
+	secretHash := sql.NullString{}
+	if clientInfo.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(resWriter, "Issue generating client secret", http.StatusInternalServerError, err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := cfg.db.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           uuid.New().String(),
+		SecretHash:   secretHash,
+		Name:         clientInfo.Name,
+		RedirectUris: clientInfo.RedirectURIs,
+		UserID:       accessToken.UserID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "Couldn't register client", http.StatusInternalServerError, err)
+		return
+	}
+
+	respondWithJson(resWriter, http.StatusCreated, OAuthClient{
+		ID:           dbClient.ID,
+		Secret:       secret,
+		Name:         dbClient.Name,
+		RedirectURIs: dbClient.RedirectUris,
+		CreatedAt:    dbClient.CreatedAt,
+	})
+}
+
+// authorizeRequest holds the validated parameters shared by GET and POST /oauth/authorize.
+type authorizeRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+// parseAuthorizeRequest validates an authorization request. If the client or redirect uri can't be
+// trusted it shows an error page instead of redirecting; any other problem is sent back to the
+// client's redirect uri as RFC 6749 requires. It reports whether the caller should continue.
+func (cfg *apiConfig) parseAuthorizeRequest(resWriter http.ResponseWriter, req *http.Request, params url.Values) (authorizeRequest, bool) {
+	dbClient, err := cfg.db.GetOAuthClient(req.Context(), params.Get("client_id"))
+	if err != nil {
+		respondWithError(resWriter, "Unknown client", http.StatusBadRequest, err)
+		return authorizeRequest{}, false
+	}
+	redirectURI := params.Get("redirect_uri")
+	if !slices.Contains(dbClient.RedirectUris, redirectURI) {
+		respondWithError(resWriter, "Redirect uri is not registered for this client", http.StatusBadRequest, nil)
+		return authorizeRequest{}, false
+	}
+
+	authReq := authorizeRequest{
+		client:        dbClient,
+		redirectURI:   redirectURI,
+		state:         params.Get("state"),
+		codeChallenge: params.Get("code_challenge"),
+	}
+	if params.Get("response_type") != "code" {
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrUnsupportedResponse, "only the code response type is supported")
+		return authorizeRequest{}, false
+	}
+	if params.Get("code_challenge_method") != "S256" || !oauth.ValidCodeChallenge(authReq.codeChallenge) {
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrInvalidRequest, "a PKCE code_challenge using S256 is required")
+		return authorizeRequest{}, false
+	}
+	authReq.scopes, err = oauth.ParseScope(params.Get("scope"))
+	if err != nil {
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrInvalidScope, err.Error())
+		return authorizeRequest{}, false
+	}
+	return authReq, true
+}
+
+func redirectOAuthError(resWriter http.ResponseWriter, req *http.Request, authReq authorizeRequest, code, description string) {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if authReq.state != "" {
+		params.Set("state", authReq.state)
+	}
+	target, err := oauth.RedirectWith(authReq.redirectURI, params)
+	if err != nil {
+		respondWithError(resWriter, "Invalid redirect uri", http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(resWriter, req, target, http.StatusFound)
+}
+
+func renderConsent(resWriter http.ResponseWriter, authReq authorizeRequest, code int, errMsg string) {
+	descriptions := make([]string, 0, len(authReq.scopes))
+	for _, scope := range authReq.scopes {
+		descriptions = append(descriptions, oauth.Scopes[scope])
+	}
+
+	resWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resWriter.Header().Set("Cache-Control", "no-store")
+	resWriter.Header().Set("X-Frame-Options", "DENY") // stop other sites framing the consent screen
+	resWriter.WriteHeader(code)
+	err := oauth.RenderConsent(resWriter, oauth.ConsentPage{
+		ClientName:          authReq.client.Name,
+		ClientID:            authReq.client.ID,
+		RedirectURI:         authReq.redirectURI,
+		Scope:               strings.Join(authReq.scopes, " "),
+		State:               authReq.state,
+		CodeChallenge:       authReq.codeChallenge,
+		CodeChallengeMethod: "S256",
+		ScopeDescriptions:   descriptions,
+		Error:               errMsg,
+	})
+	if err != nil {
+		log.Printf("issue rendering consent page: %v", err)
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(resWriter http.ResponseWriter, req *http.Request) {
+	authReq, ok := cfg.parseAuthorizeRequest(resWriter, req, req.URL.Query())
+	if !ok {
+		return
+	}
+	renderConsent(resWriter, authReq, http.StatusOK, "")
+}
+
+func (cfg *apiConfig) handlerOAuthConsent(resWriter http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithError(resWriter, "Invalid form", http.StatusBadRequest, err)
+		return
+	}
+	authReq, ok := cfg.parseAuthorizeRequest(resWriter, req, req.PostForm)
+	if !ok {
+		return
+	}
+	if req.PostForm.Get("action") != "approve" {
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrAccessDenied, "the user denied the request")
+		return
+	}
+
+	dbUser, err := cfg.checkCredentials(req.Context(), req.PostForm.Get("email"), req.PostForm.Get("password"), clientIP(req))
+	var locked *lockedOutError
+	if errors.As(err, &locked) {
+		renderConsent(resWriter, authReq, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+		return
+	}
+	if err != nil {
+		renderConsent(resWriter, authReq, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrServerError, "issue generating code")
+		return
+	}
+	err = cfg.db.CreateOAuthCode(req.Context(), database.CreateOAuthCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      authReq.client.ID,
+		UserID:        dbUser.ID,
+		RedirectUri:   authReq.redirectURI,
+		Scope:         strings.Join(authReq.scopes, " "),
+		CodeChallenge: authReq.codeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		log.Printf("issue storing oauth code: %v", err)
+		redirectOAuthError(resWriter, req, authReq, oauth.ErrServerError, "issue storing code")
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	if authReq.state != "" {
+		params.Set("state", authReq.state)
+	}
+	target, err := oauth.RedirectWith(authReq.redirectURI, params)
+	if err != nil {
+		respondWithError(resWriter, "Invalid redirect uri", http.StatusBadRequest, err)
+		return
+	}
+	http.Redirect(resWriter, req, target, http.StatusFound)
+}
+
+func respondWithOAuthError(resWriter http.ResponseWriter, code int, errCode, description string) {
+	resWriter.Header().Set("Cache-Control", "no-store")
+	respondWithJson(resWriter, code, struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}{
+		Error:       errCode,
+		Description: description,
+	})
+}
+
+// authenticateOAuthClient identifies the client calling the token endpoint through HTTP Basic
+// auth or form fields. Confidential clients must present their secret.
+func (cfg *apiConfig) authenticateOAuthClient(req *http.Request) (database.OauthClient, bool) {
+	clientID, secret, hasBasic := req.BasicAuth()
+	if !hasBasic {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+	dbClient, err := cfg.db.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+	if dbClient.SecretHash.Valid {
+		got := auth.HashToken(secret)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(dbClient.SecretHash.String)) != 1 {
+			return database.OauthClient{}, false
+		}
+	}
+	return dbClient, true
+}
+
+func (cfg *apiConfig) handlerOAuthToken(resWriter http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrInvalidRequest, "invalid form body")
+		return
+	}
+	dbClient, ok := cfg.authenticateOAuthClient(req)
+	if !ok {
+		respondWithOAuthError(resWriter, http.StatusUnauthorized, oauth.ErrInvalidClient, "client authentication failed")
+		return
+	}
+
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeOAuthCode(resWriter, req, dbClient)
+	case "refresh_token":
+		cfg.refreshOAuthToken(resWriter, req, dbClient)
+	default:
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrUnsupportedGrantType, "")
+	}
+}
+
+func (cfg *apiConfig) exchangeOAuthCode(resWriter http.ResponseWriter, req *http.Request, dbClient database.OauthClient) {
+	dbCode, err := cfg.db.UseOAuthCode(req.Context(), database.UseOAuthCodeParams{
+		CodeHash: auth.HashToken(req.PostForm.Get("code")),
+		Now:      time.Now().UTC(),
+	})
+	if err != nil {
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrInvalidGrant, "code invalid, expired or already used")
+		return
+	}
+	if dbCode.ClientID != dbClient.ID || dbCode.RedirectUri != req.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrInvalidGrant, "code was issued to another client or redirect uri")
+		return
+	}
+	if !oauth.VerifyPKCE(req.PostForm.Get("code_verifier"), dbCode.CodeChallenge) {
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrInvalidGrant, "code verifier does not match challenge")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(resWriter, req, dbClient, dbCode.UserID, strings.Fields(dbCode.Scope))
+}
+
+func (cfg *apiConfig) refreshOAuthToken(resWriter http.ResponseWriter, req *http.Request, dbClient database.OauthClient) {
+	// Rotate the refresh token so a leaked one stops working after its next use. Checking and
+	// revoking it is one update, so of two concurrent refreshes with the same token only one
+	// gets new tokens
+	dbRefToken, err := cfg.db.ClaimRefreshToken(req.Context(), database.ClaimRefreshTokenParams{
+		Token:    req.PostForm.Get("refresh_token"),
+		ClientID: dbClient.ID,
+		Now:      time.Now().UTC(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithOAuthError(resWriter, http.StatusBadRequest, oauth.ErrInvalidGrant, "refresh token not valid, expired or revoked")
+		return
+	}
+	if err != nil {
+		log.Printf("issue claiming refresh token: %v", err)
+		respondWithOAuthError(resWriter, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(resWriter, req, dbClient, dbRefToken.UserID, strings.Fields(dbRefToken.Scope.String))
+}
+
+func (cfg *apiConfig) respondWithOAuthTokens(resWriter http.ResponseWriter, req *http.Request, dbClient database.OauthClient, userID uuid.UUID, scopes []string) {
+	accessToken, err := auth.MakeScopedJWT(userID, cfg.secret, dbClient.ID, scopes)
+	if err != nil {
+		log.Printf("issue generating scoped token: %v", err)
+		respondWithOAuthError(resWriter, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+	refreshString, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("issue generating refresh token: %v", err)
+		respondWithOAuthError(resWriter, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+	later := time.Now().UTC().Add(refreshTokenTTL)
+	_, err = cfg.db.StoreRefreshToken(req.Context(), database.StoreRefreshTokenParams{
+		Token:     refreshString,
+		UserID:    userID,
+		ExpiresAt: sql.NullTime{Time: later, Valid: true},
+		ClientID:  sql.NullString{String: dbClient.ID, Valid: true},
+		Scope:     sql.NullString{String: strings.Join(scopes, " "), Valid: true},
+	})
+	if err != nil {
+		log.Printf("issue storing refresh token: %v", err)
+		respondWithOAuthError(resWriter, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+
+	resWriter.Header().Set("Cache-Control", "no-store")
+	respondWithJson(resWriter, http.StatusOK, struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    3600,
+		RefreshToken: refreshString,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/cbrookscode/chirpy/internal/outbound"
+	"github.com/google/uuid"
+)
+
+// Statuses of a row in webhook_outbox.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const (
+	// webhookBatchSize is how many due deliveries one worker pass claims.
+	webhookBatchSize = 20
+	// webhookLease hides claimed deliveries from other instances while they're being sent. If
+	// this one dies mid-send they become due again once it runs out.
+	webhookLease = time.Minute
+)
+
+type WebhookSubscription struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	ClientID   string    `json:"client_id,omitempty"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID                `json:"id"`
+	EventID       uuid.UUID                `json:"event_id"`
+	EventType     string                   `json:"event_type"`
+	Status        string                   `json:"status"`
+	Attempts      int32                    `json:"attempts"`
+	NextAttemptAt *time.Time               `json:"next_attempt_at"`
+	LastError     string                   `json:"last_error,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	DeliveredAt   *time.Time               `json:"delivered_at"`
+	Payload       json.RawMessage          `json:"payload"`
+	AttemptLog    []WebhookDeliveryAttempt `json:"attempt_log"`
+}
+
+type WebhookDeliveryAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int32     `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int32     `json:"duration_ms"`
+}
+
+func webhookSubscriptionFrom(dbSub database.WebhookSubscription) WebhookSubscription {
+	return WebhookSubscription{
+		ID:         dbSub.ID,
+		URL:        dbSub.Url,
+		EventTypes: dbSub.EventTypes,
+		ClientID:   dbSub.ClientID.String,
+		CreatedAt:  dbSub.CreatedAt,
+		UpdatedAt:  dbSub.UpdatedAt,
+	}
+}
+
+// enqueueWebhookEvent writes an event to the outbox for each of userID's subscriptions that
+// wants it. Pass the transaction making the change, so only committed changes are announced.
+func enqueueWebhookEvent(ctx context.Context, qtx *database.Queries, userID uuid.UUID, eventType string, data any) error {
+	eventID := uuid.New()
+	payload, err := outbound.Event{
+		ID:        eventID.String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = qtx.EnqueueWebhookEvent(ctx, database.EnqueueWebhookEventParams{
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		UserID:    userID,
+	})
+	return err
+}
+
+// deliverWebhooks claims the deliveries that are due and sends them. Claiming uses SKIP LOCKED,
+// so several instances can run it at once without sending anything twice.
+func (cfg *apiConfig) deliverWebhooks(ctx context.Context) error {
+	now := time.Now().UTC()
+	due, err := cfg.db.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil: now.Add(webhookLease),
+		Now:        now,
+		MaxResults: webhookBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cfg.deliverWebhook(ctx, delivery); err != nil {
+				log.Printf("issue recording webhook delivery %v: %v", delivery.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliverWebhook makes one attempt at a claimed delivery, logs it, and either marks it
+// delivered, schedules the next try, or dead-letters it after outbound.MaxAttempts.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, delivery database.ClaimDueWebhookDeliveriesRow) error {
+	result := cfg.webhookSender.Send(ctx, outbound.Delivery{
+		ID:        delivery.ID.String(),
+		URL:       delivery.Url,
+		Secret:    delivery.Secret,
+		EventType: delivery.EventType,
+		Payload:   delivery.Payload,
+	})
+
+	lastError := sql.NullString{}
+	if result.Err != nil {
+		lastError = sql.NullString{String: result.Err.Error(), Valid: true}
+	}
+	err := cfg.db.RecordWebhookDeliveryAttempt(ctx, database.RecordWebhookDeliveryAttemptParams{
+		OutboxID:   delivery.ID,
+		StatusCode: sql.NullInt32{Int32: int32(result.StatusCode), Valid: result.StatusCode != 0},
+		Error:      lastError,
+		DurationMs: int32(result.Duration.Milliseconds()),
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.OK() {
+		return cfg.db.MarkWebhookDelivered(ctx, delivery.ID)
+	}
+
+	attempts := int(delivery.Attempts) + 1
+	status := deliveryPending
+	if attempts >= outbound.MaxAttempts {
+		status = deliveryDead
+		log.Printf("dead-lettered webhook delivery %v after %d attempts: %v", delivery.ID, attempts, result.Err)
+	}
+	return cfg.db.MarkWebhookDeliveryFailed(ctx, database.MarkWebhookDeliveryFailedParams{
+		ID:            delivery.ID,
+		Status:        status,
+		LastError:     lastError,
+		NextAttemptAt: time.Now().UTC().Add(outbound.Backoff(attempts)),
+	})
+}
+
+func (cfg *apiConfig) handlerCreateWebhook(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeWebhooks) {
+		respondWithError(resWriter, "Token does not allow managing webhooks", http.StatusForbidden, nil)
+		return
+	}
+
+	type incoming struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+	}
+
+	hookInfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&hookInfo)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if err := outbound.ValidateURL(hookInfo.URL); err != nil {
+		respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := outbound.ValidateEvents(hookInfo.EventTypes); err != nil {
+		respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	secret, err := outbound.NewSecret()
+	if err != nil {
+		respondWithError(resWriter, "Issue generating webhook secret", http.StatusInternalServerError, err)
+		return
+	}
+	dbSub, err := cfg.db.CreateWebhookSubscription(req.Context(), database.CreateWebhookSubscriptionParams{
+		UserID:     accessToken.UserID,
+		ClientID:   sql.NullString{String: accessToken.ClientID, Valid: accessToken.ClientID != ""},
+		Url:        hookInfo.URL,
+		Secret:     secret,
+		EventTypes: hookInfo.EventTypes,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue creating webhook", http.StatusInternalServerError, err)
+		return
+	}
+
+	// The secret is only shown once; receivers need it to verify signatures
+	payload := webhookSubscriptionFrom(dbSub)
+	payload.Secret = dbSub.Secret
+	respondWithJson(resWriter, http.StatusCreated, payload)
+}
+
+func (cfg *apiConfig) handlerListWebhooks(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeWebhooks) {
+		respondWithError(resWriter, "Token does not allow managing webhooks", http.StatusForbidden, nil)
+		return
+	}
+
+	dbSubs, err := cfg.db.ListWebhookSubscriptionsByUser(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue listing webhooks", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Apps only see the webhooks they registered, while the user sees all of them
+	hooks := []WebhookSubscription{}
+	for _, dbSub := range dbSubs {
+		if !accessToken.FirstParty() && dbSub.ClientID.String != accessToken.ClientID {
+			continue
+		}
+		hooks = append(hooks, webhookSubscriptionFrom(dbSub))
+	}
+	respondWithJson(resWriter, http.StatusOK, hooks)
+}
+
+func (cfg *apiConfig) handlerDeleteWebhook(resWriter http.ResponseWriter, req *http.Request) {
+	dbSub, ok := cfg.ownedWebhookFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteWebhookSubscription(req.Context(), dbSub.ID)
+	if err != nil {
+		respondWithError(resWriter, "issue deleting webhook", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+func (cfg *apiConfig) handlerListWebhookDeliveries(resWriter http.ResponseWriter, req *http.Request) {
+	dbSub, ok := cfg.ownedWebhookFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if raw := req.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > 200 {
+			respondWithError(resWriter, "limit must be between 1 and 200", http.StatusBadRequest, nil)
+			return
+		}
+		limit = v
+	}
+
+	dbDeliveries, err := cfg.db.ListWebhookDeliveries(req.Context(), database.ListWebhookDeliveriesParams{
+		SubscriptionID: dbSub.ID,
+		Status:         req.URL.Query().Get("status"),
+		MaxResults:     int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing webhook deliveries", http.StatusInternalServerError, err)
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(dbDeliveries))
+	for _, dbDelivery := range dbDeliveries {
+		ids = append(ids, dbDelivery.ID)
+	}
+	dbAttempts, err := cfg.db.ListWebhookDeliveryAttempts(req.Context(), ids)
+	if err != nil {
+		respondWithError(resWriter, "issue listing webhook delivery attempts", http.StatusInternalServerError, err)
+		return
+	}
+	attempts := map[uuid.UUID][]WebhookDeliveryAttempt{}
+	for _, dbAttempt := range dbAttempts {
+		attempts[dbAttempt.OutboxID] = append(attempts[dbAttempt.OutboxID], WebhookDeliveryAttempt{
+			AttemptedAt: dbAttempt.AttemptedAt,
+			StatusCode:  dbAttempt.StatusCode.Int32,
+			Error:       dbAttempt.Error.String,
+			DurationMs:  dbAttempt.DurationMs,
+		})
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, dbDelivery := range dbDeliveries {
+		delivery := WebhookDelivery{
+			ID:         dbDelivery.ID,
+			EventID:    dbDelivery.EventID,
+			EventType:  dbDelivery.EventType,
+			Status:     dbDelivery.Status,
+			Attempts:   dbDelivery.Attempts,
+			LastError:  dbDelivery.LastError.String,
+			CreatedAt:  dbDelivery.CreatedAt,
+			Payload:    dbDelivery.Payload,
+			AttemptLog: attempts[dbDelivery.ID],
+		}
+		if delivery.AttemptLog == nil {
+			delivery.AttemptLog = []WebhookDeliveryAttempt{}
+		}
+		if dbDelivery.Status == deliveryPending {
+			delivery.NextAttemptAt = &dbDelivery.NextAttemptAt
+		}
+		if dbDelivery.DeliveredAt.Valid {
+			delivery.DeliveredAt = &dbDelivery.DeliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	respondWithJson(resWriter, http.StatusOK, deliveries)
+}
+
+// handlerRetryWebhookDelivery puts a dead-lettered delivery back in the queue with a fresh set
+// of attempts, for once the receiver has been fixed.
+func (cfg *apiConfig) handlerRetryWebhookDelivery(resWriter http.ResponseWriter, req *http.Request) {
+	dbSub, ok := cfg.ownedWebhookFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(req.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(resWriter, "delivery id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+
+	requeued, err := cfg.db.RequeueWebhookDelivery(req.Context(), database.RequeueWebhookDeliveryParams{
+		ID:             deliveryID,
+		SubscriptionID: dbSub.ID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue requeueing webhook delivery", http.StatusInternalServerError, err)
+		return
+	}
+	if requeued == 0 {
+		respondWithError(resWriter, "Only dead-lettered deliveries of this webhook can be retried", http.StatusConflict, nil)
+		return
+	}
+	respondWithJson(resWriter, http.StatusAccepted, struct{}{})
+}
+
+// ownedWebhookFromPath loads the webhook named in the path and checks the caller owns it. Apps
+// can only touch the webhooks they registered themselves.
+func (cfg *apiConfig) ownedWebhookFromPath(resWriter http.ResponseWriter, req *http.Request) (database.WebhookSubscription, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeWebhooks) {
+		respondWithError(resWriter, "Token does not allow managing webhooks", http.StatusForbidden, nil)
+		return database.WebhookSubscription{}, false
+	}
+
+	id, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(resWriter, "webhook id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.WebhookSubscription{}, false
+	}
+	dbSub, err := cfg.db.GetWebhookSubscription(req.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Webhook not found", http.StatusNotFound, nil)
+		return database.WebhookSubscription{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding webhook", http.StatusInternalServerError, err)
+		return database.WebhookSubscription{}, false
+	}
+
+	if dbSub.UserID != accessToken.UserID || (!accessToken.FirstParty() && dbSub.ClientID.String != accessToken.ClientID) {
+		respondWithError(resWriter, "You don't own this webhook", http.StatusForbidden, nil)
+		return database.WebhookSubscription{}, false
+	}
+	return dbSub, true
+}
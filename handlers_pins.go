@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+// maxPinnedChirps is how many chirps a user can pin to the top of their profile.
+const maxPinnedChirps = 3
+
+type PinnedChirps struct {
+	ChirpIDs []uuid.UUID `json:"chirp_ids"`
+}
+
+// handlerPinChirp pins one of the caller's chirps to their profile. {"position": n} puts it
+// n-th from the top, otherwise it goes below the chirps already pinned; pinning a chirp that's
+// already pinned moves it. The response lists the pinned chirps in order.
+func (cfg *apiConfig) handlerPinChirp(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Position int `json:"position"`
+	}
+
+	dbChirp, ok := cfg.ownChirpFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	userUUID := accessTokenFrom(req.Context()).UserID
+
+	target := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&target)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if target.Position < 0 {
+		respondWithError(resWriter, "position must be 1 or more, or 0 to pin below the chirps already pinned", http.StatusBadRequest, nil)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Pins are rewritten as a whole, so concurrent pins by the same user are serialised to
+	// keep them from going over the limit together.
+	if err := qtx.LockPinnedChirps(req.Context(), userUUID); err != nil {
+		respondWithError(resWriter, "issue pinning chirp", http.StatusInternalServerError, err)
+		return
+	}
+	pinned, err := qtx.ListPinnedChirpIDs(req.Context(), userUUID)
+	if err != nil {
+		respondWithError(resWriter, "issue listing pinned chirps", http.StatusInternalServerError, err)
+		return
+	}
+	pinned = placePin(pinned, dbChirp.ID, target.Position)
+	if len(pinned) > maxPinnedChirps {
+		respondWithError(resWriter, fmt.Sprintf("You can pin at most %d chirps", maxPinnedChirps), http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := qtx.ClearPinnedChirps(req.Context(), userUUID); err != nil {
+		respondWithError(resWriter, "issue pinning chirp", http.StatusInternalServerError, err)
+		return
+	}
+	err = qtx.PinChirps(req.Context(), database.PinChirpsParams{
+		UserID:   userUUID,
+		ChirpIds: pinned,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue pinning chirp", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue pinning chirp", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, PinnedChirps{ChirpIDs: pinned})
+}
+
+func (cfg *apiConfig) handlerUnpinChirp(resWriter http.ResponseWriter, req *http.Request) {
+	dbChirp, ok := cfg.ownChirpFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnpinChirp(req.Context(), database.UnpinChirpParams{
+		UserID:  accessTokenFrom(req.Context()).UserID,
+		ChirpID: dbChirp.ID,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue unpinning chirp", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// ownChirpFromPath loads the chirp in the path and checks the caller wrote it, answering
+// the same way handlerDeleteChirp does when they didn't.
+func (cfg *apiConfig) ownChirpFromPath(resWriter http.ResponseWriter, req *http.Request) (database.Chirp, bool) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow changing chirps", http.StatusForbidden, nil)
+		return database.Chirp{}, false
+	}
+
+	convertedID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.Chirp{}, false
+	}
+	dbChirp, err := cfg.db.GetSingleChirp(req.Context(), convertedID)
+	if err != nil {
+		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, err)
+		return database.Chirp{}, false
+	}
+	if accessToken.UserID != dbChirp.UserID.UUID {
+		respondWithError(resWriter, "You are not the author of this chirp", http.StatusForbidden, nil)
+		return database.Chirp{}, false
+	}
+	return dbChirp, true
+}
+
+// placePin returns pinned with chirpID moved or added to the 1-based position, or to the end
+// when position is 0 or past it.
+func placePin(pinned []uuid.UUID, chirpID uuid.UUID, position int) []uuid.UUID {
+	pinned = slices.DeleteFunc(pinned, func(id uuid.UUID) bool { return id == chirpID })
+	if position == 0 || position > len(pinned) {
+		return append(pinned, chirpID)
+	}
+	return slices.Insert(pinned, position-1, chirpID)
+}
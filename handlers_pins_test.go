@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPlacePin(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name     string
+		pinned   []uuid.UUID
+		chirpID  uuid.UUID
+		position int
+		want     []uuid.UUID
+	}{
+		{"first pin", nil, a, 0, []uuid.UUID{a}},
+		{"zero goes to the end", []uuid.UUID{a, b}, c, 0, []uuid.UUID{a, b, c}},
+		{"top", []uuid.UUID{a, b}, c, 1, []uuid.UUID{c, a, b}},
+		{"middle", []uuid.UUID{a, b}, c, 2, []uuid.UUID{a, c, b}},
+		{"past the end", []uuid.UUID{a, b}, c, 9, []uuid.UUID{a, b, c}},
+		{"move up", []uuid.UUID{a, b, c}, c, 1, []uuid.UUID{c, a, b}},
+		{"move down", []uuid.UUID{a, b, c}, a, 3, []uuid.UUID{b, c, a}},
+		{"repin at the end", []uuid.UUID{a, b, c}, a, 0, []uuid.UUID{b, c, a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := placePin(slices.Clone(tt.pinned), tt.chirpID, tt.position)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("placePin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
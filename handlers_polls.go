@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/poll"
+	"github.com/google/uuid"
+)
+
+// Poll is a chirp's poll as its viewer sees it. Vote counts are left out until the viewer
+// has voted or the poll has closed.
+type Poll struct {
+	Options         []PollOption `json:"options"`
+	ClosesAt        time.Time    `json:"closes_at"`
+	Closed          bool         `json:"closed"`
+	ChangeableVotes bool         `json:"changeable_votes"`
+	TotalVotes      *int64       `json:"total_votes,omitempty"`
+	VotedOption     int          `json:"voted_option,omitempty"`
+}
+
+type PollOption struct {
+	Option int    `json:"option"`
+	Text   string `json:"text"`
+	Votes  *int64 `json:"votes,omitempty"`
+}
+
+// newPoll is the poll part of a request to post a chirp.
+type newPoll struct {
+	Options         []string  `json:"options"`
+	ClosesAt        time.Time `json:"closes_at"`
+	ChangeableVotes bool      `json:"changeable_votes"`
+}
+
+// checkNewPoll validates a poll for a chirp going out at opens, responding with a 400 if it's
+// not allowed. It returns the poll with its options trimmed.
+func checkNewPoll(resWriter http.ResponseWriter, p newPoll, opens time.Time) (newPoll, bool) {
+	options, err := poll.Options(p.Options)
+	if err != nil {
+		respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+		return newPoll{}, false
+	}
+	if err := poll.CheckCloses(opens, p.ClosesAt); err != nil {
+		respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+		return newPoll{}, false
+	}
+	p.Options = options
+	return p, true
+}
+
+// storePoll saves p as the poll of chirpID. Call it in the transaction storing the chirp.
+func storePoll(ctx context.Context, qtx *database.Queries, chirpID uuid.UUID, p newPoll) (*Poll, error) {
+	err := qtx.CreatePoll(ctx, database.CreatePollParams{
+		ChirpID:         chirpID,
+		ClosesAt:        p.ClosesAt.UTC(),
+		ChangeableVotes: p.ChangeableVotes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = qtx.CreatePollOptions(ctx, database.CreatePollOptionsParams{
+		ChirpID: chirpID,
+		Options: p.Options,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &Poll{
+		Options:         make([]PollOption, 0, len(p.Options)),
+		ClosesAt:        p.ClosesAt.UTC(),
+		ChangeableVotes: p.ChangeableVotes,
+	}
+	for i, text := range p.Options {
+		stored.Options = append(stored.Options, PollOption{Option: i + 1, Text: text})
+	}
+	return stored, nil
+}
+
+// attachPolls fills in the Poll of every chirp in chirps that has one, as viewer sees it.
+func (cfg *apiConfig) attachPolls(ctx context.Context, chirps []Chirp, viewer uuid.NullUUID) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	rows, err := cfg.db.ListPollOptions(ctx, database.ListPollOptionsParams{
+		Now:      time.Now().UTC(),
+		ViewerID: viewer,
+		ChirpIds: ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	polls := make(map[uuid.UUID]*Poll)
+	for _, row := range rows {
+		p, ok := polls[row.ChirpID]
+		if !ok {
+			p = &Poll{
+				ClosesAt:        row.ClosesAt,
+				Closed:          row.Closed,
+				ChangeableVotes: row.ChangeableVotes,
+				VotedOption:     int(row.ViewerChoice),
+			}
+			if poll.ShowResults(row.ViewerChoice != 0, row.Closed) {
+				p.TotalVotes = new(int64)
+			}
+			polls[row.ChirpID] = p
+		}
+		option := PollOption{Option: int(row.Position), Text: row.Text}
+		if p.TotalVotes != nil {
+			option.Votes = &row.Votes
+			*p.TotalVotes += row.Votes
+		}
+		p.Options = append(p.Options, option)
+	}
+	for i := range chirps {
+		chirps[i].Poll = polls[chirps[i].ID]
+	}
+	return nil
+}
+
+// handlerVotePoll votes for {"option": n} in the poll of the chirp in the path. Voting again
+// changes the vote if the poll allows it. The response is the poll with its results.
+func (cfg *apiConfig) handlerVotePoll(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Option int `json:"option"`
+	}
+
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't vote in polls", http.StatusForbidden, nil)
+		return
+	}
+	viewer := uuid.NullUUID{UUID: accessToken.UserID, Valid: true}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(resWriter, "chirp id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return
+	}
+	dbChirp, err := cfg.db.GetVisibleChirp(req.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: viewer,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Chirp not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding chirp", http.StatusInternalServerError, err)
+		return
+	}
+	// Only the author can see a scheduled chirp, and its poll doesn't open until it's published.
+	if dbChirp.PublishAt.Valid {
+		respondWithError(resWriter, "Poll isn't open until the chirp is published", http.StatusConflict, nil)
+		return
+	}
+
+	vote := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err = decoder.Decode(&vote)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	dbPoll, err := cfg.db.GetPoll(req.Context(), database.GetPollParams{
+		Now:     time.Now().UTC(),
+		ChirpID: chirpID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "Chirp has no poll", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding poll", http.StatusInternalServerError, err)
+		return
+	}
+	if dbPoll.Closed {
+		respondWithError(resWriter, "Poll is closed", http.StatusConflict, nil)
+		return
+	}
+	if vote.Option < 1 || int64(vote.Option) > dbPoll.OptionCount {
+		respondWithError(resWriter, fmt.Sprintf("option must be between 1 and %d", dbPoll.OptionCount), http.StatusBadRequest, nil)
+		return
+	}
+
+	voted, err := cfg.db.VotePoll(req.Context(), database.VotePollParams{
+		UserID:     accessToken.UserID,
+		Position:   int32(vote.Option),
+		ChirpID:    chirpID,
+		Now:        time.Now().UTC(),
+		Changeable: dbPoll.ChangeableVotes,
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue recording vote", http.StatusInternalServerError, err)
+		return
+	}
+	if voted == 0 {
+		// Either the poll closed since it was loaded or this is a second vote that can't change
+		if !dbPoll.ChangeableVotes {
+			dbPoll, err = cfg.db.GetPoll(req.Context(), database.GetPollParams{
+				Now:     time.Now().UTC(),
+				ChirpID: chirpID,
+			})
+			if err == nil && !dbPoll.Closed {
+				respondWithError(resWriter, "You have already voted in this poll", http.StatusConflict, nil)
+				return
+			}
+		}
+		respondWithError(resWriter, "Poll is closed", http.StatusConflict, nil)
+		return
+	}
+
+	chirps := []Chirp{chirpFromDB(dbChirp)}
+	if err := cfg.attachPolls(req.Context(), chirps, viewer); err != nil {
+		respondWithError(resWriter, "issue loading poll", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, chirps[0].Poll)
+}
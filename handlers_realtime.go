@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/realtime"
+	"github.com/cbrookscode/chirpy/internal/websocket"
+	"github.com/google/uuid"
+)
+
+// Topics clients can subscribe to over /api/ws:
+//
+//	chirps                 every new or deleted chirp
+//	chirps:<user id>       one author's chirps
+//	likes:<chirp id>       like count changes on a chirp
+//	conversation:<id>      new messages and typing in a conversation, for its participants
+const (
+	topicChirps       = "chirps"
+	topicChirpsUser   = "chirps:"
+	topicChirpLikes   = "likes:"
+	topicConversation = "conversation:"
+	maxSubscriptions  = 50
+
+	realtimeEventLikes   = "chirp.likes"
+	realtimeEventMessage = "message.created"
+	realtimeEventTyping  = "typing"
+)
+
+const (
+	realtimePingInterval = 30 * time.Second
+	// realtimeReadTimeout drops a connection that stops answering pings.
+	realtimeReadTimeout = 2 * realtimePingInterval
+	// realtimeReauthWarning is how long before the token expires the client is asked for a new one.
+	realtimeReauthWarning = time.Minute
+
+	// Application close codes sent with the close frame.
+	closeTokenExpired = 4001
+	closeTooSlow      = 4002
+)
+
+func likesTopic(chirpID uuid.UUID) string {
+	return topicChirpLikes + chirpID.String()
+}
+
+func conversationTopic(conversationID uuid.UUID) string {
+	return topicConversation + conversationID.String()
+}
+
+// publishRealtime sends a message to a topic on every instance. Failures only cost live
+// updates, so they're logged rather than failing the request that caused them.
+func (cfg *apiConfig) publishRealtime(ctx context.Context, topic, event string, sender uuid.UUID, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("issue encoding realtime %v event: %v", event, err)
+		return
+	}
+	err = cfg.realtimeBus.Publish(ctx, realtime.Message{Topic: topic, Event: event, Data: payload, Sender: sender})
+	if err != nil {
+		log.Printf("issue publishing realtime %v event to %v: %v", event, topic, err)
+	}
+}
+
+// authorizeTopic checks that a topic exists and userID may subscribe to it.
+func (cfg *apiConfig) authorizeTopic(ctx context.Context, userID uuid.UUID, topic string) error {
+	switch {
+	case topic == topicChirps:
+		return nil
+	case strings.HasPrefix(topic, topicChirpsUser):
+		authorID, err := uuid.Parse(strings.TrimPrefix(topic, topicChirpsUser))
+		if err != nil {
+			return errors.New("invalid user id in topic")
+		}
+		blocked, err := cfg.db.IsBlockedBetween(ctx, database.IsBlockedBetweenParams{
+			UserID:   userID,
+			OtherIds: []uuid.UUID{authorID},
+		})
+		if err != nil {
+			log.Printf("issue checking blocks between %v and %v: %v", userID, authorID, err)
+			return errors.New("couldn't check blocks")
+		}
+		if blocked {
+			return errors.New("user not available")
+		}
+		return nil
+	case strings.HasPrefix(topic, topicChirpLikes):
+		if _, err := uuid.Parse(strings.TrimPrefix(topic, topicChirpLikes)); err != nil {
+			return errors.New("invalid chirp id in topic")
+		}
+		return nil
+	case strings.HasPrefix(topic, topicConversation):
+		conversationID, err := uuid.Parse(strings.TrimPrefix(topic, topicConversation))
+		if err != nil {
+			return errors.New("invalid conversation id in topic")
+		}
+		isParticipant, err := cfg.db.IsConversationParticipant(ctx, database.IsConversationParticipantParams{
+			ConversationID: conversationID,
+			UserID:         userID,
+		})
+		if err != nil {
+			log.Printf("issue checking participants of conversation %v: %v", conversationID, err)
+			return errors.New("couldn't check conversation")
+		}
+		if !isParticipant {
+			return errors.New("not a participant in this conversation")
+		}
+		return nil
+	}
+	return errors.New("unknown topic")
+}
+
+// realtimeToken finds the access token for a websocket handshake. Browsers can't set headers on
+// a websocket, so the query string and session cookie are accepted too; it reports whether the
+// token came from the cookie, which needs an origin check.
+func realtimeToken(req *http.Request) (string, bool, error) {
+	if tokenString, err := auth.GetBearerToken(req.Header); err == nil {
+		return tokenString, false, nil
+	} else if !errors.Is(err, auth.ErrNoAuthHeader) {
+		return "", false, err
+	}
+	if tokenString := req.URL.Query().Get("access_token"); tokenString != "" {
+		return tokenString, false, nil
+	}
+	cookie, err := req.Cookie(accessCookieName)
+	if err != nil {
+		return "", false, auth.ErrNoAuthHeader
+	}
+	return cookie.Value, true, nil
+}
+
+// sameOrigin guards cookie authenticated websockets from cross-site hijacking, since the
+// browser attaches cookies to a websocket opened by any page.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// realtimeSession is the state one websocket connection shares between its reader and writer.
+type realtimeSession struct {
+	conn   *websocket.Conn
+	client *realtime.Client
+
+	mu        sync.Mutex
+	expiresAt time.Time
+	// reauthed wakes the writer to reschedule the expiry timer.
+	reauthed chan struct{}
+}
+
+func (s *realtimeSession) expiry() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiresAt
+}
+
+func (s *realtimeSession) send(v any) {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("issue encoding realtime frame: %v", err)
+		return
+	}
+	s.conn.WriteMessage(websocket.OpText, frame)
+}
+
+func (s *realtimeSession) sendError(topic, msg string) {
+	s.send(struct {
+		Type  string `json:"type"`
+		Topic string `json:"topic,omitempty"`
+		Error string `json:"error"`
+	}{Type: "error", Topic: topic, Error: msg})
+}
+
+// handlerRealtime upgrades to a websocket for the realtime API. The connection authenticates
+// with a first-party access token through ValidateJWT; shortly before it expires the client
+// gets a reauth_required frame and must send {"type":"auth","token":...} with a fresh token
+// for the same user, or the connection is closed with 4001.
+func (cfg *apiConfig) handlerRealtime(resWriter http.ResponseWriter, req *http.Request) {
+	tokenString, fromCookie, err := realtimeToken(req)
+	if err != nil {
+		respondWithError(resWriter, authHeaderErrorMsg(err), http.StatusUnauthorized, nil)
+		return
+	}
+	userID, err := auth.ValidateJWT(tokenString, cfg.secret)
+	if err != nil {
+		respondWithError(resWriter, "Invalid token", http.StatusUnauthorized, nil)
+		return
+	}
+	expiresAt, err := auth.TokenExpiry(tokenString)
+	if err != nil {
+		respondWithError(resWriter, "Invalid token", http.StatusUnauthorized, nil)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	if fromCookie {
+		upgrader.CheckOrigin = sameOrigin
+	}
+	conn, err := upgrader.Upgrade(resWriter, req)
+	if err != nil {
+		log.Printf("realtime handshake failed for %v: %v", userID, err)
+		return
+	}
+
+	session := &realtimeSession{
+		conn:      conn,
+		client:    cfg.realtimeHub.Register(userID),
+		expiresAt: expiresAt,
+		reauthed:  make(chan struct{}, 1),
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	finish := func() {
+		once.Do(func() {
+			close(done)
+			cfg.realtimeHub.Unregister(session.client)
+			conn.Close()
+		})
+	}
+	defer finish()
+
+	cfg.hideAuthors(req.Context(), session.client)
+	go cfg.writeRealtime(req.Context(), session, done, finish)
+
+	conn.PongHandler = func() {
+		conn.SetReadDeadline(time.Now().Add(realtimeReadTimeout))
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(realtimeReadTimeout))
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if op != websocket.OpText {
+			session.sendError("", "Only JSON text messages are supported")
+			continue
+		}
+		cfg.handleRealtimeFrame(req.Context(), session, data)
+	}
+}
+
+// hideAuthors keeps chirps from users the client blocked, muted or was blocked by out of its
+// subscriptions. It's refreshed with every ping.
+func (cfg *apiConfig) hideAuthors(ctx context.Context, client *realtime.Client) {
+	ids, err := cfg.db.ListHiddenAuthorIDs(ctx, client.UserID)
+	if err != nil {
+		log.Printf("issue finding blocked and muted users for %v: %v", client.UserID, err)
+		return
+	}
+	cfg.realtimeHub.Hide(client, ids)
+}
+
+// writeRealtime forwards hub messages to the connection, pings it and enforces token expiry.
+func (cfg *apiConfig) writeRealtime(ctx context.Context, s *realtimeSession, done <-chan struct{}, finish func()) {
+	defer finish()
+	ping := time.NewTicker(realtimePingInterval)
+	defer ping.Stop()
+
+	warned := false
+	timer := time.NewTimer(time.Until(s.expiry().Add(-realtimeReauthWarning)))
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case frame, ok := <-s.client.Send:
+			if !ok {
+				if cfg.realtimeHub.Lagged(s.client) {
+					s.conn.WriteClose(closeTooSlow, "connection too slow")
+				}
+				return
+			}
+			if err := s.conn.WriteMessage(websocket.OpText, frame); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WritePing(); err != nil {
+				return
+			}
+			cfg.hideAuthors(ctx, s.client)
+		case <-s.reauthed:
+			warned = false
+			timer.Reset(time.Until(s.expiry().Add(-realtimeReauthWarning)))
+		case <-timer.C:
+			if warned {
+				s.conn.WriteClose(closeTokenExpired, "token expired")
+				return
+			}
+			warned = true
+			s.send(struct {
+				Type      string    `json:"type"`
+				ExpiresAt time.Time `json:"expires_at"`
+			}{Type: "reauth_required", ExpiresAt: s.expiry()})
+			timer.Reset(time.Until(s.expiry()))
+		}
+	}
+}
+
+// handleRealtimeFrame runs one command from the client.
+func (cfg *apiConfig) handleRealtimeFrame(ctx context.Context, s *realtimeSession, data []byte) {
+	type incoming struct {
+		Type  string `json:"type"`
+		Topic string `json:"topic"`
+		Token string `json:"token"`
+	}
+
+	cmd := incoming{}
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		s.sendError("", "Couldn't decode message")
+		return
+	}
+
+	type topicReply struct {
+		Type  string `json:"type"`
+		Topic string `json:"topic"`
+	}
+	switch cmd.Type {
+	case "subscribe":
+		if len(cfg.realtimeHub.Subscribed(s.client)) >= maxSubscriptions {
+			s.sendError(cmd.Topic, "Too many subscriptions")
+			return
+		}
+		if err := cfg.authorizeTopic(ctx, s.client.UserID, cmd.Topic); err != nil {
+			s.sendError(cmd.Topic, err.Error())
+			return
+		}
+		cfg.realtimeHub.Subscribe(s.client, cmd.Topic)
+		s.send(topicReply{Type: "subscribed", Topic: cmd.Topic})
+	case "unsubscribe":
+		cfg.realtimeHub.Unsubscribe(s.client, cmd.Topic)
+		s.send(topicReply{Type: "unsubscribed", Topic: cmd.Topic})
+	case "auth":
+		userID, err := auth.ValidateJWT(cmd.Token, cfg.secret)
+		if err != nil || userID != s.client.UserID {
+			s.sendError("", "Invalid token")
+			return
+		}
+		expiresAt, err := auth.TokenExpiry(cmd.Token)
+		if err != nil {
+			s.sendError("", "Invalid token")
+			return
+		}
+		s.mu.Lock()
+		s.expiresAt = expiresAt
+		s.mu.Unlock()
+		select {
+		case s.reauthed <- struct{}{}:
+		default:
+		}
+		s.send(struct {
+			Type      string    `json:"type"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}{Type: "authenticated", ExpiresAt: expiresAt})
+	case "typing":
+		// Typing indicators go to the other participants of a conversation the client follows
+		if !strings.HasPrefix(cmd.Topic, topicConversation) || !slices.Contains(cfg.realtimeHub.Subscribed(s.client), cmd.Topic) {
+			s.sendError(cmd.Topic, "Subscribe to a conversation before sending typing indicators")
+			return
+		}
+		cfg.publishRealtime(ctx, cmd.Topic, realtimeEventTyping, s.client.UserID, struct {
+			UserID uuid.UUID `json:"user_id"`
+		}{UserID: s.client.UserID})
+	case "ping":
+		s.send(struct {
+			Type string `json:"type"`
+		}{Type: "pong"})
+	default:
+		s.sendError("", "Unknown message type")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/notify"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/cbrookscode/chirpy/internal/outbound"
+	"github.com/cbrookscode/chirpy/internal/poll"
+	"github.com/cbrookscode/chirpy/internal/stream"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxScheduleAhead is how far in the future a chirp can be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// scheduledBatchSize caps how many due chirps one run of the scheduler publishes.
+	scheduledBatchSize = 100
+)
+
+// validPublishAt checks a requested publish time is in the future but not too far off,
+// responding with a 400 if it isn't.
+func validPublishAt(resWriter http.ResponseWriter, publishAt time.Time) bool {
+	now := time.Now()
+	if !publishAt.After(now) {
+		respondWithError(resWriter, "publish_at must be in the future", http.StatusBadRequest, nil)
+		return false
+	}
+	if publishAt.After(now.Add(maxScheduleAhead)) {
+		respondWithError(resWriter, "publish_at can be at most a year ahead", http.StatusBadRequest, nil)
+		return false
+	}
+	return true
+}
+
+// handlerListScheduledChirps lists the caller's chirps that haven't been published yet,
+// soonest first.
+func (cfg *apiConfig) handlerListScheduledChirps(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.HasScope(oauth.ScopeChirpsWrite) {
+		respondWithError(resWriter, "Token does not allow changing chirps", http.StatusForbidden, nil)
+		return
+	}
+
+	dbChirps, err := cfg.db.ListScheduledChirps(req.Context(), uuid.NullUUID{UUID: accessToken.UserID, Valid: true})
+	if err != nil {
+		respondWithError(resWriter, "issue listing scheduled chirps", http.StatusInternalServerError, err)
+		return
+	}
+	chirps := make([]Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	respondWithJson(resWriter, http.StatusOK, chirps)
+}
+
+// handlerRescheduleChirp changes the body or publish_at of a chirp that's still scheduled.
+// Fields left out keep their current value. Unlike handlerEditChirp this is open to every
+// tier, since nobody has seen the chirp yet.
+func (cfg *apiConfig) handlerRescheduleChirp(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Body      *string    `json:"body"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	dbChirp, ok := cfg.ownChirpFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	if !dbChirp.PublishAt.Valid {
+		respondWithError(resWriter, "Chirp has already been published", http.StatusConflict, nil)
+		return
+	}
+	userUUID := dbChirp.UserID.UUID
+
+	changes := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&changes)
+	if err != nil {
+		log.Printf("Error decoding json data in request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	body := dbChirp.Body
+	if changes.Body != nil {
+		dbUser, err := cfg.db.GetUserByID(req.Context(), userUUID)
+		if err != nil {
+			respondWithError(resWriter, "Couldn't find user", http.StatusUnauthorized, err)
+			return
+		}
+		filteredChirp := filterProfanity(*changes.Body)
+		if !cfg.checkChirpBody(resWriter, dbUser.IsChirpyRed.Bool, filteredChirp) {
+			return
+		}
+		if _, ok := cfg.mentionedUsers(resWriter, req, userUUID, filteredChirp); !ok {
+			return
+		}
+		body = sql.NullString{String: filteredChirp, Valid: true}
+	}
+	publishAt := dbChirp.PublishAt
+	if changes.PublishAt != nil {
+		if !validPublishAt(resWriter, *changes.PublishAt) {
+			return
+		}
+		publishAt = sql.NullTime{Time: changes.PublishAt.UTC(), Valid: true}
+
+		// The poll opens when the chirp is published, so moving that has to keep it open
+		// for as long as polls must be
+		dbPoll, err := cfg.db.GetPoll(req.Context(), database.GetPollParams{
+			Now:     time.Now().UTC(),
+			ChirpID: dbChirp.ID,
+		})
+		if err == nil {
+			if err := poll.CheckCloses(publishAt.Time, dbPoll.ClosesAt); err != nil {
+				respondWithError(resWriter, err.Error(), http.StatusBadRequest, nil)
+				return
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(resWriter, "issue finding poll", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	tx, err := cfg.dbConn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(resWriter, "issue starting transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	updatedChirp, err := qtx.RescheduleChirp(req.Context(), database.RescheduleChirpParams{
+		ID:        dbChirp.ID,
+		Body:      body,
+		PublishAt: publishAt,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// The scheduler got to it first
+		respondWithError(resWriter, "Chirp has already been published", http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue updating scheduled chirp", http.StatusInternalServerError, err)
+		return
+	}
+	if changes.Body != nil {
+		if err := queueLinkPreviews(req.Context(), qtx, dbChirp.ID, body.String); err != nil {
+			respondWithError(resWriter, "issue queueing link previews", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(resWriter, "issue updating scheduled chirp", http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJson(resWriter, http.StatusOK, chirpFromDB(updatedChirp))
+}
+
+// handlerCancelScheduledChirp deletes a chirp before it's published.
+func (cfg *apiConfig) handlerCancelScheduledChirp(resWriter http.ResponseWriter, req *http.Request) {
+	dbChirp, ok := cfg.ownChirpFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+
+	cancelled, err := cfg.db.CancelScheduledChirp(req.Context(), dbChirp.ID)
+	if err != nil {
+		respondWithError(resWriter, "issue cancelling scheduled chirp", http.StatusInternalServerError, err)
+		return
+	}
+	if cancelled == 0 {
+		respondWithError(resWriter, "Chirp has already been published", http.StatusConflict, nil)
+		return
+	}
+	respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+}
+
+// publishScheduledChirps publishes chirps whose publish_at has passed and announces them the
+// way handlerChirps announces a new chirp. Due rows are claimed with SKIP LOCKED and cleared
+// in the same transaction as their webhooks are queued, so with several instances running
+// each chirp is published exactly once.
+func (cfg *apiConfig) publishScheduledChirps(ctx context.Context) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	published, err := qtx.PublishDueChirps(ctx, database.PublishDueChirpsParams{
+		Now:        time.Now().UTC(),
+		MaxResults: scheduledBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+	chirps := make([]Chirp, 0, len(published))
+	for _, dbChirp := range published {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	// Polls and media were stored when the chirps were scheduled, so they're announced too
+	if err := cfg.attachPolls(ctx, chirps, uuid.NullUUID{}); err != nil {
+		return err
+	}
+	if err := cfg.attachMedia(ctx, chirps); err != nil {
+		return err
+	}
+	for i, dbChirp := range published {
+		err := enqueueWebhookEvent(ctx, qtx, dbChirp.UserID.UUID, outbound.EventChirpCreated, chirps[i])
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, dbChirp := range published {
+		cfg.publishChirpEvent(ctx, stream.EventChirpCreated, dbChirp.UserID.UUID, chirps[i])
+		cfg.notifyScheduledChirp(ctx, dbChirp)
+	}
+	if len(published) > 0 {
+		log.Printf("published %d scheduled chirps", len(published))
+	}
+	return nil
+}
+
+// notifyScheduledChirp sends the reply and mention notifications of a chirp that has just
+// been published. Like the notifications themselves this is best effort.
+func (cfg *apiConfig) notifyScheduledChirp(ctx context.Context, dbChirp database.Chirp) {
+	var parent database.Chirp
+	if dbChirp.ReplyToID.Valid {
+		var err error
+		parent, err = cfg.db.GetSingleChirp(ctx, dbChirp.ReplyToID.UUID)
+		if err != nil {
+			log.Printf("issue finding chirp %v replied to: %v", dbChirp.ReplyToID.UUID, err)
+		}
+	}
+	var mentioned []uuid.UUID
+	if ids := notify.Mentions(dbChirp.Body.String); len(ids) > 0 {
+		var err error
+		mentioned, err = cfg.db.GetExistingUserIDs(ctx, ids)
+		if err != nil {
+			log.Printf("issue finding users mentioned in chirp %v: %v", dbChirp.ID, err)
+		}
+	}
+	cfg.notifyChirpCreated(ctx, dbChirp, parent, mentioned)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+)
+
+// setSessionCookies stores the tokens for a browser session. The access and refresh tokens are
+// HttpOnly so page scripts can't read them; the CSRF token is readable so the app can echo it.
+func setSessionCookies(resWriter http.ResponseWriter, accessToken, refreshToken, csrfToken string) {
+	http.SetCookie(resWriter, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(time.Hour.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if refreshToken != "" {
+		http.SetCookie(resWriter, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    refreshToken,
+			Path:     "/api/session", // only sent to the refresh and logout endpoints
+			MaxAge:   int(refreshTokenTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	if csrfToken != "" {
+		http.SetCookie(resWriter, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    csrfToken,
+			Path:     "/",
+			MaxAge:   int(refreshTokenTTL.Seconds()),
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+func clearSessionCookies(resWriter http.ResponseWriter) {
+	for _, cookie := range []struct{ name, path string }{
+		{accessCookieName, "/"},
+		{refreshCookieName, "/api/session"},
+		{csrfCookieName, "/"},
+	} {
+		http.SetCookie(resWriter, &http.Cookie{
+			Name:     cookie.name,
+			Value:    "",
+			Path:     cookie.path,
+			MaxAge:   -1,
+			HttpOnly: cookie.name != csrfCookieName,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+func (cfg *apiConfig) handlerCookieLogin(resWriter http.ResponseWriter, req *http.Request) {
+	type incoming struct {
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	userinfo := incoming{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&userinfo)
+	if err != nil {
+		log.Printf("Error decoding json data in POST request: %v\n", err)
+		respondWithError(resWriter, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+	if userinfo.Email == "" || userinfo.Password == "" {
+		respondWithError(resWriter, "Provided an empty string for username or password", 400, nil)
+		return
+	}
+
+	dbUser, err := cfg.checkCredentials(req.Context(), userinfo.Email, userinfo.Password, clientIP(req))
+	var locked *lockedOutError
+	if errors.As(err, &locked) {
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.wait.Seconds()))))
+		respondWithError(resWriter, "Too many failed login attempts, try again later", http.StatusTooManyRequests, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "Incorrect email or password", http.StatusUnauthorized, nil)
+		return
+	}
+
+	tokenString, refreshString, err := cfg.createSession(req.Context(), dbUser)
+	if err != nil {
+		respondWithError(resWriter, "Issue creating session", http.StatusInternalServerError, err)
+		return
+	}
+	csrfToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(resWriter, "Issue generating csrf token", http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookies(resWriter, tokenString, refreshString, csrfToken)
+	respondWithJson(resWriter, http.StatusOK, User{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt.Time,
+		UpdatedAt:   dbUser.UpdatedAt.Time,
+		Email:       dbUser.Email.String,
+		IsChirpyRed: dbUser.IsChirpyRed.Bool,
+		Role:        dbUser.Role,
+		Badges:      cfg.entitlements.Badges(dbUser.IsChirpyRed.Bool),
+	})
+}
+
+func (cfg *apiConfig) handlerCookieRefresh(resWriter http.ResponseWriter, req *http.Request) {
+	if !validCSRF(req) {
+		respondWithError(resWriter, "Missing or invalid CSRF token", http.StatusForbidden, nil)
+		return
+	}
+	cookie, err := req.Cookie(refreshCookieName)
+	if err != nil {
+		respondWithError(resWriter, "Refresh cookie not provided", http.StatusUnauthorized, nil)
+		return
+	}
+
+	dbRefToken, err := cfg.validRefreshToken(req.Context(), cookie.Value)
+	if err != nil {
+		clearSessionCookies(resWriter)
+		respondWithError(resWriter, err.Error(), http.StatusUnauthorized, nil)
+		return
+	}
+
+	tokenString, err := cfg.refreshAccessToken(req.Context(), dbRefToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "Failed to get new token", http.StatusInternalServerError, err)
+		return
+	}
+	setSessionCookies(resWriter, tokenString, "", "")
+	resWriter.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerCookieLogout(resWriter http.ResponseWriter, req *http.Request) {
+	if !validCSRF(req) {
+		respondWithError(resWriter, "Missing or invalid CSRF token", http.StatusForbidden, nil)
+		return
+	}
+	if cookie, err := req.Cookie(refreshCookieName); err == nil {
+		err = cfg.db.RevokeRefreshToken(req.Context(), cookie.Value)
+		if err != nil {
+			respondWithError(resWriter, "issue revoking session", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	clearSessionCookies(resWriter)
+	resWriter.WriteHeader(http.StatusNoContent)
+}
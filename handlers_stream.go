@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/realtime"
+	"github.com/cbrookscode/chirpy/internal/stream"
+	"github.com/google/uuid"
+)
+
+// streamHeartbeat is how often an idle stream gets a comment line. Followed, blocked and muted
+// users are reloaded at the same time, so changes show up in an open stream within this long.
+const streamHeartbeat = 15 * time.Second
+
+// publishChirpEvent hands a committed chirp change to live SSE streams on this instance and
+// to websocket subscribers on every instance. Call it after the transaction commits.
+func (cfg *apiConfig) publishChirpEvent(ctx context.Context, eventType string, authorID uuid.UUID, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("issue encoding %v stream event: %v", eventType, err)
+		return
+	}
+	cfg.chirpBroker.Publish(eventType, authorID, payload)
+	for _, topic := range []string{topicChirps, topicChirpsUser + authorID.String()} {
+		msg := realtime.Message{Topic: topic, Event: eventType, Data: payload, Author: authorID}
+		if err := cfg.realtimeBus.Publish(ctx, msg); err != nil {
+			log.Printf("issue publishing realtime %v event to %v: %v", eventType, topic, err)
+		}
+	}
+}
+
+// handlerStreamChirps sends chirp.created and chirp.deleted events as Server-Sent Events.
+// author_id limits them to one author and followed=true to users the caller follows. Chirps
+// from users the caller muted or is on either side of a block with are left out.
+// Reconnecting clients resume after Last-Event-ID, for as long as the broker still has it.
+func (cfg *apiConfig) handlerStreamChirps(resWriter http.ResponseWriter, req *http.Request) {
+	flusher, ok := resWriter.(http.Flusher)
+	if !ok {
+		respondWithError(resWriter, "Streaming not supported", http.StatusInternalServerError, nil)
+		return
+	}
+	accessToken := accessTokenFrom(req.Context())
+	query := req.URL.Query()
+
+	authorID := uuid.Nil
+	if raw := query.Get("author_id"); raw != "" {
+		parsed, err := uuid.Parse(raw)
+		if err != nil {
+			respondWithError(resWriter, "author id provided is not a valid UUID", http.StatusBadRequest, nil)
+			return
+		}
+		authorID = parsed
+	}
+
+	// Browsers send Last-Event-ID themselves when EventSource reconnects; the query parameter
+	// lets a fresh page pick up where a previous one stopped
+	lastID := stream.EventID{}
+	rawLastID := req.Header.Get("Last-Event-ID")
+	if rawLastID == "" {
+		rawLastID = query.Get("last_event_id")
+	}
+	if rawLastID != "" {
+		parsed, err := stream.ParseEventID(rawLastID)
+		if err != nil {
+			respondWithError(resWriter, "Last-Event-ID must be an event id from this stream", http.StatusBadRequest, nil)
+			return
+		}
+		lastID = parsed
+	}
+
+	onlyFollowed := query.Get("followed") == "true"
+	var followed atomic.Pointer[map[uuid.UUID]struct{}]
+	if onlyFollowed {
+		ids, err := cfg.followedSet(req.Context(), accessToken.UserID)
+		if err != nil {
+			respondWithError(resWriter, "issue finding followed users", http.StatusInternalServerError, err)
+			return
+		}
+		followed.Store(&ids)
+	}
+
+	var hidden atomic.Pointer[map[uuid.UUID]struct{}]
+	hiddenIDs, err := cfg.hiddenAuthorSet(req.Context(), accessToken.UserID)
+	if err != nil {
+		respondWithError(resWriter, "issue finding blocked and muted users", http.StatusInternalServerError, err)
+		return
+	}
+	hidden.Store(&hiddenIDs)
+
+	// The broker calls the filter while publishing, so it must only read what's safe to share
+	filter := func(ev stream.Event) bool {
+		if authorID != uuid.Nil && ev.AuthorID != authorID {
+			return false
+		}
+		if _, ok := (*hidden.Load())[ev.AuthorID]; ok {
+			return false
+		}
+		if onlyFollowed {
+			_, ok := (*followed.Load())[ev.AuthorID]
+			return ok
+		}
+		return true
+	}
+	sub, backlog := cfg.chirpBroker.Subscribe(filter, lastID)
+	defer sub.Close()
+
+	resWriter.Header().Set("Content-Type", "text/event-stream")
+	resWriter.Header().Set("Cache-Control", "no-cache")
+	resWriter.Header().Set("Connection", "keep-alive")
+	resWriter.Header().Set("X-Accel-Buffering", "no")
+	resWriter.WriteHeader(http.StatusOK)
+	io.WriteString(resWriter, "retry: 3000\n\n")
+	for _, ev := range backlog {
+		if err := stream.WriteEvent(resWriter, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with Last-Event-ID
+				log.Printf("closing chirp stream for %v: lagged %v", accessToken.UserID, sub.Lagged())
+				return
+			}
+			if err := stream.WriteEvent(resWriter, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if ids, err := cfg.hiddenAuthorSet(req.Context(), accessToken.UserID); err != nil {
+				log.Printf("issue refreshing blocked and muted users for %v: %v", accessToken.UserID, err)
+			} else {
+				hidden.Store(&ids)
+			}
+			if onlyFollowed {
+				ids, err := cfg.followedSet(req.Context(), accessToken.UserID)
+				if err != nil {
+					log.Printf("issue refreshing followed users for %v: %v", accessToken.UserID, err)
+				} else {
+					followed.Store(&ids)
+				}
+			}
+			if err := stream.WriteHeartbeat(resWriter); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (cfg *apiConfig) followedSet(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]struct{}, error) {
+	ids, err := cfg.db.ListFollowedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set, nil
+}
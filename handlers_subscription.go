@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/outbound"
+	"github.com/cbrookscode/chirpy/internal/subscription"
+	"github.com/google/uuid"
+)
+
+// Subscription is a user's Chirpy Red subscription. CurrentPeriodEnd is null for an open-ended
+// period, which lasts until Polka downgrades the user.
+type Subscription struct {
+	Plan               string              `json:"plan"`
+	Status             string              `json:"status"`
+	CurrentPeriodStart time.Time           `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time          `json:"current_period_end"`
+	IsChirpyRed        bool                `json:"is_chirpy_red"`
+	History            []SubscriptionEvent `json:"history"`
+}
+
+type SubscriptionEvent struct {
+	Event       string     `json:"event"`
+	Plan        string     `json:"plan"`
+	Status      string     `json:"status"`
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   *time.Time `json:"period_end"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+var errUnknownUser = errors.New("no user with that id")
+
+// applySubscriptionEvent moves a user's subscription to its next state, records the change in
+// its history and updates is_chirpy_red to match. Run it inside the webhook's transaction.
+// Events for a user who doesn't exist fail with errUnknownUser.
+func applySubscriptionEvent(ctx context.Context, qtx *database.Queries, userID uuid.UUID, ev subscription.Event) error {
+	if _, err := qtx.GetUserByID(ctx, userID); errors.Is(err, sql.ErrNoRows) {
+		return errUnknownUser
+	} else if err != nil {
+		return err
+	}
+
+	// The row stays locked until the webhook commits, so concurrent events for the same user
+	// apply one after the other instead of overwriting each other
+	var current *subscription.State
+	dbSub, err := qtx.GetSubscriptionByUserIDForUpdate(ctx, userID)
+	if err == nil {
+		current = &subscription.State{
+			Plan:        dbSub.Plan,
+			Status:      dbSub.Status,
+			PeriodStart: dbSub.CurrentPeriodStart,
+			PeriodEnd:   dbSub.CurrentPeriodEnd.Time,
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	now := time.Now().UTC()
+	next, err := subscription.Apply(current, ev, now)
+	if err != nil {
+		return err
+	}
+
+	periodEnd := sql.NullTime{Time: next.PeriodEnd, Valid: !next.OpenEnded()}
+	dbSub, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:             userID,
+		Plan:               next.Plan,
+		Status:             next.Status,
+		CurrentPeriodStart: next.PeriodStart,
+		CurrentPeriodEnd:   periodEnd,
+	})
+	if err != nil {
+		return err
+	}
+	err = qtx.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+		SubscriptionID: dbSub.ID,
+		EventType:      ev.Type,
+		Plan:           next.Plan,
+		Status:         next.Status,
+		PeriodStart:    next.PeriodStart,
+		PeriodEnd:      periodEnd,
+	})
+	if err != nil {
+		return err
+	}
+	return setChirpyRed(ctx, qtx, userID, next.Entitled(now))
+}
+
+// setChirpyRed updates a user's is_chirpy_red and, if that changes it, queues a user.upgraded
+// or user.downgraded webhook in the same transaction.
+func setChirpyRed(ctx context.Context, qtx *database.Queries, userID uuid.UUID, isRed bool) error {
+	dbUser, err := qtx.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = qtx.UpdateUserChirpyRedStatus(ctx, database.UpdateUserChirpyRedStatusParams{
+		ID:          userID,
+		IsChirpyRed: sql.NullBool{Bool: isRed, Valid: true},
+	})
+	if err != nil || dbUser.IsChirpyRed.Bool == isRed {
+		return err
+	}
+
+	eventType := outbound.EventUserDowngraded
+	if isRed {
+		eventType = outbound.EventUserUpgraded
+	}
+	return enqueueWebhookEvent(ctx, qtx, userID, eventType, struct {
+		UserID      uuid.UUID `json:"user_id"`
+		IsChirpyRed bool      `json:"is_chirpy_red"`
+	}{
+		UserID:      userID,
+		IsChirpyRed: isRed,
+	})
+}
+
+// expireSubscriptions ends every subscription whose paid period has lapsed and takes Chirpy Red
+// away from its user.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	expired, err := qtx.ExpireLapsedSubscriptions(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, dbSub := range expired {
+		err = qtx.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+			SubscriptionID: dbSub.ID,
+			EventType:      subscription.EventExpired,
+			Plan:           dbSub.Plan,
+			Status:         dbSub.Status,
+			PeriodStart:    dbSub.CurrentPeriodStart,
+			PeriodEnd:      dbSub.CurrentPeriodEnd,
+		})
+		if err != nil {
+			return err
+		}
+		err = setChirpyRed(ctx, qtx, dbSub.UserID, false)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		log.Printf("expired %d lapsed subscriptions", len(expired))
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerGetSubscription(resWriter http.ResponseWriter, req *http.Request) {
+	accessToken := accessTokenFrom(req.Context())
+	if !accessToken.FirstParty() {
+		respondWithError(resWriter, "Third-party apps can't view billing details", http.StatusForbidden, nil)
+		return
+	}
+
+	dbSub, err := cfg.db.GetSubscriptionByUserID(req.Context(), accessToken.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "No subscription found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding subscription", http.StatusInternalServerError, err)
+		return
+	}
+	dbEvents, err := cfg.db.ListSubscriptionEvents(req.Context(), dbSub.ID)
+	if err != nil {
+		respondWithError(resWriter, "issue finding subscription history", http.StatusInternalServerError, err)
+		return
+	}
+
+	history := []SubscriptionEvent{}
+	for _, dbEvent := range dbEvents {
+		event := SubscriptionEvent{
+			Event:       dbEvent.EventType,
+			Plan:        dbEvent.Plan,
+			Status:      dbEvent.Status,
+			PeriodStart: dbEvent.PeriodStart,
+			CreatedAt:   dbEvent.CreatedAt,
+		}
+		if dbEvent.PeriodEnd.Valid {
+			event.PeriodEnd = &dbEvent.PeriodEnd.Time
+		}
+		history = append(history, event)
+	}
+	state := subscription.State{
+		Plan:        dbSub.Plan,
+		Status:      dbSub.Status,
+		PeriodStart: dbSub.CurrentPeriodStart,
+		PeriodEnd:   dbSub.CurrentPeriodEnd.Time,
+	}
+	sub := Subscription{
+		Plan:               dbSub.Plan,
+		Status:             dbSub.Status,
+		CurrentPeriodStart: dbSub.CurrentPeriodStart,
+		IsChirpyRed:        state.Entitled(time.Now().UTC()),
+		History:            history,
+	}
+	if dbSub.CurrentPeriodEnd.Valid {
+		sub.CurrentPeriodEnd = &dbSub.CurrentPeriodEnd.Time
+	}
+	respondWithJson(resWriter, http.StatusOK, sub)
+}
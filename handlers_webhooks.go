@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/polka"
+	"github.com/cbrookscode/chirpy/internal/subscription"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxUnverifiedLogBody is how much of an unverified webhook's body is logged. It can't be
+	// replayed, so the start is enough to see what was sent.
+	maxUnverifiedLogBody = 1 << 10
+	// unverifiedWebhooksPerMinute caps the webhooks failing verification one address may send.
+	unverifiedWebhooksPerMinute = 20
+)
+
+// Outcomes recorded in webhook_event_log.
+const (
+	webhookProcessed = "processed"
+	webhookDuplicate = "duplicate"
+	webhookIgnored   = "ignored"
+	webhookRejected  = "rejected"
+	webhookFailed    = "failed"
+)
+
+// webhookResult is what processing a Polka webhook came to: the response to send back and
+// the outcome to record in the event log.
+type webhookResult struct {
+	status    int
+	outcome   string
+	message   string
+	err       error
+	eventID   string
+	eventType string
+}
+
+// WebhookLogEntry is a webhook as we received it, or an admin replay of one. Replays are
+// entries of their own pointing back with ReplayOf, so the original is never rewritten.
+type WebhookLogEntry struct {
+	ID          uuid.UUID         `json:"id"`
+	ReceivedAt  time.Time         `json:"received_at"`
+	EventID     string            `json:"event_id"`
+	EventType   string            `json:"event_type"`
+	Verified    bool              `json:"verified"`
+	Outcome     string            `json:"outcome"`
+	Error       string            `json:"error,omitempty"`
+	ProcessedAt *time.Time        `json:"processed_at"`
+	ReplayOf    *uuid.UUID        `json:"replay_of,omitempty"`
+	Headers     json.RawMessage   `json:"headers,omitempty"`
+	RawBody     string            `json:"raw_body,omitempty"`
+	Replays     []WebhookLogEntry `json:"replays,omitempty"`
+}
+
+func webhookLogEntryFrom(dbEntry database.WebhookEventLog, withPayload bool) WebhookLogEntry {
+	entry := WebhookLogEntry{
+		ID:         dbEntry.ID,
+		ReceivedAt: dbEntry.ReceivedAt,
+		EventID:    dbEntry.EventID.String,
+		EventType:  dbEntry.EventType.String,
+		Verified:   dbEntry.Verified,
+		Outcome:    dbEntry.Outcome,
+		Error:      dbEntry.Error.String,
+	}
+	if dbEntry.ProcessedAt.Valid {
+		entry.ProcessedAt = &dbEntry.ProcessedAt.Time
+	}
+	if dbEntry.ReplayOf.Valid {
+		entry.ReplayOf = &dbEntry.ReplayOf.UUID
+	}
+	if withPayload {
+		entry.Headers = dbEntry.Headers
+		entry.RawBody = string(dbEntry.RawBody)
+	}
+	return entry
+}
+
+func (cfg *apiConfig) handlerChirpyRed(resWriter http.ResponseWriter, req *http.Request) {
+	body, readErr := io.ReadAll(http.MaxBytesReader(resWriter, req.Body, 1<<20))
+
+	var result webhookResult
+	verified := false
+	if readErr != nil {
+		result = webhookResult{status: http.StatusBadRequest, outcome: webhookRejected, message: "Couldn't read webhook body", err: readErr}
+	} else if err := polka.Verify(req.Header, body, cfg.polkaKey, time.Now(), polka.DefaultTolerance); err != nil {
+		log.Printf("rejected polka webhook: %v", err)
+		result = webhookResult{status: http.StatusUnauthorized, outcome: webhookRejected, message: "Invalid webhook signature", err: err}
+	} else {
+		verified = true
+		result = cfg.processPolkaWebhook(req.Context(), body)
+	}
+
+	if !verified {
+		// Anyone can send these, so they're rate limited and don't get to fill the log
+		if wait, ok := cfg.webhookLimiter.Allow(clientIP(req), unverifiedWebhooksPerMinute); !ok {
+			resWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(resWriter, "Too many invalid webhooks, try again later", http.StatusTooManyRequests, nil)
+			return
+		}
+		if len(body) > maxUnverifiedLogBody {
+			body = body[:maxUnverifiedLogBody]
+		}
+	}
+
+	// A webhook we can't log is still answered, since Polka retrying won't fix our database
+	if err := cfg.logWebhookEvent(req.Context(), req.Header, body, verified, result); err != nil {
+		log.Printf("issue logging polka webhook: %v", err)
+	}
+
+	if result.status == http.StatusNoContent {
+		respondWithJson(resWriter, http.StatusNoContent, struct{}{})
+		return
+	}
+	if result.status == http.StatusUnauthorized {
+		// The reason is logged and stored, but not handed to whoever sent a forged request
+		result.err = nil
+	}
+	respondWithError(resWriter, result.message, result.status, result.err)
+}
+
+// processPolkaWebhook applies a verified webhook body. It's shared by the webhook endpoint and
+// admin replay, so replaying an event that was already applied comes back as a duplicate.
+func (cfg *apiConfig) processPolkaWebhook(ctx context.Context, body []byte) webhookResult {
+	type incoming struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  struct {
+			UserID      string    `json:"user_id"`
+			Plan        string    `json:"plan"`
+			PeriodStart time.Time `json:"period_start"`
+			PeriodEnd   time.Time `json:"period_end"`
+		} `json:"data"`
+	}
+
+	webhookInfo := incoming{}
+	err := json.Unmarshal(body, &webhookInfo)
+	if err != nil {
+		return webhookResult{status: http.StatusBadRequest, outcome: webhookRejected, message: "Couldn't decode webhook body", err: err}
+	}
+	result := webhookResult{eventID: webhookInfo.ID, eventType: webhookInfo.Event}
+	finish := func(status int, outcome, message string, err error) webhookResult {
+		result.status, result.outcome, result.message, result.err = status, outcome, message, err
+		return result
+	}
+
+	if webhookInfo.ID == "" {
+		return finish(http.StatusBadRequest, webhookRejected, "Webhook event id missing", nil)
+	}
+	if !subscription.Known(webhookInfo.Event) {
+		log.Printf("Not a subscription event. %v is the event", webhookInfo.Event)
+		return finish(http.StatusNoContent, webhookIgnored, "", nil)
+	}
+
+	convertedID, err := uuid.Parse(webhookInfo.Data.UserID)
+	if err != nil {
+		log.Printf("%v is provided string that could not be parsed into UUID", webhookInfo.Data.UserID)
+		return finish(http.StatusBadRequest, webhookRejected, "chirp id provided is not a valid UUID", err)
+	}
+
+	// Recording the event id and applying it share a transaction, so a failed update can be
+	// retried while a duplicate delivery is acknowledged without being applied twice
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return finish(http.StatusInternalServerError, webhookFailed, "issue starting transaction", err)
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	_, err = qtx.MarkWebhookEventProcessed(ctx, database.MarkWebhookEventProcessedParams{
+		EventID:   webhookInfo.ID,
+		EventType: webhookInfo.Event,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("polka event %v already processed, acknowledging duplicate", webhookInfo.ID)
+		return finish(http.StatusNoContent, webhookDuplicate, "", nil)
+	}
+	if err != nil {
+		return finish(http.StatusInternalServerError, webhookFailed, "issue recording webhook event", err)
+	}
+
+	err = applySubscriptionEvent(ctx, qtx, convertedID, subscription.Event{
+		Type:        webhookInfo.Event,
+		Plan:        webhookInfo.Data.Plan,
+		PeriodStart: webhookInfo.Data.PeriodStart,
+		PeriodEnd:   webhookInfo.Data.PeriodEnd,
+	})
+	// Only a user that doesn't exist, or an event that can't apply to one who never subscribed,
+	// gets an answer Polka won't retry. Anything else is our failure and worth delivering again
+	outcome := webhookProcessed
+	switch {
+	case errors.Is(err, errUnknownUser):
+		log.Printf("polka event %v is for unknown user %v", webhookInfo.ID, convertedID)
+		return finish(http.StatusNotFound, webhookRejected, "User not found", err)
+	case errors.Is(err, subscription.ErrNoSubscription):
+		log.Printf("polka event %v: user %v has no subscription to %v", webhookInfo.ID, convertedID, webhookInfo.Event)
+		outcome = webhookIgnored
+	case err != nil:
+		log.Printf("Failed to apply subscription event. %v is the event: %v", webhookInfo.Event, err)
+		return finish(http.StatusInternalServerError, webhookFailed, "issue updating chirpy red status", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return finish(http.StatusInternalServerError, webhookFailed, "issue committing webhook event", err)
+	}
+	log.Printf("%v is the event. and updating db was successful", webhookInfo.Event)
+	return finish(http.StatusNoContent, outcome, "", nil)
+}
+
+// logWebhookEvent stores a webhook exactly as it arrived, minus credentials, alongside what
+// we did with it.
+func (cfg *apiConfig) logWebhookEvent(ctx context.Context, headers http.Header, body []byte, verified bool, result webhookResult) error {
+	rawHeaders, err := json.Marshal(polka.RedactHeaders(headers))
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	_, err = cfg.db.CreateWebhookLogEntry(ctx, database.CreateWebhookLogEntryParams{
+		Headers:     rawHeaders,
+		RawBody:     body,
+		EventID:     sql.NullString{String: result.eventID, Valid: result.eventID != ""},
+		EventType:   sql.NullString{String: result.eventType, Valid: result.eventType != ""},
+		Verified:    verified,
+		Outcome:     result.outcome,
+		Error:       webhookLogError(result),
+		ProcessedAt: webhookProcessedAt(result),
+	})
+	return err
+}
+
+func webhookLogError(result webhookResult) sql.NullString {
+	if result.err != nil {
+		return sql.NullString{String: result.err.Error(), Valid: true}
+	}
+	return sql.NullString{String: result.message, Valid: result.message != ""}
+}
+
+func webhookProcessedAt(result webhookResult) sql.NullTime {
+	if result.outcome != webhookProcessed {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.Now().UTC(), Valid: true}
+}
+
+func (cfg *apiConfig) handlerListWebhookEvents(resWriter http.ResponseWriter, req *http.Request) {
+	limit := 50
+	if raw := req.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > 500 {
+			respondWithError(resWriter, "limit must be between 1 and 500", http.StatusBadRequest, nil)
+			return
+		}
+		limit = v
+	}
+
+	dbEntries, err := cfg.db.ListWebhookLogEntries(req.Context(), database.ListWebhookLogEntriesParams{
+		Outcome:    req.URL.Query().Get("outcome"),
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue listing webhook events", http.StatusInternalServerError, err)
+		return
+	}
+
+	entries := []WebhookLogEntry{}
+	for _, dbEntry := range dbEntries {
+		entries = append(entries, webhookLogEntryFrom(dbEntry, false))
+	}
+	respondWithJson(resWriter, http.StatusOK, entries)
+}
+
+// handlerGetWebhookEvent shows a stored webhook with its payload and every replay of it.
+func (cfg *apiConfig) handlerGetWebhookEvent(resWriter http.ResponseWriter, req *http.Request) {
+	dbEntry, ok := cfg.webhookLogEntryFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	dbReplays, err := cfg.db.ListWebhookLogReplays(req.Context(), uuid.NullUUID{UUID: dbEntry.ID, Valid: true})
+	if err != nil {
+		respondWithError(resWriter, "issue finding webhook event replays", http.StatusInternalServerError, err)
+		return
+	}
+
+	entry := webhookLogEntryFrom(dbEntry, true)
+	for _, dbReplay := range dbReplays {
+		entry.Replays = append(entry.Replays, webhookLogEntryFrom(dbReplay, false))
+	}
+	respondWithJson(resWriter, http.StatusOK, entry)
+}
+
+// handlerReplayWebhookEvent runs a stored webhook through processing again and records the
+// outcome as a new entry linked to the original, which is left as it was. Replaying a replay
+// links to the webhook first received. Only verified entries can be replayed; the signature
+// isn't checked again since its timestamp will long be outside the tolerance window.
+func (cfg *apiConfig) handlerReplayWebhookEvent(resWriter http.ResponseWriter, req *http.Request) {
+	dbEntry, ok := cfg.webhookLogEntryFromPath(resWriter, req)
+	if !ok {
+		return
+	}
+	if !dbEntry.Verified {
+		respondWithError(resWriter, "Unverified webhooks can't be replayed", http.StatusConflict, nil)
+		return
+	}
+	original := dbEntry.ID
+	if dbEntry.ReplayOf.Valid {
+		original = dbEntry.ReplayOf.UUID
+	}
+
+	result := cfg.processPolkaWebhook(req.Context(), dbEntry.RawBody)
+	if result.err != nil {
+		log.Printf("replay of webhook %v failed: %v", original, result.err)
+	}
+	dbReplay, err := cfg.db.CreateWebhookLogEntry(req.Context(), database.CreateWebhookLogEntryParams{
+		Headers:     dbEntry.Headers,
+		RawBody:     dbEntry.RawBody,
+		EventID:     dbEntry.EventID,
+		EventType:   dbEntry.EventType,
+		Verified:    true,
+		Outcome:     result.outcome,
+		Error:       webhookLogError(result),
+		ProcessedAt: webhookProcessedAt(result),
+		ReplayOf:    uuid.NullUUID{UUID: original, Valid: true},
+	})
+	if err != nil {
+		respondWithError(resWriter, "issue recording webhook replay", http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("admin %v replayed webhook %v as %v: %v", accessTokenFrom(req.Context()).UserID, original, dbReplay.ID, result.outcome)
+	respondWithJson(resWriter, http.StatusCreated, webhookLogEntryFrom(dbReplay, true))
+}
+
+func (cfg *apiConfig) webhookLogEntryFromPath(resWriter http.ResponseWriter, req *http.Request) (database.WebhookEventLog, bool) {
+	id, err := uuid.Parse(req.PathValue("eventLogID"))
+	if err != nil {
+		respondWithError(resWriter, "event log id provided is not a valid UUID", http.StatusBadRequest, nil)
+		return database.WebhookEventLog{}, false
+	}
+	dbEntry, err := cfg.db.GetWebhookLogEntry(req.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(resWriter, "No webhook event found", http.StatusNotFound, nil)
+		return database.WebhookEventLog{}, false
+	}
+	if err != nil {
+		respondWithError(resWriter, "issue finding webhook event", http.StatusInternalServerError, err)
+		return database.WebhookEventLog{}, false
+	}
+	return dbEntry, true
+}
+
+// pruneWebhookLog deletes log entries older than the configured retention.
+func (cfg *apiConfig) pruneWebhookLog(ctx context.Context) error {
+	pruned, err := cfg.db.DeleteWebhookLogEntriesBefore(ctx, time.Now().UTC().Add(-cfg.webhookLogRetention))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		log.Printf("pruned %d webhook log entries", pruned)
+	}
+	return nil
+}
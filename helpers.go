@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/entitlement"
+	"github.com/cbrookscode/chirpy/internal/linkpreview"
+	"github.com/cbrookscode/chirpy/internal/password"
+	"github.com/lib/pq"
 )
 
 func respondWithError(w http.ResponseWriter, msg string, code int, err error) {
@@ -37,6 +48,115 @@ func respondWithJson(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(bytes)
 }
 
+// checkPassword runs the password policy and, if it fails, responds with a 400 listing every
+// rule that was broken. It reports whether the password was accepted.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, pw, email string) bool {
+	err := cfg.passwordChecker.Check(pw, email)
+	if err == nil {
+		return true
+	}
+
+	var verr *password.ValidationError
+	if !errors.As(err, &verr) {
+		respondWithError(w, "Issue checking password", http.StatusInternalServerError, err)
+		return false
+	}
+	respondWithJson(w, http.StatusBadRequest, struct {
+		Error      string               `json:"error"`
+		Violations []password.Violation `json:"violations"`
+	}{
+		Error:      "Password does not meet requirements",
+		Violations: verr.Violations,
+	})
+	return false
+}
+
+// respondWithEntitlementError answers a failed entitlement check: 402 when Chirpy Red would
+// unlock the feature, 403 when it wouldn't, and 400 for chirps too long for anyone.
+func respondWithEntitlementError(w http.ResponseWriter, err error) {
+	var denied *entitlement.Denied
+	if !errors.As(err, &denied) {
+		respondWithError(w, "Chrip is too long", http.StatusBadRequest, nil)
+		return
+	}
+	code := http.StatusForbidden
+	if denied.Upgrade {
+		code = http.StatusPaymentRequired
+	}
+	respondWithJson(w, code, struct {
+		Error   string `json:"error"`
+		Feature string `json:"feature"`
+		Upgrade bool   `json:"upgrade_available"`
+	}{
+		Error:   denied.Reason,
+		Feature: denied.Feature,
+		Upgrade: denied.Upgrade,
+	})
+}
+
+// checkChirpBody answers for a chirp body that's too big to store or too long for the
+// author's tier, reporting whether it may be posted.
+func (cfg *apiConfig) checkChirpBody(w http.ResponseWriter, isChirpyRed bool, body string) bool {
+	switch err := linkpreview.CheckSize(body); {
+	case errors.Is(err, linkpreview.ErrURLTooLong):
+		respondWithError(w, fmt.Sprintf("Links can be at most %d bytes", linkpreview.MaxURLLength), http.StatusBadRequest, nil)
+		return false
+	case err != nil:
+		respondWithError(w, fmt.Sprintf("Chirps can be at most %d bytes", linkpreview.MaxBodyBytes), http.StatusBadRequest, nil)
+		return false
+	}
+	if err := cfg.entitlements.CheckChirpLength(isChirpyRed, linkpreview.Length(body)); err != nil {
+		respondWithEntitlementError(w, err)
+		return false
+	}
+	return true
+}
+
+// isUniqueViolation reports whether err is Postgres refusing a row that breaks a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// authHeaderErrorMsg maps an error from parsing the Authorization header to the message sent back with a 401.
+func authHeaderErrorMsg(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrNoAuthHeader):
+		return "Authorization header not provided"
+	case errors.Is(err, auth.ErrWrongAuthScheme):
+		return "Wrong authorization scheme"
+	case errors.Is(err, auth.ErrMalformedAuthHeader):
+		return "Malformed authorization header"
+	}
+	return "Invalid authorization header"
+}
+
+// runPeriodically calls job straight away and then every interval until ctx is done, logging
+// any error with what the job does.
+func runPeriodically(ctx context.Context, interval time.Duration, what string, job func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(ctx); err != nil {
+			log.Printf("issue %s: %v", what, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func filterProfanity(text string) string {
 	badWords := map[string]struct{}{
 		"kerfuffle": {},
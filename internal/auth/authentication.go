@@ -1,13 +1,16 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/argon2id"
@@ -15,21 +18,66 @@ import (
 	"github.com/google/uuid"
 )
 
+// DefaultHashParams are used when no Argon2id parameters are configured. Parallelism is fixed
+// rather than tied to the host's CPU count so hashes come out the same on every machine.
+var DefaultHashParams = argon2id.Params{
+	Memory:      128 * 1024,
+	Iterations:  4,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var (
+	paramsMu   sync.RWMutex
+	hashParams = DefaultHashParams
+)
+
+// SetHashParams changes the Argon2id parameters used for new hashes. Existing hashes made with
+// other parameters still verify, and NeedsRehash reports them so they can be upgraded.
+func SetHashParams(params argon2id.Params) error {
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 {
+		return fmt.Errorf("invalid argon2id params: memory %d KiB, iterations %d, parallelism %d",
+			params.Memory, params.Iterations, params.Parallelism)
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return fmt.Errorf("invalid argon2id params: salt length %d, key length %d", params.SaltLength, params.KeyLength)
+	}
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	hashParams = params
+	return nil
+}
+
+func HashParams() argon2id.Params {
+	paramsMu.RLock()
+	defer paramsMu.RUnlock()
+	return hashParams
+}
+
 func HashPassword(password string) (string, error) {
-	params := &argon2id.Params{
-		Memory:      128 * 1024,
-		Iterations:  4,
-		Parallelism: uint8(runtime.NumCPU()),
-		SaltLength:  16,
-		KeyLength:   32,
-	}
-	hash, err := argon2id.CreateHash(password, params)
+	params := HashParams()
+	hash, err := argon2id.CreateHash(password, &params)
 	if err != nil {
 		return "", err
 	}
 	return hash, nil
 }
 
+// NeedsRehash reports whether hash was made with parameters other than the current ones.
+func NeedsRehash(hash string) (bool, error) {
+	params, salt, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false, err
+	}
+	current := HashParams()
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		params.KeyLength != current.KeyLength ||
+		uint32(len(salt)) != current.SaltLength, nil
+}
+
 func CheckPasswordHash(password, hash string) (bool, error) {
 	match, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil {
@@ -41,14 +89,112 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return false, nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
+var dummyHash = sync.OnceValues(func() (string, error) {
+	return HashPassword("chirpy-dummy-password")
+})
+
+// CompareDummyHash does the same argon2id work as CheckPasswordHash against a throwaway hash,
+// so a login for an email that doesn't exist takes as long as one that does.
+func CompareDummyHash(password string) {
+	hash, err := dummyHash()
+	if err != nil {
+		log.Printf("issue creating dummy hash: %v", err)
+		return
+	}
+	argon2id.ComparePasswordAndHash(password, hash)
+}
+
+// ErrScopedToken is returned by ValidateJWT for tokens issued to a third-party OAuth client.
+// Endpoints that accept those must use ValidateAccessToken and check scopes.
+var ErrScopedToken = errors.New("token was issued to an oauth client")
+
+// accessClaims are the claims in every access token. Tokens issued to OAuth clients carry the
+// client id and the space separated scopes the user granted; first-party tokens leave both empty.
+type accessClaims struct {
+	Role     string `json:"role,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessToken is the validated content of an access token.
+type AccessToken struct {
+	UserID   uuid.UUID
+	Role     string
+	ClientID string
+	Scopes   []string
+}
+
+// Roles a user can have, from least to most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether the token's role is at least role. Tokens issued to OAuth clients
+// never carry a role, so they can't reach role protected routes.
+func (t AccessToken) HasRole(role string) bool {
+	need, ok := roleRank[role]
+	return ok && t.FirstParty() && roleRank[t.Role] >= need
+}
+
+// HasScope reports whether the token allows scope. First-party tokens allow everything.
+func (t AccessToken) HasScope(scope string) bool {
+	if t.ClientID == "" {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstParty reports whether the token was issued to Chirpy itself rather than an OAuth client.
+func (t AccessToken) FirstParty() bool {
+	return t.ClientID == ""
+}
+
+// MakeJWT makes a first-party access token for userID carrying the user's role.
+func MakeJWT(userID uuid.UUID, role, tokenSecret string) (string, error) {
+	return makeAccessToken(userID, role, tokenSecret, "", nil)
+}
+
+// MakeScopedJWT makes an access token for an OAuth client acting on behalf of userID.
+func MakeScopedJWT(userID uuid.UUID, tokenSecret, clientID string, scopes []string) (string, error) {
+	if clientID == "" {
+		return "", fmt.Errorf("scoped token needs a client id")
+	}
+	return makeAccessToken(userID, "", tokenSecret, clientID, scopes)
+}
+
+func makeAccessToken(userID uuid.UUID, role, tokenSecret, clientID string, scopes []string) (string, error) {
 	now := &jwt.NumericDate{Time: time.Now().UTC()}
 	later := &jwt.NumericDate{Time: time.Now().UTC().Add(3600 * time.Second)}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  now,
-		ExpiresAt: later,
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		Role:     role,
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  now,
+			ExpiresAt: later,
+			Subject:   userID.String(),
+		},
 	})
 	// log.Printf("Issued at: %v, Expires At: %v", now, later)
 	tokenString, err := token.SignedString([]byte(tokenSecret))
@@ -58,40 +204,110 @@ func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
 	return tokenString, nil
 }
 
+// ValidateJWT validates a first-party access token and returns the user it was issued to.
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	token, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if token.ClientID != "" {
+		return uuid.Nil, ErrScopedToken
+	}
+	return token.UserID, nil
+}
+
+// TokenExpiry returns when an access token expires. It doesn't check the signature, so only
+// call it on a token that ValidateJWT or ValidateAccessToken has already accepted.
+func TokenExpiry(tokenString string) (time.Time, error) {
+	claims := &accessClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		return time.Time{}, err
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, fmt.Errorf("token has no expiry")
+	}
+	return exp.Time, nil
+}
+
+// ValidateAccessToken validates any access token, first-party or OAuth, and returns its claims.
+func ValidateAccessToken(tokenString, tokenSecret string) (AccessToken, error) {
+	claims := &accessClaims{}
 	parsedToken, err := jwt.ParseWithClaims(
 		tokenString,
-		&jwt.RegisteredClaims{},
+		claims,
 		func(token *jwt.Token) (interface{}, error) {
 			return []byte(tokenSecret), nil
 		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
 	)
 	if err != nil {
 		log.Printf("issue parsing token string: %v", err)
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
-	if parsedToken.Valid {
-		uuidString, err := parsedToken.Claims.GetSubject()
-		if err != nil {
-			log.Printf("issue getting subject from parse token: %v", err)
-			return uuid.Nil, err
-		}
-		validUUID, err := uuid.Parse(uuidString)
-		if err != nil {
-			log.Printf("issue parsing uuid string: %v", err)
-			return uuid.Nil, err
-		}
-		return validUUID, nil
+	if !parsedToken.Valid {
+		return AccessToken{}, fmt.Errorf("invalid token")
+	}
+	uuidString, err := claims.GetSubject()
+	if err != nil {
+		log.Printf("issue getting subject from parse token: %v", err)
+		return AccessToken{}, err
 	}
-	return uuid.Nil, fmt.Errorf("invalid token")
+	validUUID, err := uuid.Parse(uuidString)
+	if err != nil {
+		log.Printf("issue parsing uuid string: %v", err)
+		return AccessToken{}, err
+	}
+	return AccessToken{
+		UserID:   validUUID,
+		Role:     claims.Role,
+		ClientID: claims.ClientID,
+		Scopes:   strings.Fields(claims.Scope),
+	}, nil
 }
 
-func GetBearerToken(headers http.Header) (string, error) {
-	authHeader, exist := headers["Authorization"]
-	if !exist {
-		return "", fmt.Errorf("no auth info")
+// HashToken returns the hex sha256 of a high-entropy secret such as an authorization code or
+// client secret, so only the hash needs to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Errors returned when parsing the Authorization header. Handlers can use
+// errors.Is to map these to a specific 401 message.
+var (
+	ErrNoAuthHeader        = errors.New("no authorization header provided")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+	ErrWrongAuthScheme     = errors.New("authorization scheme does not match")
+)
+
+// getAuthCredential pulls the credential out of an "Authorization: <scheme> <credential>"
+// header. The scheme is matched case-insensitively and exactly one credential must follow it.
+func getAuthCredential(headers http.Header, scheme string) (string, error) {
+	values := headers.Values("Authorization")
+	if len(values) == 0 {
+		return "", ErrNoAuthHeader
+	}
+	if len(values) > 1 {
+		return "", ErrMalformedAuthHeader
+	}
+
+	fields := strings.Fields(values[0])
+	if len(fields) == 0 {
+		return "", ErrNoAuthHeader
+	}
+	if !strings.EqualFold(fields[0], scheme) {
+		return "", ErrWrongAuthScheme
 	}
-	return strings.TrimPrefix(authHeader[0], "Bearer "), nil
+	if len(fields) != 2 {
+		return "", ErrMalformedAuthHeader
+	}
+	return fields[1], nil
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	return getAuthCredential(headers, "Bearer")
 }
 
 func MakeRefreshToken() (string, error) {
@@ -104,9 +320,44 @@ func MakeRefreshToken() (string, error) {
 }
 
 func GetAPIKey(headers http.Header) (string, error) {
-	authHeader, exist := headers["Authorization"]
-	if !exist {
-		return "", fmt.Errorf("no auth info")
+	return getAuthCredential(headers, "ApiKey")
+}
+
+// ErrInvalidMagicLink is returned when a magic link token is malformed or its signature doesn't match.
+var ErrInvalidMagicLink = errors.New("invalid magic link token")
+
+func signMagicLink(id, tokenSecret string) string {
+	mac := hmac.New(sha256.New, []byte(tokenSecret))
+	mac.Write([]byte("chirpy-magic-link:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MakeMagicLinkToken returns a random id to store server side and the signed "<id>.<signature>"
+// token to put in the emailed link.
+func MakeMagicLinkToken(tokenSecret string) (string, string, error) {
+	id, err := MakeRefreshToken()
+	if err != nil {
+		return "", "", err
 	}
-	return strings.TrimPrefix(authHeader[0], "ApiKey "), nil
+	return id, id + "." + signMagicLink(id, tokenSecret), nil
+}
+
+// ValidateMagicLinkToken checks the signature on a magic link token and returns its id.
+// Whether the id is unused and unexpired is up to the caller to check against the database.
+func ValidateMagicLinkToken(token, tokenSecret string) (string, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || id == "" {
+		return "", ErrInvalidMagicLink
+	}
+	if !hmac.Equal([]byte(sig), []byte(signMagicLink(id, tokenSecret))) {
+		return "", ErrInvalidMagicLink
+	}
+	return id, nil
+}
+
+// DeviceFingerprint hashes the client supplied device id together with its user agent so a
+// magic link can only be redeemed from the device that asked for it.
+func DeviceFingerprint(deviceID, userAgent string) string {
+	sum := sha256.Sum256([]byte(deviceID + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:])
 }
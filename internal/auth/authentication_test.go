@@ -1,9 +1,12 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
 )
 
@@ -25,7 +28,7 @@ func TestCreateAndValidateJWT(t *testing.T) {
 		tt := tt // capture variable at current loop iteration
 		t.Run(tt.tokenSecret, func(t *testing.T) {
 			t.Parallel() // run each subtest concurrently
-			tokenString, err := MakeJWT(tt.id, tt.tokenSecret)
+			tokenString, err := MakeJWT(tt.id, RoleUser, tt.tokenSecret)
 			if err != nil {
 				t.Errorf("error making jwt: %v", err)
 			}
@@ -40,6 +43,24 @@ func TestCreateAndValidateJWT(t *testing.T) {
 	}
 }
 
+func TestTokenExpiry(t *testing.T) {
+	before := time.Now().Add(time.Hour - time.Second)
+	tokenString, err := MakeJWT(uuid.New(), RoleUser, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := TokenExpiry(tokenString)
+	if err != nil {
+		t.Fatalf("TokenExpiry() error = %v", err)
+	}
+	if exp.Before(before) || exp.After(time.Now().Add(time.Hour+time.Second)) {
+		t.Errorf("TokenExpiry() = %v, want about an hour from now", exp)
+	}
+	if _, err := TokenExpiry("not.a.token"); err == nil {
+		t.Error("TokenExpiry() accepted garbage")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	testHeader := http.Header{
 		"Authorization": []string{"Bearer tokenString"},
@@ -53,3 +74,209 @@ func TestGetBearerToken(t *testing.T) {
 		t.Errorf("string doesn't match expectation. Got %v, Want tokenString", tokenstring)
 	}
 }
+
+func TestGetBearerTokenParsing(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    string
+		wantErr error
+	}{
+		{"valid", http.Header{"Authorization": {"Bearer abc.def.ghi"}}, "abc.def.ghi", nil},
+		{"lowercase scheme", http.Header{"Authorization": {"bearer abc"}}, "abc", nil},
+		{"extra whitespace", http.Header{"Authorization": {"  Bearer   abc  "}}, "abc", nil},
+		{"missing header", http.Header{}, "", ErrNoAuthHeader},
+		{"empty header", http.Header{"Authorization": {""}}, "", ErrNoAuthHeader},
+		{"scheme only", http.Header{"Authorization": {"Bearer"}}, "", ErrMalformedAuthHeader},
+		{"two credentials", http.Header{"Authorization": {"Bearer abc def"}}, "", ErrMalformedAuthHeader},
+		{"duplicate headers", http.Header{"Authorization": {"Bearer abc", "Bearer def"}}, "", ErrMalformedAuthHeader},
+		{"basic scheme", http.Header{"Authorization": {"Basic xyz"}}, "", ErrWrongAuthScheme},
+		{"api key scheme", http.Header{"Authorization": {"ApiKey xyz"}}, "", ErrWrongAuthScheme},
+		{"no scheme", http.Header{"Authorization": {"abc"}}, "", ErrWrongAuthScheme},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetBearerToken(tt.headers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got err %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    string
+		wantErr error
+	}{
+		{"valid", http.Header{"Authorization": {"ApiKey f271c81ff7084ee5b99a5091b42d486e"}}, "f271c81ff7084ee5b99a5091b42d486e", nil},
+		{"mixed case scheme", http.Header{"Authorization": {"APIKEY secret"}}, "secret", nil},
+		{"missing header", http.Header{}, "", ErrNoAuthHeader},
+		{"bearer scheme", http.Header{"Authorization": {"Bearer secret"}}, "", ErrWrongAuthScheme},
+		{"scheme only", http.Header{"Authorization": {"ApiKey "}}, "", ErrMalformedAuthHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetAPIKey(tt.headers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got err %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	original := HashParams()
+	t.Cleanup(func() { SetHashParams(original) })
+
+	old := argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	if err := SetHashParams(old); err != nil {
+		t.Fatalf("error setting params: %v", err)
+	}
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	if stale, err := NeedsRehash(hash); err != nil || stale {
+		t.Errorf("hash made with current params: got stale %v err %v", stale, err)
+	}
+
+	upgraded := old
+	upgraded.Iterations = 2
+	if err := SetHashParams(upgraded); err != nil {
+		t.Fatalf("error setting params: %v", err)
+	}
+	if stale, err := NeedsRehash(hash); err != nil || !stale {
+		t.Errorf("hash made with old params: got stale %v err %v", stale, err)
+	}
+	match, err := CheckPasswordHash("hunter2", hash)
+	if err != nil || !match {
+		t.Errorf("old hash should still verify: got match %v err %v", match, err)
+	}
+
+	if _, err := NeedsRehash("unset"); err == nil {
+		t.Error("expected error for a value that isn't an argon2id hash")
+	}
+}
+
+func TestSetHashParamsRejectsInvalid(t *testing.T) {
+	bad := DefaultHashParams
+	bad.Iterations = 0
+	if err := SetHashParams(bad); err == nil {
+		t.Error("expected error for zero iterations")
+	}
+	if HashParams() == bad {
+		t.Error("invalid params should not be applied")
+	}
+}
+
+func TestMagicLinkToken(t *testing.T) {
+	id, token, err := MakeMagicLinkToken("dinosaurs")
+	if err != nil {
+		t.Fatalf("error making magic link token: %v", err)
+	}
+
+	got, err := ValidateMagicLinkToken(token, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error validating magic link token: %v", err)
+	}
+	if got != id {
+		t.Errorf("ids did not match: got %v, want %v", got, id)
+	}
+
+	tampered := []string{
+		token + "0",
+		"deadbeef" + token[8:],
+		id,
+		"",
+	}
+	for _, bad := range tampered {
+		if _, err := ValidateMagicLinkToken(bad, "dinosaurs"); !errors.Is(err, ErrInvalidMagicLink) {
+			t.Errorf("token %q: got err %v, want ErrInvalidMagicLink", bad, err)
+		}
+	}
+	if _, err := ValidateMagicLinkToken(token, "computers"); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("wrong secret: got err %v, want ErrInvalidMagicLink", err)
+	}
+}
+
+func TestScopedJWT(t *testing.T) {
+	userID := uuid.New()
+	tokenString, err := MakeScopedJWT(userID, "dinosaurs", "client-123", []string{"chirps:write"})
+	if err != nil {
+		t.Fatalf("error making scoped jwt: %v", err)
+	}
+
+	if _, err := ValidateJWT(tokenString, "dinosaurs"); !errors.Is(err, ErrScopedToken) {
+		t.Errorf("first-party validation: got err %v, want ErrScopedToken", err)
+	}
+
+	token, err := ValidateAccessToken(tokenString, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error validating scoped jwt: %v", err)
+	}
+	if token.UserID != userID || token.ClientID != "client-123" {
+		t.Errorf("got user %v client %q, want %v client-123", token.UserID, token.ClientID, userID)
+	}
+	if !token.HasScope("chirps:write") || token.HasScope("profile:write") {
+		t.Errorf("unexpected scopes %v", token.Scopes)
+	}
+
+	firstParty, err := MakeJWT(userID, RoleUser, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error making jwt: %v", err)
+	}
+	token, err = ValidateAccessToken(firstParty, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error validating jwt: %v", err)
+	}
+	if !token.HasScope("anything") {
+		t.Error("first-party tokens should allow every scope")
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		name  string
+		token AccessToken
+		need  string
+		want  bool
+	}{
+		{"admin reaches admin", AccessToken{Role: RoleAdmin}, RoleAdmin, true},
+		{"admin reaches moderator", AccessToken{Role: RoleAdmin}, RoleModerator, true},
+		{"moderator blocked from admin", AccessToken{Role: RoleModerator}, RoleAdmin, false},
+		{"user blocked from moderator", AccessToken{Role: RoleUser}, RoleModerator, false},
+		{"missing role", AccessToken{}, RoleUser, false},
+		{"unknown required role", AccessToken{Role: RoleAdmin}, "owner", false},
+		{"oauth client token", AccessToken{Role: RoleAdmin, ClientID: "client-123"}, RoleUser, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.HasRole(tt.need); got != tt.want {
+				t.Errorf("HasRole(%q) = %v, want %v", tt.need, got, tt.want)
+			}
+		})
+	}
+
+	tokenString, err := MakeJWT(uuid.New(), RoleModerator, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error making jwt: %v", err)
+	}
+	token, err := ValidateAccessToken(tokenString, "dinosaurs")
+	if err != nil {
+		t.Fatalf("error validating jwt: %v", err)
+	}
+	if token.Role != RoleModerator {
+		t.Errorf("got role %q, want %q", token.Role, RoleModerator)
+	}
+}
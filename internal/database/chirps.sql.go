@@ -8,29 +8,52 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const cancelScheduledChirp = `-- name: CancelScheduledChirp :execrows
+DELETE FROM chirps
+WHERE id = $1 AND publish_at IS NOT NULL
+`
+
+func (q *Queries) CancelScheduledChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelScheduledChirp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to_id, publish_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, reply_to_id, publish_at
 `
 
 type CreateChirpParams struct {
-	Body   sql.NullString
-	UserID uuid.NullUUID
+	Body      sql.NullString
+	UserID    uuid.NullUUID
+	ReplyToID uuid.NullUUID
+	PublishAt sql.NullTime
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ReplyToID,
+		arg.PublishAt,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -38,6 +61,8 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ReplyToID,
+		&i.PublishAt,
 	)
 	return i, err
 }
@@ -61,13 +86,130 @@ func (q *Queries) DeleteSingleChirp(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getChirpsAscByCreated = `-- name: GetChirpsAscByCreated :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+const getSingleChirp = `-- name: GetSingleChirp :one
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, publish_at FROM chirps
+WHERE id = $1
+`
+
+func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getSingleChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyToID,
+		&i.PublishAt,
+	)
+	return i, err
+}
+
+const getVisibleChirp = `-- name: GetVisibleChirp :one
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.reply_to_id, c.publish_at FROM chirps c
+WHERE c.id = $1
+  AND (c.publish_at IS NULL OR c.user_id = $2)
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks b
+    WHERE (b.blocker_id = c.user_id AND b.blocked_id = $2)
+       OR (b.blocker_id = $2 AND b.blocked_id = c.user_id)
+  )
+`
+
+type GetVisibleChirpParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetVisibleChirp(ctx context.Context, arg GetVisibleChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleChirp, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyToID,
+		&i.PublishAt,
+	)
+	return i, err
+}
+
+const listChirps = `-- name: ListChirps :many
+SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.reply_to_id, c.publish_at, (p.chirp_id IS NOT NULL)::bool AS pinned
+FROM chirps c
+LEFT JOIN pinned_chirps p
+    ON p.chirp_id = c.id AND p.user_id = $1
+WHERE ($1::uuid IS NULL OR c.user_id = $1)
+  AND c.publish_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks b
+    WHERE (b.blocker_id = c.user_id AND b.blocked_id = $2)
+       OR (b.blocker_id = $2 AND b.blocked_id = c.user_id)
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM user_mutes m
+    WHERE m.muter_id = $2 AND m.muted_id = c.user_id
+  )
+ORDER BY
+    p.position ASC NULLS LAST,
+    CASE WHEN $3::bool THEN c.created_at END DESC,
+    CASE WHEN NOT $3::bool THEN c.created_at END ASC
+`
+
+type ListChirpsParams struct {
+	AuthorID    uuid.NullUUID
+	ViewerID    uuid.NullUUID
+	NewestFirst bool
+}
+
+type ListChirpsRow struct {
+	Chirp  Chirp
+	Pinned bool
+}
+
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]ListChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps, arg.AuthorID, arg.ViewerID, arg.NewestFirst)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListChirpsRow
+	for rows.Next() {
+		var i ListChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.ReplyToID,
+			&i.Chirp.PublishAt,
+			&i.Pinned,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledChirps = `-- name: ListScheduledChirps :many
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, publish_at FROM chirps
+WHERE user_id = $1 AND publish_at IS NOT NULL
+ORDER BY publish_at, id
 `
 
-func (q *Queries) GetChirpsAscByCreated(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsAscByCreated)
+func (q *Queries) ListScheduledChirps(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledChirps, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +223,8 @@ func (q *Queries) GetChirpsAscByCreated(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyToID,
+			&i.PublishAt,
 		); err != nil {
 			return nil, err
 		}
@@ -95,13 +239,102 @@ func (q *Queries) GetChirpsAscByCreated(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getSingleChirp = `-- name: GetSingleChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const publishDueChirps = `-- name: PublishDueChirps :many
+UPDATE chirps
+SET created_at = publish_at,
+    updated_at = NOW(),
+    publish_at = NULL
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE publish_at <= $1
+    ORDER BY publish_at
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, body, user_id, reply_to_id, publish_at
+`
+
+type PublishDueChirpsParams struct {
+	Now        time.Time
+	MaxResults int32
+}
+
+func (q *Queries) PublishDueChirps(ctx context.Context, arg PublishDueChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, publishDueChirps, arg.Now, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ReplyToID,
+			&i.PublishAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const rescheduleChirp = `-- name: RescheduleChirp :one
+UPDATE chirps
+SET body = $2,
+    publish_at = $3,
+    updated_at = NOW()
+WHERE id = $1 AND publish_at IS NOT NULL
+RETURNING id, created_at, updated_at, body, user_id, reply_to_id, publish_at
+`
+
+type RescheduleChirpParams struct {
+	ID        uuid.UUID
+	Body      sql.NullString
+	PublishAt sql.NullTime
+}
+
+func (q *Queries) RescheduleChirp(ctx context.Context, arg RescheduleChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, rescheduleChirp, arg.ID, arg.Body, arg.PublishAt)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyToID,
+		&i.PublishAt,
+	)
+	return i, err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2,
+    updated_at = NOW()
 WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, reply_to_id, publish_at
 `
 
-func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getSingleChirp, id)
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body sql.NullString
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -109,6 +342,8 @@ func (q *Queries) GetSingleChirp(ctx context.Context, id uuid.UUID) (Chirp, erro
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ReplyToID,
+		&i.PublishAt,
 	)
 	return i, err
 }
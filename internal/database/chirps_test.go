@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestChirpVisibility(t *testing.T) {
+	q := testQueries(t)
+	ctx := context.Background()
+
+	viewer := createTestUser(t, q, "viewer@example.com")
+	friend := createTestUser(t, q, "friend@example.com")
+	muted := createTestUser(t, q, "muted@example.com")
+	blocked := createTestUser(t, q, "blocked@example.com")
+	blocker := createTestUser(t, q, "blocker@example.com")
+
+	own := createTestChirp(t, q, viewer, "mine")
+	fromFriend := createTestChirp(t, q, friend, "hello")
+	fromMuted := createTestChirp(t, q, muted, "noise")
+	fromBlocked := createTestChirp(t, q, blocked, "spam")
+	fromBlocker := createTestChirp(t, q, blocker, "go away")
+
+	scheduled, err := q.CreateChirp(ctx, CreateChirpParams{
+		Body:      sql.NullString{String: "later", Valid: true},
+		UserID:    uuid.NullUUID{UUID: friend.ID, Valid: true},
+		PublishAt: sql.NullTime{Time: time.Now().Add(time.Hour).UTC(), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.MuteUser(ctx, MuteUserParams{MuterID: viewer.ID, MutedID: muted.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.BlockUser(ctx, BlockUserParams{BlockerID: viewer.ID, BlockedID: blocked.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.BlockUser(ctx, BlockUserParams{BlockerID: blocker.ID, BlockedID: viewer.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := func(viewerID uuid.NullUUID, authorID uuid.NullUUID) map[uuid.UUID]bool {
+		t.Helper()
+		rows, err := q.ListChirps(ctx, ListChirpsParams{AuthorID: authorID, ViewerID: viewerID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[uuid.UUID]bool)
+		for _, row := range rows {
+			ids[row.Chirp.ID] = true
+		}
+		return ids
+	}
+	signedIn := uuid.NullUUID{UUID: viewer.ID, Valid: true}
+
+	got := listed(signedIn, uuid.NullUUID{})
+	want := map[uuid.UUID]bool{own.ID: true, fromFriend.ID: true}
+	if len(got) != len(want) || !got[own.ID] || !got[fromFriend.ID] {
+		t.Errorf("signed in viewer sees %v, want only %v", got, want)
+	}
+	if got := listed(uuid.NullUUID{}, uuid.NullUUID{}); len(got) != 5 {
+		t.Errorf("anonymous viewer sees %d chirps, want all 5", len(got))
+	}
+	// Asking for a muted author by name still leaves them out; a blocked one too
+	if got := listed(signedIn, uuid.NullUUID{UUID: muted.ID, Valid: true}); len(got) != 0 {
+		t.Errorf("muted author's page shows %d chirps, want none", len(got))
+	}
+
+	visible := func(viewerID uuid.NullUUID, chirp Chirp) bool {
+		t.Helper()
+		_, err := q.GetVisibleChirp(ctx, GetVisibleChirpParams{ID: chirp.ID, ViewerID: viewerID})
+		if errors.Is(err, sql.ErrNoRows) {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+	tests := []struct {
+		name   string
+		chirp  Chirp
+		viewer uuid.NullUUID
+		want   bool
+	}{
+		{"friend", fromFriend, signedIn, true},
+		// Muting only hides chirps from lists; a link to one still opens
+		{"muted", fromMuted, signedIn, true},
+		{"blocked by viewer", fromBlocked, signedIn, false},
+		{"blocking viewer", fromBlocker, signedIn, false},
+		{"blocked, anonymous", fromBlocked, uuid.NullUUID{}, true},
+		{"own chirp of a blocked user", fromBlocked, uuid.NullUUID{UUID: blocked.ID, Valid: true}, true},
+		{"scheduled, someone else", scheduled, signedIn, false},
+		{"scheduled, its author", scheduled, uuid.NullUUID{UUID: friend.ID, Valid: true}, true},
+	}
+	for _, tt := range tests {
+		if got := visible(tt.viewer, tt.chirp); got != tt.want {
+			t.Errorf("%s: visible = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
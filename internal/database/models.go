@@ -6,17 +6,197 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type Bookmark struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	FolderID  uuid.NullUUID
+	CreatedAt time.Time
+}
+
+type BookmarkFolder struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Name      string
+	CreatedAt time.Time
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt sql.NullTime
 	UpdatedAt sql.NullTime
 	Body      sql.NullString
 	UserID    uuid.NullUUID
+	ReplyToID uuid.NullUUID
+	PublishAt sql.NullTime
+}
+
+type ChirpLike struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type Conversation struct {
+	ID        uuid.UUID
+	IsGroup   bool
+	Title     sql.NullString
+	CreatedBy uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ConversationParticipant struct {
+	ConversationID uuid.UUID
+	UserID         uuid.UUID
+	JoinedAt       time.Time
+	LastReadAt     sql.NullTime
+}
+
+type Draft struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Body      string
+	ReplyToID uuid.NullUUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LinkPreview struct {
+	ChirpID       uuid.UUID
+	Position      int32
+	Url           string
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	Title         sql.NullString
+	Description   sql.NullString
+	ImageUrl      sql.NullString
+	SiteName      sql.NullString
+	FetchedAt     sql.NullTime
+}
+
+type MagicLinkToken struct {
+	ID          string
+	UserID      uuid.UUID
+	Fingerprint string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+}
+
+type MediaFile struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	ChirpID       uuid.NullUUID
+	Position      sql.NullInt32
+	AltText       string
+	ContentType   string
+	SizeBytes     int32
+	Width         int32
+	Height        int32
+	StorageKey    string
+	ThumbnailKey  sql.NullString
+	ThumbnailType sql.NullString
+	CreatedAt     time.Time
+}
+
+type Message struct {
+	ID             uuid.UUID
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	Body           string
+	CreatedAt      time.Time
+}
+
+type Notification struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Type      string
+	ActorID   uuid.UUID
+	ChirpID   uuid.NullUUID
+	CreatedAt time.Time
+	ReadAt    sql.NullTime
+}
+
+type NotificationPreference struct {
+	UserID  uuid.UUID
+	Type    string
+	Enabled bool
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	SecretHash   sql.NullString
+	Name         string
+	RedirectUris []string
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type PinnedChirp struct {
+	UserID   uuid.UUID
+	ChirpID  uuid.UUID
+	Position int32
+}
+
+type Poll struct {
+	ChirpID         uuid.UUID
+	ClosesAt        time.Time
+	ChangeableVotes bool
+}
+
+type PollOption struct {
+	ChirpID  uuid.UUID
+	Position int32
+	Text     string
+}
+
+type PollVote struct {
+	ChirpID  uuid.UUID
+	UserID   uuid.UUID
+	Position int32
+	VotedAt  time.Time
+}
+
+type ProcessedWebhookEvent struct {
+	EventID     string
+	EventType   string
+	ProcessedAt time.Time
+}
+
+type RealtimeMessage struct {
+	ID        uuid.UUID
+	Topic     string
+	Event     string
+	Data      json.RawMessage
+	Sender    uuid.NullUUID
+	Author    uuid.NullUUID
+	CreatedAt time.Time
 }
 
 type RefreshToken struct {
@@ -26,6 +206,30 @@ type RefreshToken struct {
 	UserID    uuid.UUID
 	ExpiresAt sql.NullTime
 	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     sql.NullString
+}
+
+type Subscription struct {
+	ID                 uuid.UUID
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   sql.NullTime
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+type SubscriptionEvent struct {
+	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	EventType      string
+	Plan           string
+	Status         string
+	PeriodStart    time.Time
+	PeriodEnd      sql.NullTime
+	CreatedAt      time.Time
 }
 
 type User struct {
@@ -35,4 +239,65 @@ type User struct {
 	Email          sql.NullString
 	HashedPassword sql.NullString
 	IsChirpyRed    sql.NullBool
+	Role           string
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserMute struct {
+	MuterID   uuid.UUID
+	MutedID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          uuid.UUID
+	OutboxID    uuid.UUID
+	AttemptedAt time.Time
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+	DurationMs  int32
+}
+
+type WebhookEventLog struct {
+	ID          uuid.UUID
+	ReceivedAt  time.Time
+	Headers     json.RawMessage
+	RawBody     []byte
+	EventID     sql.NullString
+	EventType   sql.NullString
+	Verified    bool
+	Outcome     string
+	Error       sql.NullString
+	ProcessedAt sql.NullTime
+	ReplayOf    uuid.NullUUID
+}
+
+type WebhookOutbox struct {
+	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastError      sql.NullString
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	ClientID   sql.NullString
+	Url        string
+	Secret     string
+	EventTypes []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
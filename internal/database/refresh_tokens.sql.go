@@ -8,10 +8,43 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const claimRefreshToken = `-- name: ClaimRefreshToken :one
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE token = $1
+    AND client_id = $2::text
+    AND revoked_at IS NULL
+    AND expires_at > $3::timestamp
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
+`
+
+type ClaimRefreshTokenParams struct {
+	Token    string
+	ClientID string
+	Now      time.Time
+}
+
+func (q *Queries) ClaimRefreshToken(ctx context.Context, arg ClaimRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, claimRefreshToken, arg.Token, arg.ClientID, arg.Now)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
 const deleteRefreshTokens = `-- name: DeleteRefreshTokens :exec
 DELETE FROM refresh_tokens
 `
@@ -22,7 +55,7 @@ func (q *Queries) DeleteRefreshTokens(ctx context.Context) error {
 }
 
 const grabRefreshToken = `-- name: GrabRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope FROM refresh_tokens
 WHERE token = $1
 `
 
@@ -36,6 +69,8 @@ func (q *Queries) GrabRefreshToken(ctx context.Context, token string) (RefreshTo
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
@@ -52,25 +87,35 @@ func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
 }
 
 const storeRefreshToken = `-- name: StoreRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, client_id, scope)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
 `
 
 type StoreRefreshTokenParams struct {
 	Token     string
 	UserID    uuid.UUID
 	ExpiresAt sql.NullTime
+	ClientID  sql.NullString
+	Scope     sql.NullString
 }
 
 func (q *Queries) StoreRefreshToken(ctx context.Context, arg StoreRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, storeRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, storeRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.ClientID,
+		arg.Scope,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -79,6 +124,8 @@ func (q *Queries) StoreRefreshToken(ctx context.Context, arg StoreRefreshTokenPa
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
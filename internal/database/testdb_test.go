@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testQueries runs the migrations in a schema of their own on the database at
+// CHIRPY_TEST_DB_URL and returns queries against it. The schema is dropped when the test ends.
+// Tests using it are skipped when the variable isn't set.
+func testQueries(t *testing.T) *Queries {
+	t.Helper()
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL is not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection, so the search_path set below applies to every query
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA "+schema+"; SET search_path TO "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE") })
+
+	files, err := filepath.Glob("../../sql/schema/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(migration), "-- +goose down")
+		if _, err := db.ExecContext(ctx, up); err != nil {
+			t.Fatalf("migrating %s: %v", filepath.Base(file), err)
+		}
+	}
+	return New(db)
+}
+
+func createTestUser(t *testing.T, q *Queries, email string) User {
+	t.Helper()
+	user, err := q.CreateUser(context.Background(), CreateUserParams{
+		Email:          sql.NullString{String: email, Valid: true},
+		HashedPassword: sql.NullString{String: "unused", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createTestChirp(t *testing.T, q *Queries, author User, body string) Chirp {
+	t.Helper()
+	chirp, err := q.CreateChirp(context.Background(), CreateChirpParams{
+		Body:   sql.NullString{String: body, Valid: true},
+		UserID: uuid.NullUUID{UUID: author.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
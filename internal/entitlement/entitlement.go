@@ -0,0 +1,144 @@
+// Package entitlement decides what free and Chirpy Red users may do. Limits live in a
+// Config, loaded from JSON, so premium features are tuned in one place instead of with
+// checks scattered through the handlers.
+package entitlement
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type Entitlements struct {
+	MaxChirpLength int      `json:"max_chirp_length"`
+	CanEditChirps  bool     `json:"can_edit_chirps"`
+	ChirpsPerHour  int      `json:"chirps_per_hour"` // 0 means unlimited
+	Badges         []string `json:"badges"`
+	// BookmarkFolders is how many bookmark folders a user may have; 0 means none.
+	BookmarkFolders int `json:"bookmark_folders"`
+}
+
+type Config struct {
+	Free      Entitlements `json:"free"`
+	ChirpyRed Entitlements `json:"chirpy_red"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Free: Entitlements{
+			MaxChirpLength: 140,
+			CanEditChirps:  false,
+			ChirpsPerHour:  30,
+			Badges:         []string{},
+		},
+		ChirpyRed: Entitlements{
+			MaxChirpLength:  280,
+			CanEditChirps:   true,
+			ChirpsPerHour:   300,
+			Badges:          []string{"chirpy_red"},
+			BookmarkFolders: 50,
+		},
+	}
+}
+
+// LoadConfig reads a JSON config file. Tiers or fields it leaves out keep their defaults.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// For returns the entitlements of a free or Chirpy Red user.
+func (c Config) For(isChirpyRed bool) Entitlements {
+	if isChirpyRed {
+		return c.ChirpyRed
+	}
+	return c.Free
+}
+
+// Badges returns the profile badges a user's tier shows. It never returns nil so the
+// JSON field is always a list.
+func (c Config) Badges(isChirpyRed bool) []string {
+	badges := c.For(isChirpyRed).Badges
+	if badges == nil {
+		return []string{}
+	}
+	return badges
+}
+
+// ErrChirpTooLong is returned when a chirp is longer than any tier allows.
+var ErrChirpTooLong = errors.New("chirp is too long")
+
+// Denied is returned when a user's tier doesn't include a feature. Upgrade is set when
+// Chirpy Red would allow it, so the caller can answer 402 rather than 403.
+type Denied struct {
+	Feature string
+	Reason  string
+	Upgrade bool
+}
+
+func (d *Denied) Error() string {
+	return d.Reason
+}
+
+// CheckChirpLength reports whether a chirp of length characters may be posted.
+func (c Config) CheckChirpLength(isChirpyRed bool, length int) error {
+	if length <= c.For(isChirpyRed).MaxChirpLength {
+		return nil
+	}
+	if !isChirpyRed && length <= c.ChirpyRed.MaxChirpLength {
+		return &Denied{
+			Feature: "long_chirps",
+			Reason:  fmt.Sprintf("Chirps over %d characters need Chirpy Red", c.Free.MaxChirpLength),
+			Upgrade: true,
+		}
+	}
+	return ErrChirpTooLong
+}
+
+// CheckEditChirps reports whether the user may edit their chirps.
+func (c Config) CheckEditChirps(isChirpyRed bool) error {
+	if c.For(isChirpyRed).CanEditChirps {
+		return nil
+	}
+	return &Denied{
+		Feature: "edit_chirps",
+		Reason:  "Editing chirps needs Chirpy Red",
+		Upgrade: !isChirpyRed && c.ChirpyRed.CanEditChirps,
+	}
+}
+
+// CheckBookmarkFolders reports whether the user may organise bookmarks into folders.
+func (c Config) CheckBookmarkFolders(isChirpyRed bool) error {
+	if c.For(isChirpyRed).BookmarkFolders > 0 {
+		return nil
+	}
+	return &Denied{
+		Feature: "bookmark_folders",
+		Reason:  "Bookmark folders need Chirpy Red",
+		Upgrade: !isChirpyRed && c.ChirpyRed.BookmarkFolders > 0,
+	}
+}
+
+// CheckBookmarkFolderCount reports whether a user with existing folders may create another.
+func (c Config) CheckBookmarkFolderCount(isChirpyRed bool, existing int) error {
+	if err := c.CheckBookmarkFolders(isChirpyRed); err != nil {
+		return err
+	}
+	limit := c.For(isChirpyRed).BookmarkFolders
+	if existing < limit {
+		return nil
+	}
+	return &Denied{
+		Feature: "bookmark_folders",
+		Reason:  fmt.Sprintf("You can have at most %d bookmark folders", limit),
+		Upgrade: !isChirpyRed && existing < c.ChirpyRed.BookmarkFolders,
+	}
+}
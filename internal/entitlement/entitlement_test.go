@@ -0,0 +1,82 @@
+package entitlement
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckChirpLength(t *testing.T) {
+	cfg := DefaultConfig()
+
+	if err := cfg.CheckChirpLength(false, 140); err != nil {
+		t.Errorf("140 characters should be fine for free users: %v", err)
+	}
+
+	var denied *Denied
+	err := cfg.CheckChirpLength(false, 200)
+	if !errors.As(err, &denied) || !denied.Upgrade {
+		t.Errorf("free user over the free limit should be told to upgrade, got %v", err)
+	}
+	if err := cfg.CheckChirpLength(true, 200); err != nil {
+		t.Errorf("chirpy red user within limit: %v", err)
+	}
+	if err := cfg.CheckChirpLength(false, 500); !errors.Is(err, ErrChirpTooLong) {
+		t.Errorf("over every limit: got %v, want ErrChirpTooLong", err)
+	}
+	if err := cfg.CheckChirpLength(true, 500); !errors.Is(err, ErrChirpTooLong) {
+		t.Errorf("over every limit: got %v, want ErrChirpTooLong", err)
+	}
+}
+
+func TestCheckEditChirps(t *testing.T) {
+	cfg := DefaultConfig()
+
+	var denied *Denied
+	if err := cfg.CheckEditChirps(false); !errors.As(err, &denied) || !denied.Upgrade {
+		t.Errorf("free user editing should be told to upgrade, got %v", err)
+	}
+	if err := cfg.CheckEditChirps(true); err != nil {
+		t.Errorf("chirpy red user editing: %v", err)
+	}
+
+	cfg.ChirpyRed.CanEditChirps = false
+	if err := cfg.CheckEditChirps(false); !errors.As(err, &denied) || denied.Upgrade {
+		t.Errorf("upgrading wouldn't help, so no upgrade prompt expected, got %v", err)
+	}
+}
+
+func TestCheckBookmarkFolders(t *testing.T) {
+	cfg := DefaultConfig()
+
+	var denied *Denied
+	if err := cfg.CheckBookmarkFolders(false); !errors.As(err, &denied) || !denied.Upgrade {
+		t.Errorf("free user using folders should be told to upgrade, got %v", err)
+	}
+	if err := cfg.CheckBookmarkFolderCount(true, 49); err != nil {
+		t.Errorf("chirpy red user under the folder limit: %v", err)
+	}
+	if err := cfg.CheckBookmarkFolderCount(true, 50); !errors.As(err, &denied) || denied.Upgrade {
+		t.Errorf("chirpy red user at the folder limit can't upgrade further, got %v", err)
+	}
+}
+
+func TestLoadConfigKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entitlements.json")
+	err := os.WriteFile(path, []byte(`{"chirpy_red": {"max_chirp_length": 500}}`), 0o600)
+	if err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if cfg.ChirpyRed.MaxChirpLength != 500 {
+		t.Errorf("got red max length %d, want 500", cfg.ChirpyRed.MaxChirpLength)
+	}
+	if cfg.Free.MaxChirpLength != 140 || !cfg.ChirpyRed.CanEditChirps {
+		t.Errorf("fields missing from the file should keep their defaults: %+v", cfg)
+	}
+}
@@ -0,0 +1,295 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultTimeout bounds a whole fetch, redirects and reading the page included.
+	DefaultTimeout = 5 * time.Second
+	// CacheTTL is how long a fetched preview, or the lack of one, is reused for the same URL.
+	CacheTTL = time.Hour
+
+	maxRedirects   = 3
+	maxBodySize    = 512 << 10
+	maxCacheSize   = 1000
+	maxTitleLength = 200
+	maxDescLength  = 300
+	userAgent      = "Chirpy-LinkPreview/1.0 (+https://chirpy.local)"
+)
+
+var (
+	// ErrBlocked means the link leads somewhere previews aren't fetched from, such as a
+	// private address.
+	ErrBlocked = errors.New("link leads to an address previews aren't fetched from")
+	// ErrNoPreview means the page was fetched but has nothing to show a card for.
+	ErrNoPreview = errors.New("link has no preview")
+)
+
+// Preview is what a link's card shows. URL is the link as it appeared in the chirp.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Fetcher fetches previews and remembers them for CacheTTL. It's safe for concurrent use.
+type Fetcher struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	now   func() time.Time
+}
+
+type cacheEntry struct {
+	preview Preview
+	err     error
+	expires time.Time
+}
+
+// NewFetcher returns a Fetcher that only connects to addresses allow accepts. Pass nil to allow
+// only public ones, as PublicAddr decides.
+func NewFetcher(allow func(netip.AddrPort) bool) *Fetcher {
+	if allow == nil {
+		allow = PublicAddr
+	}
+	dialer := &net.Dialer{
+		Timeout: DefaultTimeout,
+		// Checked once the name has been resolved, so DNS can't point a public name at a
+		// private address between a check and the connection
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addrPort) {
+				return fmt.Errorf("%w: %s", ErrBlocked, address)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// No proxy from the environment: it would be the one connecting, unchecked
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   DefaultTimeout,
+		ResponseHeaderTimeout: DefaultTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   DefaultTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("%w: too many redirects", ErrNoPreview)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("%w: redirect to %s", ErrBlocked, req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		cache: make(map[string]cacheEntry),
+		now:   time.Now,
+	}
+}
+
+// blockedPrefixes are ranges that aren't private in netip's terms but still aren't the public
+// internet.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which can reach any IPv4 address
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// PublicAddr reports whether addrPort is on the public internet.
+func PublicAddr(addrPort netip.AddrPort) bool {
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetch returns the preview for link. Errors other than ErrBlocked and ErrNoPreview may be
+// worth trying again later.
+func (f *Fetcher) Fetch(ctx context.Context, link string) (Preview, error) {
+	if entry, ok := f.cached(link); ok {
+		return entry.preview, entry.err
+	}
+	preview, err := f.fetch(ctx, link)
+	if err == nil || errors.Is(err, ErrBlocked) || errors.Is(err, ErrNoPreview) {
+		f.remember(link, preview, err)
+	}
+	return preview, err
+}
+
+func (f *Fetcher) fetch(ctx context.Context, link string) (Preview, error) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return Preview{}, fmt.Errorf("%w: not an http link", ErrNoPreview)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Preview{}, fmt.Errorf("%w: %v", ErrNoPreview, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return Preview{}, fmt.Errorf("link answered %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("%w: link answered %d", ErrNoPreview, resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Preview{}, fmt.Errorf("%w: link is %s", ErrNoPreview, mediaType)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return Preview{}, err
+	}
+
+	preview := parse(string(page), resp.Request.URL)
+	if preview.Title == "" {
+		return Preview{}, ErrNoPreview
+	}
+	preview.URL = link
+	return preview, nil
+}
+
+func (f *Fetcher) cached(link string) (cacheEntry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.cache[link]
+	if !ok || f.now().After(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (f *Fetcher) remember(link string, preview Preview, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if len(f.cache) >= maxCacheSize {
+		for key, entry := range f.cache {
+			if now.After(entry.expires) {
+				delete(f.cache, key)
+			}
+		}
+	}
+	if len(f.cache) >= maxCacheSize {
+		// Still full of live entries; drop any one of them
+		for key := range f.cache {
+			delete(f.cache, key)
+			break
+		}
+	}
+	f.cache[link] = cacheEntry{preview: preview, err: err, expires: now.Add(CacheTTL)}
+}
+
+var (
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// parse reads a page's Open Graph tags, falling back to Twitter card tags, the description
+// meta tag and the title element. Relative image URLs are resolved against base.
+func parse(page string, base *url.URL) Preview {
+	// Only the head matters
+	if end := strings.Index(strings.ToLower(page), "</head>"); end >= 0 {
+		page = page[:end]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := Preview{
+		Title:       first("og:title", "twitter:title"),
+		Description: truncate(first("og:description", "twitter:description", "description"), maxDescLength),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			preview.Title = clean(match[1])
+		}
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	if image := first("og:image:secure_url", "og:image", "twitter:image"); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+	return preview
+}
+
+// clean unescapes HTML entities and collapses whitespace.
+func clean(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
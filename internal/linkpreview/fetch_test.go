@@ -0,0 +1,213 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPage = `<!doctype html>
+<html><head>
+<title>Fallback title</title>
+<meta property="og:title" content="Chirpy &amp; friends">
+<meta property='og:description' content='A   place
+  to chirp'>
+<meta content="/img/card.png" property="og:image">
+<meta property="og:site_name" content="Chirpy">
+</head>
+<body><meta property="og:title" content="Not in the head"></body></html>`
+
+// allowOnly lets the fetcher reach the test servers given, which are on loopback like
+// everything else a test could reach.
+func allowOnly(servers ...*httptest.Server) func(netip.AddrPort) bool {
+	return func(addrPort netip.AddrPort) bool {
+		for _, srv := range servers {
+			if srv.Listener.Addr().String() == addrPort.String() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestFetch(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(allowOnly(srv))
+	link := srv.URL + "/post"
+	preview, err := f.Fetch(context.Background(), link)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	want := Preview{
+		URL:         link,
+		Title:       "Chirpy & friends",
+		Description: "A place to chirp",
+		ImageURL:    srv.URL + "/img/card.png",
+		SiteName:    "Chirpy",
+	}
+	if preview != want {
+		t.Errorf("got %+v\nwant %+v", preview, want)
+	}
+
+	// A second fetch comes from the cache until it expires
+	if _, err := f.Fetch(context.Background(), link); err != nil || hits.Load() != 1 {
+		t.Errorf("second fetch: err %v, server hit %d times, want 1", err, hits.Load())
+	}
+	f.now = func() time.Time { return time.Now().Add(CacheTTL + time.Minute) }
+	if _, err := f.Fetch(context.Background(), link); err != nil || hits.Load() != 2 {
+		t.Errorf("fetch after expiry: err %v, server hit %d times, want 2", err, hits.Load())
+	}
+}
+
+func TestFetchFallsBackToTitle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title> Plain
+page </title><meta name="description" content="Just a page"></head></html>`))
+	}))
+	defer srv.Close()
+
+	preview, err := NewFetcher(allowOnly(srv)).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "Plain page" || preview.Description != "Just a page" {
+		t.Errorf("got title %q description %q", preview.Title, preview.Description)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	// The default fetcher won't touch loopback, however the link is spelled
+	f := NewFetcher(nil)
+	port := srv.URL[strings.LastIndex(srv.URL, ":")+1:]
+	for _, link := range []string{srv.URL, "http://localhost:" + port, "http://[::1]:" + port} {
+		if _, err := f.Fetch(context.Background(), link); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Fetch(%s): got %v, want ErrBlocked", link, err)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Errorf("blocked server was hit %d times", hits.Load())
+	}
+}
+
+func TestFetchBlocksRedirectToPrivateAddress(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+	}))
+	defer internal.Close()
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/admin", http.StatusFound)
+	}))
+	defer public.Close()
+
+	_, err := NewFetcher(allowOnly(public)).Fetch(context.Background(), public.URL)
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("got %v, want ErrBlocked", err)
+	}
+	if internalHits.Load() != 0 {
+		t.Error("redirect reached the internal server")
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	f := NewFetcher(allowOnly(srv))
+	_, err := f.Fetch(ctx, srv.URL)
+	if err == nil || errors.Is(err, ErrBlocked) || errors.Is(err, ErrNoPreview) {
+		t.Fatalf("got %v, want a timeout worth retrying", err)
+	}
+	if _, ok := f.cached(srv.URL); ok {
+		t.Error("a failure worth retrying was cached")
+	}
+}
+
+func TestFetchWithoutPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		case "/empty":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>no head</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(allowOnly(srv))
+	for _, path := range []string{"/image", "/empty", "/missing"} {
+		if _, err := f.Fetch(context.Background(), srv.URL+path); !errors.Is(err, ErrNoPreview) {
+			t.Errorf("%s: got %v, want ErrNoPreview", path, err)
+		}
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:80", false},
+		{"0.0.0.0:80", false},
+		{"[::1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[fd00::1]:80", false},
+		{"[fe80::1]:80", false},
+		{"[64:ff9b::a00:1]:80", false},
+	}
+	for _, tt := range tests {
+		if got := PublicAddr(netip.MustParseAddrPort(tt.addr)); got != tt.want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestParseResolvesImageAgainstFinalURL(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	preview := parse(`<meta property="og:title" content="t"><meta property="og:image" content="cover.jpg">`, base)
+	if preview.ImageURL != "https://example.com/blog/cover.jpg" {
+		t.Errorf("got %q", preview.ImageURL)
+	}
+	preview = parse(`<meta property="og:title" content="t"><meta property="og:image" content="javascript:alert(1)">`, base)
+	if preview.ImageURL != "" {
+		t.Errorf("kept image %q", preview.ImageURL)
+	}
+}
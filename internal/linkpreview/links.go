@@ -0,0 +1,94 @@
+// Package linkpreview finds links in chirps and fetches the Open Graph metadata their preview
+// cards are drawn from. Fetching only ever connects to public addresses, so a chirp can't be
+// used to make the server reach into its own network.
+package linkpreview
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// URLLength is how many characters a link counts for toward a chirp's length, however long
+	// it really is.
+	URLLength = 23
+	// MaxURLLength is the longest a link in a chirp may really be, in bytes.
+	MaxURLLength = 2048
+	// MaxBodyBytes caps the real size of a chirp, which links counting as URLLength would
+	// otherwise leave unbounded.
+	MaxBodyBytes = 8 << 10
+)
+
+var (
+	ErrURLTooLong  = errors.New("link is too long")
+	ErrBodyTooLong = errors.New("chirp is too many bytes")
+)
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// find returns where each link in body starts and ends. Punctuation ending a sentence isn't
+// part of the link, nor is a closing bracket with no opening one inside the link.
+func find(body string) [][2]int {
+	var spans [][2]int
+	for _, match := range urlPattern.FindAllStringIndex(body, -1) {
+		start, end := match[0], match[1]
+		for end > start {
+			last := body[end-1]
+			if strings.IndexByte(".,:;!?'*", last) >= 0 {
+				end--
+				continue
+			}
+			if last == ')' && strings.Count(body[start:end], "(") < strings.Count(body[start:end], ")") {
+				end--
+				continue
+			}
+			break
+		}
+		u, err := url.Parse(body[start:end])
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+	return spans
+}
+
+// URLs returns the distinct links in body in the order they first appear.
+func URLs(body string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, span := range find(body) {
+		link := body[span[0]:span[1]]
+		if !seen[link] {
+			seen[link] = true
+			urls = append(urls, link)
+		}
+	}
+	return urls
+}
+
+// CheckSize reports whether body fits in MaxBodyBytes and each of its links in MaxURLLength.
+// Check it before Length, which only sees links as URLLength characters.
+func CheckSize(body string) error {
+	if len(body) > MaxBodyBytes {
+		return ErrBodyTooLong
+	}
+	for _, span := range find(body) {
+		if span[1]-span[0] > MaxURLLength {
+			return ErrURLTooLong
+		}
+	}
+	return nil
+}
+
+// Length is the length of body for the chirp length limit, with every link counted as
+// URLLength characters.
+func Length(body string) int {
+	length := len(body)
+	for _, span := range find(body) {
+		length += URLLength - (span[1] - span[0])
+	}
+	return length
+}
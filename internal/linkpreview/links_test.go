@@ -0,0 +1,65 @@
+package linkpreview
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no links here", nil},
+		{"see https://example.com/a?b=c.", []string{"https://example.com/a?b=c"}},
+		{"(read http://example.com/post)", []string{"http://example.com/post"}},
+		{"https://en.wikipedia.org/wiki/Go_(language), neat", []string{"https://en.wikipedia.org/wiki/Go_(language)"}},
+		{"HTTPS://Example.com twice HTTPS://Example.com and https://b.example", []string{"HTTPS://Example.com", "https://b.example"}},
+		{"not a link: http:// or ftp://example.com", nil},
+	}
+	for _, tt := range tests {
+		if got := URLs(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("URLs(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	long := "https://example.com/" + strings.Repeat("a", 200)
+	tests := []struct {
+		body string
+		want int
+	}{
+		{"hello", 5},
+		{"hi " + long, 3 + URLLength},
+		{"https://a.co", URLLength},
+		{long + " " + long + "!", 2*URLLength + 2},
+	}
+	for _, tt := range tests {
+		if got := Length(tt.body); got != tt.want {
+			t.Errorf("Length(%.30q...) = %d, want %d", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	link := "https://example.com/"
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"short", "hi " + link, nil},
+		{"longest link", link + strings.Repeat("a", MaxURLLength-len(link)), nil},
+		{"link too long", "hi " + link + strings.Repeat("a", MaxURLLength), ErrURLTooLong},
+		{"too many bytes", strings.Repeat(link+"a ", MaxBodyBytes/len(link)), ErrBodyTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSize(tt.body); got != tt.want {
+				t.Errorf("CheckSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package loginguard tracks failed login attempts per account and per client IP
+// and locks either one out with exponential backoff once it crosses a threshold.
+package loginguard
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	AccountThreshold int           // failures allowed per account before it is locked
+	IPThreshold      int           // failures allowed per IP before it is locked
+	BaseLockout      time.Duration // lockout applied when a threshold is first crossed
+	MaxLockout       time.Duration // upper bound for the doubling lockout
+	ResetAfter       time.Duration // quiet period after which failures are forgotten
+}
+
+func DefaultConfig() Config {
+	return Config{
+		AccountThreshold: 5,
+		IPThreshold:      20,
+		BaseLockout:      30 * time.Second,
+		MaxLockout:       time.Hour,
+		ResetAfter:       15 * time.Minute,
+	}
+}
+
+type record struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type Guard struct {
+	mu        sync.Mutex
+	cfg       Config
+	accounts  map[string]*record
+	ips       map[string]*record
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func New(cfg Config) *Guard {
+	return &Guard{
+		cfg:      cfg,
+		accounts: make(map[string]*record),
+		ips:      make(map[string]*record),
+		now:      time.Now,
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check reports whether a login attempt for email from ip may proceed. When it
+// may not, the returned duration is how long until the lockout ends.
+func (g *Guard) Check(email, ip string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	if rec, ok := g.accounts[normalizeEmail(email)]; ok && rec.lockedUntil.After(now) {
+		wait = rec.lockedUntil.Sub(now)
+	}
+	if rec, ok := g.ips[ip]; ok && rec.lockedUntil.After(now) {
+		wait = max(wait, rec.lockedUntil.Sub(now))
+	}
+	return wait, wait == 0
+}
+
+// Fail records a failed login attempt for both the account and the IP.
+func (g *Guard) Fail(email, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.prune(now)
+	g.fail(g.accounts, normalizeEmail(email), g.cfg.AccountThreshold, now)
+	g.fail(g.ips, ip, g.cfg.IPThreshold, now)
+}
+
+func (g *Guard) fail(records map[string]*record, key string, threshold int, now time.Time) {
+	rec, ok := records[key]
+	if !ok || g.stale(rec, now) {
+		rec = &record{}
+		records[key] = rec
+	}
+	rec.failures++
+	rec.lastFailure = now
+	if rec.failures >= threshold {
+		rec.lockedUntil = now.Add(g.lockout(rec.failures - threshold))
+	}
+}
+
+// lockout doubles the base lockout for every failure past the threshold.
+func (g *Guard) lockout(over int) time.Duration {
+	d := g.cfg.BaseLockout
+	for i := 0; i < over && d < g.cfg.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxLockout)
+}
+
+func (g *Guard) stale(rec *record, now time.Time) bool {
+	return !rec.lockedUntil.After(now) && now.Sub(rec.lastFailure) > g.cfg.ResetAfter
+}
+
+// prune drops forgotten records, at most once per ResetAfter period.
+func (g *Guard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < g.cfg.ResetAfter {
+		return
+	}
+	g.lastPrune = now
+	for _, records := range []map[string]*record{g.accounts, g.ips} {
+		for key, rec := range records {
+			if g.stale(rec, now) {
+				delete(records, key)
+			}
+		}
+	}
+}
+
+// Succeed clears the failure history of an account after a successful login.
+// The IP record is left alone so one good login can't reset a spraying attack.
+func (g *Guard) Succeed(email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.accounts, normalizeEmail(email))
+}
+
+// Unlock clears any lockout and failure history for an account. It reports
+// whether there was anything to clear.
+func (g *Guard) Unlock(email string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := normalizeEmail(email)
+	_, ok := g.accounts[key]
+	delete(g.accounts, key)
+	return ok
+}
+
+// UnlockIP clears any lockout and failure history for a client IP.
+func (g *Guard) UnlockIP(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.ips[ip]
+	delete(g.ips, ip)
+	return ok
+}
@@ -0,0 +1,95 @@
+package loginguard
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestGuard() (*Guard, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := New(Config{
+		AccountThreshold: 3,
+		IPThreshold:      5,
+		BaseLockout:      time.Minute,
+		MaxLockout:       8 * time.Minute,
+		ResetAfter:       15 * time.Minute,
+	})
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestAccountLockoutBackoff(t *testing.T) {
+	g, now := newTestGuard()
+
+	for i := 0; i < 2; i++ {
+		g.Fail("a@example.com", "10.0.0.1")
+	}
+	if _, ok := g.Check("a@example.com", "10.0.0.1"); !ok {
+		t.Fatal("locked out before reaching the threshold")
+	}
+
+	wants := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 8 * time.Minute}
+	for _, want := range wants {
+		g.Fail("A@example.com", "10.0.0.2")
+		wait, ok := g.Check("a@example.com", "10.0.0.3")
+		if ok || wait != want {
+			t.Fatalf("got wait %v ok %v, want wait %v", wait, ok, want)
+		}
+		*now = now.Add(wait)
+	}
+	if _, ok := g.Check("a@example.com", "10.0.0.3"); !ok {
+		t.Error("still locked after the lockout expired")
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	g, _ := newTestGuard()
+
+	for i := 0; i < 5; i++ {
+		g.Fail("user"+string(rune('a'+i))+"@example.com", "10.0.0.1")
+	}
+	if _, ok := g.Check("new@example.com", "10.0.0.1"); ok {
+		t.Error("ip should be locked after spraying accounts")
+	}
+	if _, ok := g.Check("new@example.com", "10.0.0.2"); !ok {
+		t.Error("other ips should not be affected")
+	}
+	if !g.UnlockIP("10.0.0.1") {
+		t.Error("expected ip record to be cleared")
+	}
+	if _, ok := g.Check("new@example.com", "10.0.0.1"); !ok {
+		t.Error("ip still locked after unlock")
+	}
+}
+
+func TestSucceedAndUnlock(t *testing.T) {
+	g, now := newTestGuard()
+
+	g.Fail("a@example.com", "10.0.0.1")
+	g.Fail("a@example.com", "10.0.0.1")
+	g.Succeed("a@example.com")
+	g.Fail("a@example.com", "10.0.0.1")
+	if _, ok := g.Check("a@example.com", "10.0.0.1"); !ok {
+		t.Error("success should reset the account failure count")
+	}
+
+	g.Fail("a@example.com", "10.0.0.2")
+	g.Fail("a@example.com", "10.0.0.2")
+	if _, ok := g.Check("a@example.com", "10.0.0.3"); ok {
+		t.Fatal("expected account to be locked")
+	}
+	if !g.Unlock("a@example.com") {
+		t.Error("expected account record to be cleared")
+	}
+	if _, ok := g.Check("a@example.com", "10.0.0.3"); !ok {
+		t.Error("account still locked after unlock")
+	}
+
+	g.Fail("b@example.com", "10.0.0.9")
+	g.Fail("b@example.com", "10.0.0.9")
+	*now = now.Add(16 * time.Minute)
+	g.Fail("b@example.com", "10.0.0.9")
+	if _, ok := g.Check("b@example.com", "10.0.0.9"); !ok {
+		t.Error("failures older than ResetAfter should be forgotten")
+	}
+}
@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCaptureSender(t *testing.T) {
+	capture := &CaptureSender{}
+	msgs := []Message{
+		{To: "walt@breakingbad.com", Subject: "one", Body: "first"},
+		{To: "jesse@breakingbad.com", Subject: "two", Body: "second"},
+	}
+	for _, msg := range msgs {
+		if err := capture.Send(context.Background(), msg); err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+
+	got := capture.Messages()
+	if len(got) != len(msgs) {
+		t.Fatalf("got %d messages, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i] != msgs[i] {
+			t.Errorf("message %d: got %+v, want %+v", i, got[i], msgs[i])
+		}
+	}
+}
+
+func TestSMTPSenderRejectsHeaderInjection(t *testing.T) {
+	sender := &SMTPSender{Host: "localhost", Port: "1", From: "noreply@chirpy.local"}
+	err := sender.Send(context.Background(), Message{
+		To:      "walt@breakingbad.com\r\nBcc: everyone@example.com",
+		Subject: "hi",
+	})
+	if err == nil {
+		t.Error("expected error for newline in recipient")
+	}
+}
@@ -0,0 +1,65 @@
+package media
+
+import "encoding/binary"
+
+// scanGIF walks the blocks of a GIF without decompressing any of them, counting its frames
+// and the pixels they cover, so a small file that would decode into gigabytes can be turned
+// away before it's decoded.
+func scanGIF(data []byte) (frames int, pixels int64, ok bool) {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, false
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			return frames, pixels, true
+		case 0x21: // extension: label, then sub-blocks
+			i += 2
+			if i, ok = skipSubBlocks(data, i); !ok {
+				return 0, 0, false
+			}
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return 0, 0, false
+			}
+			width := int64(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int64(binary.LittleEndian.Uint16(data[i+7:]))
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size, then the image data sub-blocks
+			i++
+			if i, ok = skipSubBlocks(data, i); !ok {
+				return 0, 0, false
+			}
+			frames++
+			pixels += width * height
+		default:
+			return 0, 0, false
+		}
+	}
+	// No trailer; the decoder accepts that as long as the frames are whole
+	return frames, pixels, true
+}
+
+func skipSubBlocks(data []byte, i int) (int, bool) {
+	for {
+		if i >= len(data) {
+			return 0, false
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, true
+		}
+		i += size
+	}
+}
@@ -0,0 +1,240 @@
+// Package media checks and cleans up images attached to chirps. Uploads are identified by
+// their content rather than their name, re-encoded so no metadata such as EXIF location data
+// survives, and given a thumbnail. Where the files are kept is up to a Store.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	TypePNG  = "image/png"
+	TypeJPEG = "image/jpeg"
+	TypeGIF  = "image/gif"
+	TypeWebP = "image/webp"
+)
+
+const (
+	MaxUploadSize = 5 << 20
+	// MaxDimension caps width and height, so a small file can't decode into a huge image.
+	MaxDimension = 4096
+	// MaxGIFFrames caps how many frames an animated GIF may have.
+	MaxGIFFrames = 300
+	// MaxGIFPixels caps the pixels of all of a GIF's frames together, since each is decoded
+	// into memory of its own.
+	MaxGIFPixels = 64 << 20
+	// ThumbnailSize is the longest side of a thumbnail.
+	ThumbnailSize = 320
+
+	jpegQuality = 90
+	// maxConcurrentDecodes bounds how many images are decoded at once. A 4096 pixel square
+	// takes 64 MB as RGBA, and orienting and thumbnailing copy it.
+	maxConcurrentDecodes = 2
+)
+
+// decodeSlots is a semaphore held while an image is decoded and re-encoded.
+var decodeSlots = make(chan struct{}, maxConcurrentDecodes)
+
+func acquireDecodeSlot() func() {
+	decodeSlots <- struct{}{}
+	return func() { <-decodeSlots }
+}
+
+var (
+	ErrTooLarge        = fmt.Errorf("images can be at most %d MB", MaxUploadSize>>20)
+	ErrUnsupportedType = errors.New("only PNG, JPEG, GIF and WebP images are supported")
+	ErrInvalidImage    = errors.New("image could not be read")
+	ErrDimensions      = fmt.Errorf("images can be at most %d pixels wide or tall", MaxDimension)
+	ErrTooManyFrames   = fmt.Errorf("animated images can have at most %d frames", MaxGIFFrames)
+	ErrTooManyPixels   = errors.New("animated image is too large once its frames are decoded")
+)
+
+// Image is an upload ready to be stored. Thumbnail is nil for animated WebP images, which
+// can't be decoded.
+type Image struct {
+	ContentType   string
+	Data          []byte
+	Width         int
+	Height        int
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// Process sniffs what kind of image data holds, checks it, strips its metadata and makes a
+// thumbnail. PNG, JPEG and GIF images are decoded and encoded again, which drops everything
+// but the pixels; JPEGs are turned upright first, since their EXIF orientation goes with the
+// rest of the metadata. WebP images keep their pixels as they are but lose every chunk that
+// isn't image data, EXIF and XMP among them.
+func Process(data []byte) (Image, error) {
+	if len(data) > MaxUploadSize {
+		return Image{}, ErrTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case TypePNG, TypeJPEG, TypeGIF:
+	case TypeWebP:
+		return processWebP(data)
+	default:
+		return Image{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrInvalidImage
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return Image{}, ErrDimensions
+	}
+
+	if contentType == TypeGIF {
+		return processGIF(data)
+	}
+
+	defer acquireDecodeSlot()()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrInvalidImage
+	}
+	var out bytes.Buffer
+	if contentType == TypeJPEG {
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&out, img)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	thumbnail, thumbnailType, err := encodeThumbnail(img, contentType == TypeJPEG)
+	if err != nil {
+		return Image{}, err
+	}
+	bounds := img.Bounds()
+	return Image{
+		ContentType:   contentType,
+		Data:          out.Bytes(),
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		Thumbnail:     thumbnail,
+		ThumbnailType: thumbnailType,
+	}, nil
+}
+
+// processGIF re-encodes every frame of a GIF, keeping its timing and loop count but not its
+// comments or application extensions.
+func processGIF(data []byte) (Image, error) {
+	frames, pixels, ok := scanGIF(data)
+	if !ok || frames == 0 {
+		return Image{}, ErrInvalidImage
+	}
+	if frames > MaxGIFFrames {
+		return Image{}, ErrTooManyFrames
+	}
+	if pixels > MaxGIFPixels {
+		return Image{}, ErrTooManyPixels
+	}
+
+	defer acquireDecodeSlot()()
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrInvalidImage
+	}
+	var out bytes.Buffer
+	err = gif.EncodeAll(&out, &gif.GIF{
+		Image:           g.Image,
+		Delay:           g.Delay,
+		LoopCount:       g.LoopCount,
+		Disposal:        g.Disposal,
+		Config:          g.Config,
+		BackgroundIndex: g.BackgroundIndex,
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	// The thumbnail is the first frame, drawn on a canvas the size of the whole GIF
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(canvas, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+	thumbnail, thumbnailType, err := encodeThumbnail(canvas, false)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{
+		ContentType:   TypeGIF,
+		Data:          out.Bytes(),
+		Width:         g.Config.Width,
+		Height:        g.Config.Height,
+		Thumbnail:     thumbnail,
+		ThumbnailType: thumbnailType,
+	}, nil
+}
+
+// encodeThumbnail scales img down to fit in ThumbnailSize square. It's a JPEG for photos and
+// a PNG otherwise, so transparency survives.
+func encodeThumbnail(img image.Image, photo bool) ([]byte, string, error) {
+	thumb := Thumbnail(img, ThumbnailSize)
+	var out bytes.Buffer
+	if photo {
+		if err := jpeg.Encode(&out, thumb, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", err
+		}
+		return out.Bytes(), TypeJPEG, nil
+	}
+	if err := png.Encode(&out, thumb); err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), TypePNG, nil
+}
+
+// Thumbnail scales img down, keeping its aspect ratio, so neither side is longer than size.
+// Each thumbnail pixel averages the block of pixels it covers. Images that already fit are
+// only copied.
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	if dstW == srcW && dstH == srcH {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,347 @@
+package media
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+// pngChunk builds a PNG chunk with its CRC.
+func pngChunk(chunkType string, data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	out = append(out, chunkType...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+}
+
+func TestProcessPNGStripsMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+	// Slip text and EXIF chunks in after IHDR, which is 8+25 bytes into the file
+	raw := buf.Bytes()
+	withMeta := append([]byte{}, raw[:33]...)
+	withMeta = append(withMeta, pngChunk("tEXt", []byte("Comment\x00secret"))...)
+	withMeta = append(withMeta, pngChunk("eXIf", []byte("MM\x00\x2aGPSsecret"))...)
+	withMeta = append(withMeta, raw[33:]...)
+
+	img, err := Process(withMeta)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if img.ContentType != TypePNG || img.Width != 40 || img.Height != 20 {
+		t.Errorf("got %s %dx%d, want image/png 40x20", img.ContentType, img.Width, img.Height)
+	}
+	if bytes.Contains(img.Data, []byte("secret")) {
+		t.Error("metadata survived processing")
+	}
+	if img.ThumbnailType != TypePNG || len(img.Thumbnail) == 0 {
+		t.Errorf("got thumbnail %q of %d bytes, want a PNG", img.ThumbnailType, len(img.Thumbnail))
+	}
+}
+
+// exifSegment builds a JPEG APP1 segment with a big-endian EXIF block holding only an
+// orientation tag, followed by some bytes standing in for location data.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)                  // one entry
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag) // tag
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)                  // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)                  // count
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = binary.BigEndian.AppendUint32(tiff, 0) // no next IFD
+	tiff = append(tiff, "GPSsecret"...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestProcessJPEGStripsEXIFAndTurnsUpright(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(40, 20), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	withEXIF := append([]byte{}, raw[:2]...)
+	withEXIF = append(withEXIF, exifSegment(6)...)
+	withEXIF = append(withEXIF, raw[2:]...)
+
+	if got := jpegOrientation(withEXIF); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+	img, err := Process(withEXIF)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) || bytes.Contains(img.Data, []byte("GPSsecret")) {
+		t.Error("EXIF survived processing")
+	}
+	if img.Width != 20 || img.Height != 40 {
+		t.Errorf("got %dx%d, want the 40x20 image turned to 20x40", img.Width, img.Height)
+	}
+	if img.ThumbnailType != TypeJPEG {
+		t.Errorf("got thumbnail type %q, want image/jpeg", img.ThumbnailType)
+	}
+}
+
+func TestProcessGIFKeepsFrames(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 30, 30), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	out, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatalf("decoding processed GIF: %v", err)
+	}
+	if len(out.Image) != 3 {
+		t.Errorf("got %d frames, want 3", len(out.Image))
+	}
+	if img.ThumbnailType != TypePNG || len(img.Thumbnail) == 0 {
+		t.Error("GIF has no thumbnail")
+	}
+}
+
+func riffChunkBytes(fourCC string, payload []byte) []byte {
+	out := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// 1x1 WebP images: lossy, lossy with an ALPH chunk behind VP8X, and animated.
+const (
+	webpLossy    = "UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA"
+	webpAlpha    = "UklGRkoAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAwAAAARBxAR/Q9ERP8DAABWUDggGAAAABQBAJ0BKgEAAQAAAP4AAA3AAP7mtQAAAA=="
+	webpAnimated = "UklGRlIAAABXRUJQVlA4WAoAAAASAAAAAAAAAAAAQU5JTQYAAAD/////AABBTk1GJgAAAAAAAAAAAAAAAAAAAGQAAABWUDhMDQAAAC8AAAAQBxAREYiI/gcA"
+)
+
+// webpWith decodes one of the images above and adds extra chunks to the end of it.
+func webpWith(t *testing.T, image string, extra ...[]byte) []byte {
+	data, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range extra {
+		data = append(data, chunk...)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestProcessWebPKeepsOnlyImageChunks(t *testing.T) {
+	data := webpWith(t, webpAlpha,
+		riffChunkBytes("EXIF", []byte("GPSsecret")),
+		riffChunkBytes("XMP ", []byte("<x:secret/>")),
+		riffChunkBytes("ZZZZ", []byte("secret")),
+	)
+	data[20] |= vp8xFlagEXIF | vp8xFlagXMP
+
+	img, err := Process(data)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if img.ContentType != TypeWebP || img.Width != 1 || img.Height != 1 {
+		t.Errorf("got %s %dx%d, want image/webp 1x1", img.ContentType, img.Width, img.Height)
+	}
+	if bytes.Contains(img.Data, []byte("secret")) {
+		t.Error("metadata chunks survived processing")
+	}
+	chunks, ok := readRIFF(img.Data)
+	if !ok || len(chunks) != 3 {
+		t.Fatalf("got %d chunks (ok %v), want VP8X, ALPH and VP8", len(chunks), ok)
+	}
+	if chunks[0].payload[0]&(vp8xFlagEXIF|vp8xFlagXMP) != 0 {
+		t.Error("VP8X still claims EXIF or XMP")
+	}
+	// Transparency survives in a PNG thumbnail
+	if img.ThumbnailType != TypePNG || len(img.Thumbnail) == 0 {
+		t.Errorf("got thumbnail %q of %d bytes, want a PNG", img.ThumbnailType, len(img.Thumbnail))
+	}
+}
+
+func TestProcessWebPThumbnails(t *testing.T) {
+	img, err := Process(webpWith(t, webpLossy))
+	if err != nil {
+		t.Fatalf("lossy: %v", err)
+	}
+	if img.ThumbnailType != TypeJPEG || len(img.Thumbnail) == 0 {
+		t.Errorf("lossy: got thumbnail %q of %d bytes, want a JPEG", img.ThumbnailType, len(img.Thumbnail))
+	}
+
+	img, err = Process(webpWith(t, webpAnimated))
+	if err != nil {
+		t.Fatalf("animated: %v", err)
+	}
+	if img.Width != 1 || img.Height != 1 || img.Thumbnail != nil {
+		t.Errorf("animated: got %dx%d with a %d byte thumbnail, want 1x1 without one", img.Width, img.Height, len(img.Thumbnail))
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	var wide bytes.Buffer
+	if err := png.Encode(&wide, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	var small bytes.Buffer
+	if err := png.Encode(&small, testImage(10, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 1x1 canvas whose frame says it's far larger
+	hugeFrame := append([]byte("RIFF\x00\x00\x00\x00WEBP"), riffChunkBytes("VP8X", make([]byte, 10))...)
+	hugeFrame = append(hugeFrame, riffChunkBytes("VP8L", append([]byte{0x2f}, binary.LittleEndian.AppendUint32(nil, 4999|4999<<14)...))...)
+	binary.LittleEndian.PutUint32(hugeFrame[4:], uint32(len(hugeFrame)-8))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("hello, this is not an image"), ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedType},
+		{"too large", make([]byte, MaxUploadSize+1), ErrTooLarge},
+		{"truncated", small.Bytes()[:40], ErrInvalidImage},
+		{"too wide", wide.Bytes(), ErrDimensions},
+		{"bad webp", []byte("RIFF\x0c\x00\x00\x00WEBPVP8 \xff\x00\x00\x00"), ErrInvalidImage},
+		{"webp frame larger than canvas", hugeFrame, ErrDimensions},
+		{"too many frames", animatedGIF(t, MaxGIFFrames+1), ErrTooManyFrames},
+		{"gif bomb", gifBomb(5), ErrTooManyPixels},
+	}
+	for _, tt := range tests {
+		if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func animatedGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}))
+		anim.Delay = append(anim.Delay, 1)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gifBomb builds a GIF of frames full-size frames with next to no image data. Decoding it
+// would take frames*MaxDimension² bytes; it has to be turned away before that.
+func gifBomb(frames int) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, MaxDimension)
+	data = binary.LittleEndian.AppendUint16(data, MaxDimension)
+	data = append(data, 0x80, 0, 0)       // two colour global table
+	data = append(data, 0, 0, 0, 1, 1, 1) // the table
+	for i := 0; i < frames; i++ {
+		data = append(data, 0x2C, 0, 0, 0, 0)
+		data = binary.LittleEndian.AppendUint16(data, MaxDimension)
+		data = binary.LittleEndian.AppendUint16(data, MaxDimension)
+		data = append(data, 0, 2, 1, 0x44, 0) // no local table, LZW code size, one sub-block
+	}
+	return append(data, 0x3B)
+}
+
+func TestScanGIF(t *testing.T) {
+	frames, pixels, ok := scanGIF(animatedGIF(t, 7))
+	if !ok || frames != 7 || pixels != 7 {
+		t.Errorf("scanGIF = %d frames %d pixels ok %v, want 7 frames of 1 pixel", frames, pixels, ok)
+	}
+	frames, pixels, ok = scanGIF(gifBomb(3))
+	if !ok || frames != 3 || pixels != 3*MaxDimension*MaxDimension {
+		t.Errorf("scanGIF(bomb) = %d frames %d pixels ok %v", frames, pixels, ok)
+	}
+	if _, _, ok := scanGIF([]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x2C\x00")); ok {
+		t.Error("scanGIF accepted a truncated image descriptor")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		w, h, wantW, wantH int
+	}{
+		{1000, 500, 320, 160},
+		{50, 1000, 16, 320},
+		{100, 50, 100, 50},
+		{5000, 1, 320, 1},
+	}
+	for _, tt := range tests {
+		thumb := Thumbnail(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), ThumbnailSize)
+		if got := thumb.Bounds(); got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("%dx%d: got %dx%d, want %dx%d", tt.w, tt.h, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	marked := color.RGBA{255, 0, 0, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.SetRGBA(0, 0, marked)
+
+	// Where the top-left pixel of a 3x2 image ends up
+	tests := []struct {
+		orientation  int
+		wantW, wantH int
+		x, y         int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, tt := range tests {
+		out := orient(img, tt.orientation)
+		bounds := out.Bounds()
+		if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.orientation, bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			continue
+		}
+		if got := color.RGBAModel.Convert(out.At(tt.x, tt.y)); got != marked {
+			t.Errorf("orientation %d: pixel (%d,%d) is %v, want the marked pixel", tt.orientation, tt.x, tt.y, got)
+		}
+	}
+}
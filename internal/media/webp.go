@@ -0,0 +1,169 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+
+	"golang.org/x/image/webp"
+)
+
+// VP8X flags saying the file has EXIF or XMP chunks, or is animated.
+const (
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagXMP       = 0x04
+	vp8xFlagAnimation = 0x02
+)
+
+// webpChunks are the chunks a WebP image keeps: its pixels, animation and colour profile.
+// Anything else, EXIF and XMP included, is dropped.
+var webpChunks = map[string]bool{
+	"VP8 ": true,
+	"VP8L": true,
+	"VP8X": true,
+	"ALPH": true,
+	"ANIM": true,
+	"ANMF": true,
+	"ICCP": true,
+}
+
+type riffChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// processWebP checks a WebP image, keeps only the chunks in webpChunks and makes its
+// thumbnail. There's no WebP encoder to hand, so the pixels are kept as uploaded rather than
+// re-encoded. Animated images go without a thumbnail, since the decoder can't read them.
+func processWebP(data []byte) (Image, error) {
+	chunks, ok := readRIFF(data)
+	if !ok {
+		return Image{}, ErrInvalidImage
+	}
+
+	width, height := 0, 0
+	animated := false
+	kept := make([]riffChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if !webpChunks[chunk.fourCC] {
+			continue
+		}
+		switch chunk.fourCC {
+		case "VP8X":
+			if len(chunk.payload) < 10 {
+				return Image{}, ErrInvalidImage
+			}
+			payload := bytes.Clone(chunk.payload)
+			payload[0] &^= vp8xFlagEXIF | vp8xFlagXMP
+			chunk.payload = payload
+			animated = payload[0]&vp8xFlagAnimation != 0
+			width = 1 + int(uint32(payload[4])|uint32(payload[5])<<8|uint32(payload[6])<<16)
+			height = 1 + int(uint32(payload[7])|uint32(payload[8])<<8|uint32(payload[9])<<16)
+		case "VP8 ", "VP8L":
+			// The decoder goes by the frame's own size rather than the canvas in VP8X, so
+			// that's checked too
+			frameWidth, frameHeight, ok := webpFrameSize(chunk)
+			if !ok {
+				return Image{}, ErrInvalidImage
+			}
+			if frameWidth > MaxDimension || frameHeight > MaxDimension {
+				return Image{}, ErrDimensions
+			}
+			if width == 0 {
+				width, height = frameWidth, frameHeight
+			}
+		}
+		kept = append(kept, chunk)
+	}
+	if width == 0 || height == 0 {
+		return Image{}, ErrInvalidImage
+	}
+	if width > MaxDimension || height > MaxDimension {
+		return Image{}, ErrDimensions
+	}
+
+	img := Image{
+		ContentType: TypeWebP,
+		Data:        writeRIFF(kept),
+		Width:       width,
+		Height:      height,
+	}
+	if animated {
+		return img, nil
+	}
+
+	defer acquireDecodeSlot()()
+	decoded, err := webp.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return Image{}, ErrInvalidImage
+	}
+	// Lossy images without transparency are photos; the rest keep their alpha in a PNG
+	_, photo := decoded.(*image.YCbCr)
+	img.Thumbnail, img.ThumbnailType, err = encodeThumbnail(decoded, photo)
+	if err != nil {
+		return Image{}, err
+	}
+	return img, nil
+}
+
+// webpFrameSize reads the width and height from the header of a VP8 or VP8L chunk.
+func webpFrameSize(chunk riffChunk) (int, int, bool) {
+	if chunk.fourCC == "VP8 " {
+		if len(chunk.payload) < 10 || !bytes.Equal(chunk.payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false
+		}
+		width := int(binary.LittleEndian.Uint16(chunk.payload[6:]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(chunk.payload[8:]) & 0x3fff)
+		return width, height, width > 0 && height > 0
+	}
+	if len(chunk.payload) < 5 || chunk.payload[0] != 0x2f {
+		return 0, 0, false
+	}
+	bits := binary.LittleEndian.Uint32(chunk.payload[1:])
+	return 1 + int(bits&0x3fff), 1 + int(bits>>14&0x3fff), true
+}
+
+// readRIFF splits a RIFF WEBP file into its chunks. Anything after the length the RIFF
+// header gives is ignored.
+func readRIFF(data []byte) ([]riffChunk, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	if end > len(data) {
+		return nil, false
+	}
+
+	var chunks []riffChunk
+	for i := 12; i < end; {
+		if i+8 > end {
+			return nil, false
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > end {
+			return nil, false
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(data[i : i+4]), payload: data[i+8 : i+8+size]})
+		// Chunks are padded to an even length
+		i += 8 + size + size%2
+	}
+	return chunks, len(chunks) > 0
+}
+
+func writeRIFF(chunks []riffChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, chunk := range chunks {
+		body.WriteString(chunk.fourCC)
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunk.payload)))
+		body.Write(chunk.payload)
+		if len(chunk.payload)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	out := make([]byte, 0, 8+body.Len())
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
@@ -0,0 +1,80 @@
+// Package notify holds the rules of the notifications center: which kinds of notification
+// exist, which of them are grouped together, how a group is summarised, and how mentions are
+// found in a chirp.
+package notify
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Types of notification. Each can be turned off in a user's preferences.
+const (
+	TypeReply   = "reply"
+	TypeLike    = "like"
+	TypeMention = "mention"
+	TypeFollow  = "follow"
+)
+
+// Types lists every notification type, in the order preferences are shown.
+var Types = []string{TypeReply, TypeLike, TypeMention, TypeFollow}
+
+func ValidType(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupedTypes are the notification types collapsed into one entry per chirp, as in "5 people
+// liked your chirp". Replies and mentions each carry their own chirp, so they stay separate.
+var GroupedTypes = []string{TypeLike, TypeFollow}
+
+// Summary describes a group of count notifications of type t.
+func Summary(t string, count int) string {
+	who := "Someone"
+	if count > 1 {
+		who = fmt.Sprintf("%d people", count)
+	}
+	switch t {
+	case TypeReply:
+		return who + " replied to your chirp"
+	case TypeLike:
+		return who + " liked your chirp"
+	case TypeMention:
+		return who + " mentioned you in a chirp"
+	case TypeFollow:
+		return who + " followed you"
+	}
+	return ""
+}
+
+// MaxMentions caps how many users one chirp can notify by mentioning them.
+const MaxMentions = 10
+
+// A mention is "@" followed by a user's id, which clients show as the user. Ids rather than
+// email addresses, so mentioning someone doesn't publish how to reach them.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})\b`)
+
+// Mentions returns the distinct user ids mentioned in body, in the order they first appear
+// and at most MaxMentions of them.
+func Mentions(body string) []uuid.UUID {
+	ids := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		id, err := uuid.Parse(match[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if len(ids) == MaxMentions {
+			break
+		}
+	}
+	return ids
+}
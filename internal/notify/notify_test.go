@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMentions(t *testing.T) {
+	walt := uuid.MustParse("0b6e4a1c-3f1c-4d2a-9c55-8a7f2e1d9b01")
+	jesse := uuid.MustParse("7d2f9c4e-1a3b-4c5d-8e6f-0a1b2c3d4e5f")
+	tests := []struct {
+		body string
+		want []uuid.UUID
+	}{
+		{"no mentions here", []uuid.UUID{}},
+		{"hey @" + walt.String(), []uuid.UUID{walt}},
+		{"@" + strings.ToUpper(jesse.String()) + " and @" + walt.String() + ".", []uuid.UUID{jesse, walt}},
+		{"@" + walt.String() + " @" + strings.ToUpper(walt.String()), []uuid.UUID{walt}},
+		{"(@" + walt.String() + ")", []uuid.UUID{walt}},
+		{"not a mention: " + walt.String(), []uuid.UUID{}},
+		{"nor an email: @walt@example.com", []uuid.UUID{}},
+		{"nor part of one: x@" + walt.String(), []uuid.UUID{}},
+		{"too long: @" + walt.String() + "ff", []uuid.UUID{}},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Mentions(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestMentionsCapped(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < MaxMentions+5; i++ {
+		fmt.Fprintf(&body, "@%s ", uuid.New())
+	}
+	if got := Mentions(body.String()); len(got) != MaxMentions {
+		t.Errorf("got %d mentions, want %d", len(got), MaxMentions)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	if got := Summary(TypeLike, 5); got != "5 people liked your chirp" {
+		t.Errorf("Summary(like, 5) = %q", got)
+	}
+	if got := Summary(TypeFollow, 1); got != "Someone followed you" {
+		t.Errorf("Summary(follow, 1) = %q", got)
+	}
+	for _, typ := range Types {
+		if Summary(typ, 2) == "" || !ValidType(typ) {
+			t.Errorf("type %q has no summary or isn't valid", typ)
+		}
+	}
+	if ValidType("poke") {
+		t.Error("unknown type reported valid")
+	}
+}
@@ -0,0 +1,49 @@
+package oauth
+
+import (
+	"html/template"
+	"io"
+)
+
+// ConsentPage is everything the consent screen needs to render.
+type ConsentPage struct {
+	ClientName          string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ScopeDescriptions   []string
+	Error               string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+  <body>
+    <h1>Authorize {{.ClientName}}</h1>
+    <p>{{.ClientName}} would like to:</p>
+    <ul>
+      {{range .ScopeDescriptions}}<li>{{.}}</li>
+      {{end}}
+    </ul>
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="POST" action="/oauth/authorize">
+      <input type="hidden" name="response_type" value="code">
+      <input type="hidden" name="client_id" value="{{.ClientID}}">
+      <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+      <input type="hidden" name="scope" value="{{.Scope}}">
+      <input type="hidden" name="state" value="{{.State}}">
+      <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+      <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+      <p><label>Email <input type="email" name="email"></label></p>
+      <p><label>Password <input type="password" name="password"></label></p>
+      <button type="submit" name="action" value="approve">Allow</button>
+      <button type="submit" name="action" value="deny">Deny</button>
+    </form>
+  </body>
+</html>
+`))
+
+func RenderConsent(w io.Writer, page ConsentPage) error {
+	return consentTemplate.Execute(w, page)
+}
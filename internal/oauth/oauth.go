@@ -0,0 +1,124 @@
+// Package oauth holds the protocol pieces of Chirpy's OAuth2 authorization-code flow:
+// scopes, PKCE verification and redirect URI checks. Storage and token signing live
+// with the handlers and the auth package.
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeChirpsDelete = "chirps:delete"
+	ScopeWebhooks     = "webhooks"
+)
+
+// Scopes maps each scope a client can ask for to the description shown on the consent screen.
+var Scopes = map[string]string{
+	ScopeChirpsWrite:  "Post chirps as you",
+	ScopeChirpsDelete: "Delete your chirps",
+	ScopeWebhooks:     "Get notified when you chirp, delete a chirp or change your Chirpy Red status",
+}
+
+// Error codes from RFC 6749 section 4.1.2.1 and 5.2.
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrInvalidScope         = "invalid_scope"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrUnsupportedResponse  = "unsupported_response_type"
+	ErrAccessDenied         = "access_denied"
+	ErrServerError          = "server_error"
+)
+
+// ParseScope splits a space separated scope parameter, rejecting unknown scopes and
+// dropping duplicates. At least one scope is required.
+func ParseScope(raw string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Fields(raw) {
+		if _, ok := Scopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("no scope requested")
+	}
+	return scopes, nil
+}
+
+// ValidCodeChallenge reports whether challenge looks like an S256 PKCE challenge:
+// 43 characters of unpadded base64url.
+func ValidCodeChallenge(challenge string) bool {
+	if len(challenge) != 43 {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil
+}
+
+// VerifyPKCE checks a code verifier against the S256 challenge sent with the authorization request.
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		if !isUnreserved(r) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func isUnreserved(r rune) bool {
+	return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') ||
+		r == '-' || r == '.' || r == '_' || r == '~'
+}
+
+// ValidateRedirectURI checks a redirect URI a client wants to register. It must be absolute,
+// carry no fragment, and use https unless it points at the local machine.
+func ValidateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect uri %q must be absolute", raw)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect uri %q must not have a fragment", raw)
+	}
+	host := u.Hostname()
+	local := host == "localhost" || host == "127.0.0.1" || host == "::1"
+	if u.Scheme != "https" && !(u.Scheme == "http" && local) {
+		return fmt.Errorf("redirect uri %q must use https", raw)
+	}
+	return nil
+}
+
+// RedirectWith adds params to redirectURI's query, keeping any query it already has.
+func RedirectWith(redirectURI string, params url.Values) (string, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, v := range values {
+			query.Add(key, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
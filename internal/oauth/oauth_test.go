@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example values from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !ValidCodeChallenge(challenge) {
+		t.Error("expected RFC challenge to be valid")
+	}
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("expected RFC verifier to match challenge")
+	}
+	if VerifyPKCE(verifier[:42]+"A", challenge) {
+		t.Error("wrong verifier should not match")
+	}
+	if VerifyPKCE("short", challenge) {
+		t.Error("verifier under 43 characters should be rejected")
+	}
+	if VerifyPKCE(strings.Repeat("a", 42)+"!", challenge) {
+		t.Error("verifier with reserved characters should be rejected")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scopes, err := ParseScope("chirps:write  chirps:delete chirps:write")
+	if err != nil {
+		t.Fatalf("error parsing scope: %v", err)
+	}
+	if strings.Join(scopes, " ") != "chirps:write chirps:delete" {
+		t.Errorf("got %v", scopes)
+	}
+	if _, err := ParseScope("chirps:write admin"); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+	if _, err := ParseScope(" "); err == nil {
+		t.Error("expected error for empty scope")
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://localhost:3000/callback", true},
+		{"http://127.0.0.1/cb", true},
+		{"http://app.example.com/callback", false},
+		{"https://app.example.com/callback#frag", false},
+		{"/callback", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		err := ValidateRedirectURI(tt.uri)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateRedirectURI(%q) = %v, want valid %v", tt.uri, err, tt.valid)
+		}
+	}
+}
+
+func TestRedirectWith(t *testing.T) {
+	got, err := RedirectWith("https://app.example.com/cb?keep=1", url.Values{"code": {"abc"}, "state": {"x y"}})
+	if err != nil {
+		t.Fatalf("error building redirect: %v", err)
+	}
+	if got != "https://app.example.com/cb?code=abc&keep=1&state=x+y" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRenderConsentEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	err := RenderConsent(&buf, ConsentPage{ClientName: "<script>bad</script>", State: `"><x>`})
+	if err != nil {
+		t.Fatalf("error rendering consent: %v", err)
+	}
+	if strings.Contains(buf.String(), "<script>") || strings.Contains(buf.String(), `"><x>`) {
+		t.Error("consent page should escape client supplied values")
+	}
+}
@@ -0,0 +1,269 @@
+// Package outbound delivers Chirpy's own webhooks to integrators. Each delivery is signed the
+// same way Polka signs ours: an HMAC-SHA256 over the timestamp and raw body using the
+// subscription's secret. Queueing and retry bookkeeping are left to the caller.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cbrookscode/chirpy/internal/linkpreview"
+)
+
+// Events a subscription can ask for.
+const (
+	EventChirpCreated   = "chirp.created"
+	EventChirpDeleted   = "chirp.deleted"
+	EventUserUpgraded   = "user.upgraded"
+	EventUserDowngraded = "user.downgraded"
+)
+
+var Events = []string{EventChirpCreated, EventChirpDeleted, EventUserUpgraded, EventUserDowngraded}
+
+const (
+	SignatureHeader = "X-Chirpy-Signature"
+	TimestampHeader = "X-Chirpy-Timestamp"
+	EventHeader     = "X-Chirpy-Event"
+	DeliveryHeader  = "X-Chirpy-Delivery"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it's dead-lettered.
+	MaxAttempts = 8
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+
+	// DefaultTimeout bounds a single delivery, including reading the response.
+	DefaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownEvent = errors.New("unknown webhook event type")
+	ErrNoEvents     = errors.New("at least one event type is required")
+	ErrInvalidURL   = errors.New("webhook url must be an absolute http or https url")
+	// ErrBlockedAddress means a delivery would have gone to a loopback, private or otherwise
+	// non-public address.
+	ErrBlockedAddress = errors.New("webhook url must be on the public internet")
+)
+
+// ValidateEvents checks that every event type is one we send.
+func ValidateEvents(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return ErrNoEvents
+	}
+	for _, eventType := range eventTypes {
+		known := false
+		for _, event := range Events {
+			if eventType == event {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %q", ErrUnknownEvent, eventType)
+		}
+	}
+	return nil
+}
+
+// ValidateURL checks that raw is somewhere we can POST to.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials belong in the signing secret, not the url", ErrInvalidURL)
+	}
+	// Names are checked again when a delivery connects; this only catches the obvious ones early
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrBlockedAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !linkpreview.PublicAddr(netip.AddrPortFrom(addr, 0)) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// NewSecret returns a random signing secret for a new subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Event is the JSON body of every delivery.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+func (e Event) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return "sha256=" + hex.EncodeToString(mac(secret, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks a delivery's signature the way a receiver should. It lives here so tests and
+// example receivers don't have to reimplement it.
+func Verify(headers http.Header, body []byte, secret string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(headers.Get(SignatureHeader), "sha256="))
+	if err != nil || headers.Get(TimestampHeader) == "" {
+		return false
+	}
+	return hmac.Equal(got, mac(secret, headers.Get(TimestampHeader), body))
+}
+
+// Backoff is how long to wait before trying again after the attempt'th failed attempt:
+// 30s, 1m, 2m, ... capped at six hours.
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	wait := baseBackoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return wait
+}
+
+// Delivery is one event on its way to one subscription.
+type Delivery struct {
+	ID        string
+	URL       string
+	Secret    string
+	EventType string
+	Payload   []byte
+}
+
+// Result is what came of a single attempt at a delivery.
+type Result struct {
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// OK reports whether the receiver accepted the delivery with a 2xx.
+func (r Result) OK() bool {
+	return r.Err == nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Sender POSTs signed deliveries.
+type Sender struct {
+	Client *http.Client
+	// Now is used to timestamp signatures; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewSender returns a Sender with a client that gives up after DefaultTimeout and doesn't
+// follow redirects, so a receiver can't bounce a signed payload somewhere else. It only
+// connects to addresses allow accepts; pass nil to allow only public ones, as
+// linkpreview.PublicAddr decides.
+func NewSender(allow func(netip.AddrPort) bool) *Sender {
+	if allow == nil {
+		allow = linkpreview.PublicAddr
+	}
+	dialer := &net.Dialer{
+		Timeout: DefaultTimeout,
+		// Checked after the name is resolved, so DNS can't point a registered name inward
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addrPort) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+			}
+			return nil
+		},
+	}
+	return &Sender{
+		Client: &http.Client{
+			Timeout: DefaultTimeout,
+			Transport: &http.Transport{
+				// No proxy from the environment: it would be the one connecting, unchecked
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: DefaultTimeout,
+				MaxIdleConns:        20,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send makes one attempt at d. Non-2xx responses come back with their status code and an Err
+// describing them.
+func (s *Sender) Send(ctx context.Context, d Delivery) Result {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return Result{Err: err}
+	}
+	sentAt := now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(sentAt.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, sentAt, d.Payload))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{Err: err, Duration: time.Since(start)}
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused, but don't wait on a huge one
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result := Result{StatusCode: resp.StatusCode, Duration: time.Since(start)}
+	if !result.OK() {
+		result.Err = fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const prefixLength = 5
+
+// BreachedList is an offline set of breached password SHA-1 hashes, laid out the way the
+// Pwned Passwords downloader writes them one file per range: a directory of "<prefix>.txt"
+// files named for the first five hex characters of the hash, each holding "<suffix>[:count]"
+// lines. Only the file for a password's prefix is read, when that password is checked, so
+// the list can be far bigger than memory.
+type BreachedList struct {
+	dir string
+}
+
+// OpenBreachedList returns the list of range files in dir.
+func OpenBreachedList(dir string) (*BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory of range files", dir)
+	}
+	return &BreachedList{dir: dir}, nil
+}
+
+// Lookup reports whether password is in the list and how many times it was seen, if known.
+// A prefix with no range file has no breached hashes.
+func (b *BreachedList) Lookup(password string) (int, bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	file, err := os.Open(filepath.Join(b.dir, hash[:prefixLength]+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	return lookupRange(file, hash[prefixLength:])
+}
+
+// lookupRange scans one range file for suffix. Blank lines and lines starting with # are
+// skipped.
+func lookupRange(r io.Reader, suffix string) (int, bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		candidate, countStr, hasCount := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		if !hasCount {
+			return 0, true, nil
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(countStr))
+		if err != nil {
+			return 0, false, fmt.Errorf("range file has an invalid count for %s: %v", suffix, err)
+		}
+		return count, true, nil
+	}
+	return 0, false, scanner.Err()
+}
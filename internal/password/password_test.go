@@ -0,0 +1,114 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+	}{
+		{"password", 0, 0},
+		{"P@ssw0rd", 0, 0},
+		{"qwerty123", 1, 0},
+		{"aaaaaaaaaa", 1, 0},
+		{"abcdefgh", 1, 0},
+		{"Tr0ub4dor&3", 4, 3},
+		{"correct horse battery staple", 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := Score(tt.password)
+			if got < tt.minScore || got > tt.maxScore {
+				t.Errorf("Score(%q) = %d, want between %d and %d", tt.password, got, tt.minScore, tt.maxScore)
+			}
+		})
+	}
+}
+
+func TestCheckerViolations(t *testing.T) {
+	// sha1("correct horse battery staple") and sha1("hunter2hunter2")
+	breached := writeRanges(t, map[string]string{
+		"ABF7A.txt": "# test list\n0000000000000000000000000000000000A:1\nAD6438836DBE526AA231ABDE2D0EEF74D42:42\n",
+		"FC8C5.txt": "eb194806e31a213f073131e73b0012a0fb5\n",
+	})
+	checker := NewChecker(DefaultPolicy(), breached)
+
+	tests := []struct {
+		name      string
+		password  string
+		email     string
+		wantRules []string
+	}{
+		{"strong", "violet-Umbrella-93", "walt@breakingbad.com", nil},
+		{"too short and weak", "abc", "walt@breakingbad.com", []string{"min_length", "strength"}},
+		{"same as email", "Walt@BreakingBad.com", "walt@breakingbad.com", []string{"not_email"}},
+		{"breached", "correct horse battery staple", "walt@breakingbad.com", []string{"breached"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checker.Check(tt.password, tt.email)
+			if tt.wantRules == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+			var got []string
+			for _, v := range verr.Violations {
+				got = append(got, v.Rule)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantRules, ",") {
+				t.Errorf("got rules %v, want %v", got, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestBreachedListLookup(t *testing.T) {
+	breached := writeRanges(t, map[string]string{
+		"ABF7A.txt": "AD6438836DBE526AA231ABDE2D0EEF74D42:lots\n",
+		"FC8C5.txt": "eb194806e31a213f073131e73b0012a0fb5\n",
+	})
+
+	if count, found, err := breached.Lookup("hunter2hunter2"); err != nil || !found || count != 0 {
+		t.Errorf("hash without a count: got %d, %v, %v", count, found, err)
+	}
+	if _, found, err := breached.Lookup("violet-Umbrella-93"); err != nil || found {
+		t.Errorf("prefix without a range file: got %v, %v", found, err)
+	}
+	if _, _, err := breached.Lookup("correct horse battery staple"); err == nil {
+		t.Error("expected error for malformed count")
+	}
+	if _, err := OpenBreachedList(filepath.Join(breached.dir, "ABF7A.txt")); err == nil {
+		t.Error("expected error opening a file rather than a directory")
+	}
+}
+
+// writeRanges returns a BreachedList of range files with the given contents.
+func writeRanges(t *testing.T, files map[string]string) *BreachedList {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	breached, err := OpenBreachedList(dir)
+	if err != nil {
+		t.Fatalf("error opening breached list: %v", err)
+	}
+	return breached
+}
@@ -0,0 +1,99 @@
+// Package password checks new passwords against a configurable policy: length,
+// an estimated strength score, not reusing the email, and an offline list of
+// breached password hashes.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type Policy struct {
+	MinLength int // minimum number of characters
+	MaxLength int // maximum number of characters, 0 for no limit
+	MinScore  int // minimum strength score from 0 (weakest) to 4
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength: 8,
+		MaxLength: 128,
+		MinScore:  2,
+	}
+}
+
+// Violation is a single rule a password failed.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rule a password failed, so clients can show them all at once.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return "password rejected: " + strings.Join(msgs, "; ")
+}
+
+type Checker struct {
+	policy   Policy
+	breached *BreachedList
+}
+
+// NewChecker returns a Checker for policy. breached may be nil to skip the breached password check.
+func NewChecker(policy Policy, breached *BreachedList) *Checker {
+	return &Checker{policy: policy, breached: breached}
+}
+
+// Check returns a *ValidationError describing every rule password fails, or nil if it passes.
+func (c *Checker) Check(password, email string) error {
+	var violations []Violation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < c.policy.MinLength {
+		add("min_length", "must be at least %d characters", c.policy.MinLength)
+	}
+	if c.policy.MaxLength > 0 && length > c.policy.MaxLength {
+		add("max_length", "must be at most %d characters", c.policy.MaxLength)
+	}
+
+	lowered := strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	localPart, _, _ := strings.Cut(email, "@")
+	if email != "" && (lowered == email || lowered == localPart) {
+		add("not_email", "must not be the same as your email")
+	}
+
+	if score := Score(password); score < c.policy.MinScore {
+		add("strength", "is too easy to guess (strength %d of 4, need %d)", score, c.policy.MinScore)
+	}
+
+	if c.breached != nil {
+		count, found, err := c.breached.Lookup(password)
+		if err != nil {
+			return fmt.Errorf("checking breached passwords: %w", err)
+		}
+		if found {
+			if count > 0 {
+				add("breached", "has appeared in %d known data breaches", count)
+			} else {
+				add("breached", "has appeared in a known data breach")
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
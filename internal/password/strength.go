@@ -0,0 +1,129 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are ranked roughly by how often they show up in leaked password dumps.
+// Keyboard walks are included since they are guessed just as early.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "monkey", "dragon", "football",
+	"iloveyou", "admin", "login", "abc123", "master", "sunshine", "princess", "starwars",
+	"whatever", "trustno1", "baseball", "shadow", "superman", "michael", "freedom", "qazwsx",
+	"asdfgh", "zxcvbn", "batman", "secret", "hello", "charlie", "summer", "winter", "spring",
+	"autumn", "flower", "hunter", "killer", "soccer", "hockey", "ranger", "jordan", "jennifer",
+	"thomas", "passw0rd", "access", "mustang", "pepper", "ginger", "cheese", "computer",
+	"internet", "chirpy", "chirp", "twitter", "changeme", "default", "qwertyuiop", "asdfghjkl",
+	"zxcvbnm", "1q2w3e4r", "google", "matrix", "orange", "banana", "cookie", "silver",
+}
+
+var commonRank = func() map[string]int {
+	ranks := make(map[string]int, len(commonPasswords))
+	for i, word := range commonPasswords {
+		ranks[word] = i + 1
+	}
+	return ranks
+}()
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// Score estimates how hard password is to guess on a 0 to 4 scale, in the spirit of zxcvbn:
+// 0 is guessable within about a thousand tries, 4 needs more than ten billion.
+func Score(password string) int {
+	bits := entropyBits(password)
+	switch {
+	case bits < 10:
+		return 0
+	case bits < 20:
+		return 1
+	case bits < 27:
+		return 2
+	case bits < 33:
+		return 3
+	}
+	return 4
+}
+
+// entropyBits approximates log2 of the number of guesses needed. Dictionary words cost
+// log2 of their rank, and characters that repeat or continue a run cost a single bit.
+func entropyBits(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	normalized := []rune(leetReplacer.Replace(strings.ToLower(password)))
+	if len(normalized) != len(runes) {
+		normalized = []rune(strings.ToLower(password))
+	}
+
+	perChar := math.Log2(float64(charsetSize(runes)))
+	bits := 0.0
+	for i := 0; i < len(runes); {
+		if word, rank := longestCommonWord(normalized[i:]); rank > 0 {
+			bits += math.Log2(float64(rank)) + 1 // one extra bit for case or leet variations
+			i += len([]rune(word))
+			continue
+		}
+		if i > 0 {
+			diff := runes[i] - runes[i-1]
+			if diff >= -1 && diff <= 1 {
+				bits += 1
+				i++
+				continue
+			}
+		}
+		bits += perChar
+		i++
+	}
+	return bits
+}
+
+func longestCommonWord(s []rune) (string, int) {
+	best, bestRank := "", 0
+	for end := len(s); end >= 4; end-- {
+		if rank, ok := commonRank[string(s[:end])]; ok {
+			best, bestRank = string(s[:end]), rank
+			break
+		}
+	}
+	return best, bestRank
+}
+
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return size
+}
@@ -0,0 +1,70 @@
+package polka
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "f271c81ff7084ee5b99a5091b42d486e"
+	now := time.Unix(1735689600, 0)
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+	signed := func(ts time.Time, sig string) http.Header {
+		h := http.Header{}
+		h.Set(TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		h.Set(SignatureHeader, sig)
+		return h
+	}
+
+	tests := []struct {
+		name    string
+		headers http.Header
+		body    []byte
+		wantErr error
+	}{
+		{"valid", signed(now, Sign(secret, now, body)), body, nil},
+		{"within tolerance", signed(now.Add(-4*time.Minute), Sign(secret, now.Add(-4*time.Minute), body)), body, nil},
+		{"missing headers", http.Header{}, body, ErrMissingSignature},
+		{"bad timestamp", http.Header{TimestampHeader: {"yesterday"}, SignatureHeader: {"sha256=00"}}, body, ErrInvalidTimestamp},
+		{"replayed later", signed(now.Add(-10*time.Minute), Sign(secret, now.Add(-10*time.Minute), body)), body, ErrStaleTimestamp},
+		{"future timestamp", signed(now.Add(10*time.Minute), Sign(secret, now.Add(10*time.Minute), body)), body, ErrStaleTimestamp},
+		{"tampered body", signed(now, Sign(secret, now, body)), []byte(`{"event":"user.upgraded"}`), ErrBadSignature},
+		{"wrong secret", signed(now, Sign("other", now, body)), body, ErrBadSignature},
+		{"not hex", signed(now, "sha256=zz"), body, ErrBadSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.headers, tt.body, secret, now, DefaultTolerance)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "ApiKey f271c81ff7084ee5b99a5091b42d486e")
+	headers.Set("Cookie", "chirpy_access=abc")
+	headers.Set(SignatureHeader, "sha256=00")
+	headers.Set("Content-Type", "application/json")
+
+	got := RedactHeaders(headers)
+	if got.Get("Authorization") != "[redacted]" || got.Get("Cookie") != "[redacted]" {
+		t.Errorf("credentials not redacted: %v", got)
+	}
+	if got.Get(SignatureHeader) != "sha256=00" || got.Get("Content-Type") != "application/json" {
+		t.Errorf("other headers changed: %v", got)
+	}
+	if headers.Get("Authorization") == "[redacted]" {
+		t.Error("RedactHeaders modified its input")
+	}
+	if _, ok := got["Proxy-Authorization"]; ok {
+		t.Error("RedactHeaders added a header that wasn't sent")
+	}
+}
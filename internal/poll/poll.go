@@ -0,0 +1,72 @@
+// Package poll holds the rules for polls attached to chirps: how many options a poll has, how
+// long they may be, and how long a poll stays open.
+package poll
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinOptions      = 2
+	MaxOptions      = 4
+	MaxOptionLength = 25 // in characters
+	MinDuration     = 5 * time.Minute
+	MaxDuration     = 7 * 24 * time.Hour
+)
+
+var (
+	ErrOptionCount     = fmt.Errorf("a poll needs between %d and %d options", MinOptions, MaxOptions)
+	ErrEmptyOption     = errors.New("poll options can't be empty")
+	ErrOptionTooLong   = fmt.Errorf("poll options can be at most %d characters", MaxOptionLength)
+	ErrDuplicateOption = errors.New("poll options must be different from each other")
+	ErrClosesTooSoon   = fmt.Errorf("a poll must stay open for at least %v", MinDuration)
+	ErrClosesTooLate   = errors.New("a poll can stay open for at most 7 days")
+)
+
+// Options trims the options of a new poll and checks them, returning the trimmed options.
+// Options differing only in case count as duplicates.
+func Options(raw []string) ([]string, error) {
+	if len(raw) < MinOptions || len(raw) > MaxOptions {
+		return nil, ErrOptionCount
+	}
+	options := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, option := range raw {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return nil, ErrEmptyOption
+		}
+		if utf8.RuneCountInString(option) > MaxOptionLength {
+			return nil, ErrOptionTooLong
+		}
+		key := strings.ToLower(option)
+		if _, ok := seen[key]; ok {
+			return nil, ErrDuplicateOption
+		}
+		seen[key] = struct{}{}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// CheckCloses checks a poll that opens at opens may close at closes.
+func CheckCloses(opens, closes time.Time) error {
+	open := closes.Sub(opens)
+	if open < MinDuration {
+		return ErrClosesTooSoon
+	}
+	if open > MaxDuration {
+		return ErrClosesTooLate
+	}
+	return nil
+}
+
+// ShowResults reports whether a user sees a poll's tallies: once they've voted, or once it
+// has closed, so early results don't sway anyone.
+func ShowResults(voted, closed bool) bool {
+	return voted || closed
+}
@@ -0,0 +1,67 @@
+package poll
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []string
+		want    []string
+		wantErr error
+	}{
+		{"two", []string{"Yes", "No"}, []string{"Yes", "No"}, nil},
+		{"trimmed", []string{" tea ", "coffee\n", "water"}, []string{"tea", "coffee", "water"}, nil},
+		{"four", []string{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}, nil},
+		{"one", []string{"only"}, nil, ErrOptionCount},
+		{"five", []string{"a", "b", "c", "d", "e"}, nil, ErrOptionCount},
+		{"empty", []string{"a", "  "}, nil, ErrEmptyOption},
+		{"too long", []string{"a", strings.Repeat("x", MaxOptionLength+1)}, nil, ErrOptionTooLong},
+		{"long in bytes only", []string{"a", strings.Repeat("é", MaxOptionLength)}, []string{"a", strings.Repeat("é", MaxOptionLength)}, nil},
+		{"duplicate", []string{"Yes", "yes "}, nil, ErrDuplicateOption},
+	}
+	for _, tt := range tests {
+		got, err := Options(tt.raw)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCloses(t *testing.T) {
+	opens := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		open    time.Duration
+		wantErr error
+	}{
+		{MinDuration, nil},
+		{24 * time.Hour, nil},
+		{MaxDuration, nil},
+		{MinDuration - time.Second, ErrClosesTooSoon},
+		{-time.Hour, ErrClosesTooSoon},
+		{MaxDuration + time.Second, ErrClosesTooLate},
+	}
+	for _, tt := range tests {
+		if err := CheckCloses(opens, opens.Add(tt.open)); !errors.Is(err, tt.wantErr) {
+			t.Errorf("open for %v: got %v, want %v", tt.open, err, tt.wantErr)
+		}
+	}
+}
+
+func TestShowResults(t *testing.T) {
+	if ShowResults(false, false) {
+		t.Error("results shown before voting on an open poll")
+	}
+	if !ShowResults(true, false) || !ShowResults(false, true) {
+		t.Error("results hidden after voting or closing")
+	}
+}
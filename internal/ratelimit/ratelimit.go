@@ -0,0 +1,62 @@
+// Package ratelimit counts events per key in fixed windows. The limit is passed on every
+// call so different users can have different allowances from the same Limiter.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	start time.Time
+	count int
+}
+
+type Limiter struct {
+	mu      sync.Mutex
+	period  time.Duration
+	windows map[string]*window
+	now     func() time.Time
+}
+
+func New(period time.Duration) *Limiter {
+	return &Limiter{
+		period:  period,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Allow records an event for key if fewer than limit have happened in the current window.
+// When it refuses, the returned duration is how long until the window resets. A limit of
+// zero or less means no limit.
+func (l *Limiter) Allow(key string, limit int) (time.Duration, bool) {
+	if limit <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.period {
+		l.prune(now)
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= limit {
+		return w.start.Add(l.period).Sub(now), false
+	}
+	w.count++
+	return 0, true
+}
+
+// prune drops windows that have already ended so the map doesn't grow forever.
+func (l *Limiter) prune(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.period {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(time.Hour)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.Allow("walt", 3); !ok {
+			t.Fatalf("event %d should be allowed", i+1)
+		}
+	}
+	now = now.Add(10 * time.Minute)
+	wait, ok := l.Allow("walt", 3)
+	if ok || wait != 50*time.Minute {
+		t.Errorf("got wait %v ok %v, want 50m refused", wait, ok)
+	}
+	if _, ok := l.Allow("jesse", 3); !ok {
+		t.Error("other keys have their own window")
+	}
+	if _, ok := l.Allow("walt", 10); !ok {
+		t.Error("a higher limit should let the same key through")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := l.Allow("walt", 3); !ok {
+		t.Error("a new window should reset the count")
+	}
+	if _, ok := l.Allow("walt", 0); !ok {
+		t.Error("zero limit means unlimited")
+	}
+}
@@ -0,0 +1,323 @@
+// Package realtime routes topic messages to websocket clients. A Hub only knows the clients
+// connected to this process; a Bus carries published messages to the hub of every instance,
+// either directly (LocalBus) or through Postgres (PostgresBus), which stores each message in
+// realtime_messages and announces it with LISTEN/NOTIFY.
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Message is something published to a topic, such as a new chirp on "chirps:<author id>".
+type Message struct {
+	Topic string          `json:"topic"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+	// Sender lets a client skip its own messages, such as its typing indicator.
+	Sender uuid.UUID `json:"sender,omitzero"`
+	// Author is whose content the message carries, so clients that hid them can skip it.
+	Author uuid.UUID `json:"author,omitzero"`
+}
+
+// clientBuffer is how many messages a client may have queued before it's dropped.
+const clientBuffer = 64
+
+// Client is one connection's mailbox. Send is closed when the hub drops the client.
+type Client struct {
+	UserID uuid.UUID
+	Send   <-chan []byte
+
+	send   chan []byte
+	topics map[string]struct{}
+	hidden map[uuid.UUID]struct{}
+	lagged bool
+}
+
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	topics  map[string]map[*Client]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients: map[*Client]struct{}{},
+		topics:  map[string]map[*Client]struct{}{},
+	}
+}
+
+// Register adds a client for userID with no subscriptions.
+func (h *Hub) Register(userID uuid.UUID) *Client {
+	send := make(chan []byte, clientBuffer)
+	c := &Client{UserID: userID, Send: send, send: send, topics: map[string]struct{}{}}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	return c
+}
+
+// Unregister removes the client and closes its Send channel. It's safe to call more than once.
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(c)
+}
+
+// Lagged reports whether the hub dropped c for not keeping up.
+func (h *Hub) Lagged(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return c.lagged
+}
+
+func (h *Hub) Subscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = map[*Client]struct{}{}
+	}
+	h.topics[topic][c] = struct{}{}
+	c.topics[topic] = struct{}{}
+}
+
+func (h *Hub) Unsubscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribe(c, topic)
+}
+
+// Hide replaces the authors whose messages c never receives, such as users it blocked.
+func (h *Hub) Hide(c *Client, authors []uuid.UUID) {
+	hidden := make(map[uuid.UUID]struct{}, len(authors))
+	for _, id := range authors {
+		hidden[id] = struct{}{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.hidden = hidden
+}
+
+// Subscribed returns the topics c is subscribed to.
+func (h *Hub) Subscribed(c *Client) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Deliver sends msg to this process's subscribers of its topic, skipping its sender and
+// clients that hid its author. A client whose queue is full is dropped rather than slowing
+// everyone else down.
+func (h *Hub) Deliver(msg Message) {
+	frame, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Message
+	}{Type: "event", Message: msg})
+	if err != nil {
+		log.Printf("issue encoding realtime message for %v: %v", msg.Topic, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.topics[msg.Topic] {
+		if msg.Sender != uuid.Nil && c.UserID == msg.Sender {
+			continue
+		}
+		if _, hidden := c.hidden[msg.Author]; hidden && msg.Author != uuid.Nil {
+			continue
+		}
+		select {
+		case c.send <- frame:
+		default:
+			c.lagged = true
+			h.remove(c)
+		}
+	}
+}
+
+// Watched reports whether any client on this process is subscribed to topic.
+func (h *Hub) Watched(topic string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[topic]) > 0
+}
+
+// Clients returns how many clients are connected to this process.
+func (h *Hub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// remove and unsubscribe must be called with h.mu held.
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	for topic := range c.topics {
+		h.unsubscribe(c, topic)
+	}
+	delete(h.clients, c)
+	close(c.send)
+}
+
+func (h *Hub) unsubscribe(c *Client, topic string) {
+	delete(c.topics, topic)
+	if subs := h.topics[topic]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// Bus publishes a message to every instance's hub.
+type Bus interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// LocalBus delivers straight to one hub, for a single instance or tests.
+type LocalBus struct {
+	Hub *Hub
+}
+
+func (b LocalBus) Publish(ctx context.Context, msg Message) error {
+	b.Hub.Deliver(msg)
+	return nil
+}
+
+// Channel is the Postgres NOTIFY channel instances share.
+const Channel = "chirpy_realtime"
+
+// messageRetention is how long a stored message waits for instances to read it. Its
+// notification arrives within moments, so this only needs to outlast a slow instance.
+const messageRetention = 5 * time.Minute
+
+// envelope is what's sent with NOTIFY. Messages can be far bigger than Postgres's 8000 byte
+// payload limit, so the rest waits in realtime_messages for instances with a subscriber to
+// the topic to load.
+type envelope struct {
+	ID     uuid.UUID `json:"id"`
+	Topic  string    `json:"topic"`
+	Event  string    `json:"event"`
+	Author uuid.UUID `json:"author,omitzero"`
+}
+
+// PostgresBus stores each message and sends its envelope with NOTIFY, delivering whatever
+// arrives on LISTEN to its hub, so a message reaches every instance, including the one that
+// sent it.
+type PostgresBus struct {
+	db       *sql.DB
+	hub      *Hub
+	listener *pq.Listener
+}
+
+// NewPostgresBus starts listening on Channel with a dedicated connection to dsn. Call Run to
+// start delivering.
+func NewPostgresBus(db *sql.DB, dsn string, hub *Hub) (*PostgresBus, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("realtime listener event %d: %v", ev, err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &PostgresBus{db: db, hub: hub, listener: listener}, nil
+}
+
+// Publish stores msg and notifies every instance in one statement, so no instance hears of a
+// message before it can be read.
+func (b *PostgresBus) Publish(ctx context.Context, msg Message) error {
+	id := uuid.New()
+	env, err := json.Marshal(envelope{ID: id, Topic: msg.Topic, Event: msg.Event, Author: msg.Author})
+	if err != nil {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, `
+WITH stored AS (
+    INSERT INTO realtime_messages (id, topic, event, data, sender, author, created_at)
+    VALUES ($3, $4, $5, $6, $7, $8, NOW() AT TIME ZONE 'UTC')
+    RETURNING id
+)
+SELECT pg_notify($1, $2) FROM stored`,
+		Channel, string(env), id, msg.Topic, msg.Event, msg.Data,
+		uuid.NullUUID{UUID: msg.Sender, Valid: msg.Sender != uuid.Nil},
+		uuid.NullUUID{UUID: msg.Author, Valid: msg.Author != uuid.Nil},
+	)
+	return err
+}
+
+// Run delivers notifications until ctx is done. Messages sent while the listener was
+// reconnecting are lost; clients only rely on the realtime API for live updates.
+func (b *PostgresBus) Run(ctx context.Context) {
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.listener.Close()
+			return
+		case n := <-b.listener.Notify:
+			b.handle(ctx, n)
+		case <-ping.C:
+			// Notices a dead connection even when nothing is being published
+			go b.listener.Ping()
+			go b.prune(ctx)
+		}
+	}
+}
+
+func (b *PostgresBus) handle(ctx context.Context, n *pq.Notification) {
+	if n == nil {
+		// The listener reconnected
+		return
+	}
+	env := envelope{}
+	if err := json.Unmarshal([]byte(n.Extra), &env); err != nil {
+		log.Printf("issue decoding realtime notification: %v", err)
+		return
+	}
+	if !b.hub.Watched(env.Topic) {
+		return
+	}
+
+	msg := Message{Topic: env.Topic, Event: env.Event, Author: env.Author}
+	var sender uuid.NullUUID
+	err := b.db.QueryRowContext(ctx, "SELECT data, sender FROM realtime_messages WHERE id = $1", env.ID).Scan(&msg.Data, &sender)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("realtime message %v was pruned before it was read", env.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("issue loading realtime message %v: %v", env.ID, err)
+		return
+	}
+	msg.Sender = sender.UUID
+	b.hub.Deliver(msg)
+}
+
+// prune deletes stored messages every instance has had time to read.
+func (b *PostgresBus) prune(ctx context.Context) {
+	_, err := b.db.ExecContext(ctx, "DELETE FROM realtime_messages WHERE created_at < $1", time.Now().UTC().Add(-messageRetention))
+	if err != nil {
+		log.Printf("issue pruning realtime messages: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func decode(t *testing.T, frame []byte) Message {
+	t.Helper()
+	var got struct {
+		Type string `json:"type"`
+		Message
+	}
+	if err := json.Unmarshal(frame, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "event" {
+		t.Errorf("frame type = %q, want event", got.Type)
+	}
+	return got.Message
+}
+
+func TestDeliverToSubscribers(t *testing.T) {
+	hub := NewHub()
+	bus := LocalBus{Hub: hub}
+	alice := hub.Register(uuid.New())
+	bob := hub.Register(uuid.New())
+	defer hub.Unregister(alice)
+	defer hub.Unregister(bob)
+
+	hub.Subscribe(alice, "chirps")
+	hub.Subscribe(bob, "likes:1")
+
+	bus.Publish(context.Background(), Message{Topic: "chirps", Event: "chirp.created", Data: json.RawMessage(`{"body":"hi"}`)})
+	if len(alice.Send) != 1 || len(bob.Send) != 0 {
+		t.Fatalf("queued alice=%d bob=%d, want 1 and 0", len(alice.Send), len(bob.Send))
+	}
+	msg := decode(t, <-alice.Send)
+	if msg.Topic != "chirps" || msg.Event != "chirp.created" || string(msg.Data) != `{"body":"hi"}` {
+		t.Errorf("delivered %+v", msg)
+	}
+
+	hub.Unsubscribe(alice, "chirps")
+	bus.Publish(context.Background(), Message{Topic: "chirps", Event: "chirp.created"})
+	if len(alice.Send) != 0 {
+		t.Error("delivered after unsubscribe")
+	}
+}
+
+func TestDeliverSkipsSender(t *testing.T) {
+	hub := NewHub()
+	typist := hub.Register(uuid.New())
+	other := hub.Register(uuid.New())
+	hub.Subscribe(typist, "conversation:1")
+	hub.Subscribe(other, "conversation:1")
+
+	hub.Deliver(Message{Topic: "conversation:1", Event: "typing", Sender: typist.UserID})
+	if len(typist.Send) != 0 || len(other.Send) != 1 {
+		t.Errorf("queued typist=%d other=%d, want 0 and 1", len(typist.Send), len(other.Send))
+	}
+}
+
+func TestDeliverSkipsHiddenAuthors(t *testing.T) {
+	hub := NewHub()
+	blocker := hub.Register(uuid.New())
+	other := hub.Register(uuid.New())
+	hub.Subscribe(blocker, "chirps")
+	hub.Subscribe(other, "chirps")
+
+	blocked := uuid.New()
+	hub.Hide(blocker, []uuid.UUID{blocked})
+	hub.Deliver(Message{Topic: "chirps", Event: "chirp.created", Author: blocked})
+	hub.Deliver(Message{Topic: "chirps", Event: "chirp.created", Author: uuid.New()})
+	if len(blocker.Send) != 1 || len(other.Send) != 2 {
+		t.Errorf("queued blocker=%d other=%d, want 1 and 2", len(blocker.Send), len(other.Send))
+	}
+
+	hub.Hide(blocker, nil)
+	hub.Deliver(Message{Topic: "chirps", Event: "chirp.created", Author: blocked})
+	if len(blocker.Send) != 2 {
+		t.Errorf("queued %d after unhiding, want 2", len(blocker.Send))
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Register(uuid.New())
+	hub.Subscribe(slow, "chirps")
+
+	for i := 0; i < clientBuffer+1; i++ {
+		hub.Deliver(Message{Topic: "chirps", Event: "chirp.created"})
+	}
+	drained := 0
+	for range slow.Send {
+		drained++
+	}
+	if drained != clientBuffer || !hub.Lagged(slow) {
+		t.Errorf("drained %d, lagged %v; want %d and true", drained, hub.Lagged(slow), clientBuffer)
+	}
+	if hub.Clients() != 0 || len(hub.topics) != 0 {
+		t.Errorf("dropped client left %d clients and %d topics", hub.Clients(), len(hub.topics))
+	}
+	// Unregistering an already dropped client is harmless
+	hub.Unregister(slow)
+}
+
+func TestPostgresBusHandleSkipsUnwatchedTopics(t *testing.T) {
+	hub := NewHub()
+	c := hub.Register(uuid.New())
+	hub.Subscribe(c, "likes:42")
+	// With no database, loading a message would panic, so only envelopes for topics nobody
+	// here watches can be handled
+	bus := &PostgresBus{hub: hub}
+
+	env, _ := json.Marshal(envelope{ID: uuid.New(), Topic: "likes:7", Event: "chirp.likes"})
+	bus.handle(context.Background(), &pq.Notification{Channel: Channel, Extra: string(env)})
+	bus.handle(context.Background(), nil)
+	bus.handle(context.Background(), &pq.Notification{Channel: Channel, Extra: "not json"})
+
+	if len(c.Send) != 0 {
+		t.Errorf("queued %d messages, want none", len(c.Send))
+	}
+	if !hub.Watched("likes:42") || hub.Watched("likes:7") {
+		t.Error("Watched reported the wrong topics")
+	}
+	hub.Unregister(c)
+	if hub.Watched("likes:42") {
+		t.Error("topic still watched after its only client left")
+	}
+}
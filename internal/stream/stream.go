@@ -0,0 +1,200 @@
+// Package stream fans chirp events out to live subscribers such as the SSE endpoint. The broker
+// lives in one process; publishing never blocks, and a subscriber that can't keep up is
+// dropped so it can reconnect and catch up from the replay buffer instead.
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+)
+
+const (
+	// DefaultReplaySize is how many recent events are kept for Last-Event-ID resume.
+	DefaultReplaySize = 1024
+	// subscriberBuffer is how far a subscriber may fall behind before it's dropped.
+	subscriberBuffer = 64
+)
+
+// EventID places an event in a broker's stream: the broker's epoch, which is new every time
+// the process starts, and the event's sequence number within it. Clients see it as
+// "<epoch>-<seq>".
+type EventID struct {
+	Epoch uint64
+	Seq   uint64
+}
+
+func (id EventID) String() string {
+	return strconv.FormatUint(id.Epoch, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+var ErrInvalidEventID = errors.New("not an event id from this stream")
+
+// ParseEventID reads an id as String writes it. A bare sequence number, as ids were before
+// they had an epoch, belongs to no current broker.
+func ParseEventID(s string) (EventID, error) {
+	epoch, seq, found := strings.Cut(s, "-")
+	if !found {
+		epoch, seq = "0", s
+	}
+	var id EventID
+	var err error
+	if id.Epoch, err = strconv.ParseUint(epoch, 10, 64); err != nil {
+		return EventID{}, ErrInvalidEventID
+	}
+	if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return EventID{}, ErrInvalidEventID
+	}
+	return id, nil
+}
+
+type Event struct {
+	ID       EventID
+	Type     string
+	AuthorID uuid.UUID
+	// Data is the JSON sent to clients.
+	Data []byte
+}
+
+// Filter decides which events a subscriber receives.
+type Filter func(Event) bool
+
+type Subscription struct {
+	// C receives matching events. It's closed when the subscription ends, either through
+	// Close or because the subscriber fell too far behind.
+	C <-chan Event
+
+	c      chan Event
+	filter Filter
+	broker *Broker
+	lagged bool
+}
+
+// Lagged reports whether the subscription was dropped for falling behind. Only meaningful
+// once C has been closed.
+func (s *Subscription) Lagged() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.lagged
+}
+
+// Close ends the subscription. It's safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+type Broker struct {
+	mu     sync.Mutex
+	epoch  uint64
+	nextID uint64
+	replay []Event
+	size   int
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroker returns a broker that keeps the last replaySize events for resuming clients.
+func NewBroker(replaySize int) *Broker {
+	return &Broker{
+		epoch:  uint64(time.Now().UnixNano()),
+		nextID: 1,
+		size:   replaySize,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns the event the next id and hands it to every matching subscriber.
+func (b *Broker) Publish(eventType string, authorID uuid.UUID, data []byte) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := Event{ID: EventID{Epoch: b.epoch, Seq: b.nextID}, Type: eventType, AuthorID: authorID, Data: data}
+	b.nextID++
+	b.replay = append(b.replay, ev)
+	if len(b.replay) > b.size {
+		b.replay = b.replay[len(b.replay)-b.size:]
+	}
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(ev) {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			sub.lagged = true
+			b.remove(sub)
+		}
+	}
+	return ev
+}
+
+// Subscribe starts a subscription. If lastID is non-zero, the matching buffered events after
+// it are returned to be sent first; subscribing and taking the backlog happen together so no
+// event falls between them. An id from another epoch, such as before a restart, gets the
+// whole buffer, since none of it can have been seen. Events older than the buffer are gone.
+func (b *Broker) Subscribe(filter Filter, lastID EventID) (*Subscription, []Event) {
+	c := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+
+	var backlog []Event
+	if lastID == (EventID{}) {
+		return sub, backlog
+	}
+	for _, ev := range b.replay {
+		if (lastID.Epoch != b.epoch || ev.ID.Seq > lastID.Seq) && (filter == nil || filter(ev)) {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog
+}
+
+// Subscribers returns how many subscriptions are open.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// remove must be called with b.mu held.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.c)
+}
+
+// WriteEvent writes ev in the text/event-stream format.
+func WriteEvent(w io.Writer, ev Event) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "id: %s\nevent: %s\n", ev.ID, ev.Type)
+	for _, line := range strings.Split(string(ev.Data), "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteHeartbeat writes an SSE comment, which keeps proxies from closing an idle stream.
+func WriteHeartbeat(w io.Writer) error {
+	_, err := io.WriteString(w, ": heartbeat\n\n")
+	return err
+}
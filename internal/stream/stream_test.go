@@ -0,0 +1,139 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublishFilters(t *testing.T) {
+	b := NewBroker(DefaultReplaySize)
+	alice, bob := uuid.New(), uuid.New()
+
+	all, _ := b.Subscribe(nil, EventID{})
+	defer all.Close()
+	onlyAlice, _ := b.Subscribe(func(ev Event) bool { return ev.AuthorID == alice }, EventID{})
+	defer onlyAlice.Close()
+
+	b.Publish(EventChirpCreated, alice, []byte(`{"body":"hi"}`))
+	b.Publish(EventChirpCreated, bob, []byte(`{"body":"yo"}`))
+
+	if got := len(all.C); got != 2 {
+		t.Errorf("unfiltered subscriber got %d events, want 2", got)
+	}
+	if got := len(onlyAlice.C); got != 1 {
+		t.Fatalf("filtered subscriber got %d events, want 1", got)
+	}
+	if ev := <-onlyAlice.C; ev.AuthorID != alice || ev.ID.Seq != 1 {
+		t.Errorf("filtered subscriber got %+v", ev)
+	}
+}
+
+func TestSubscribeReplaysAfterLastID(t *testing.T) {
+	b := NewBroker(3)
+	author := uuid.New()
+	for i := 0; i < 5; i++ {
+		b.Publish(EventChirpCreated, author, []byte("{}"))
+	}
+
+	sub, backlog := b.Subscribe(nil, EventID{Epoch: b.epoch, Seq: 3})
+	defer sub.Close()
+	if len(backlog) != 2 || backlog[0].ID.Seq != 4 || backlog[1].ID.Seq != 5 {
+		t.Errorf("backlog after 3 = %+v, want events 4 and 5", backlog)
+	}
+
+	// Only the last three events are kept, so resuming from 1 can't get 2 back
+	_, backlog = b.Subscribe(nil, EventID{Epoch: b.epoch, Seq: 1})
+	if len(backlog) != 3 || backlog[0].ID.Seq != 3 {
+		t.Errorf("backlog after 1 = %+v, want events 3 to 5", backlog)
+	}
+
+	_, backlog = b.Subscribe(nil, EventID{})
+	if len(backlog) != 0 {
+		t.Errorf("fresh subscriber got a backlog of %d", len(backlog))
+	}
+}
+
+func TestSubscribeFromAnotherEpoch(t *testing.T) {
+	b := NewBroker(DefaultReplaySize)
+	author := uuid.New()
+	for i := 0; i < 3; i++ {
+		b.Publish(EventChirpCreated, author, []byte("{}"))
+	}
+
+	// The client last saw event 5 before a restart; every event since is new to it
+	_, backlog := b.Subscribe(nil, EventID{Epoch: b.epoch - 1, Seq: 5})
+	if len(backlog) != 3 || backlog[0].ID.Seq != 1 {
+		t.Errorf("backlog from another epoch = %+v, want events 1 to 3", backlog)
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    EventID
+		wantErr bool
+	}{
+		{name: "epoch and sequence", input: "17-4", want: EventID{Epoch: 17, Seq: 4}},
+		{name: "bare sequence", input: "4", want: EventID{Seq: 4}},
+		{name: "not a number", input: "17-x", wantErr: true},
+		{name: "negative", input: "-4", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEventID(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEventID(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseEventID(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(DefaultReplaySize)
+	slow, _ := b.Subscribe(nil, EventID{})
+	fast, _ := b.Subscribe(nil, EventID{})
+	defer fast.Close()
+
+	author := uuid.New()
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(EventChirpCreated, author, []byte("{}"))
+		<-fast.C
+	}
+
+	drained := 0
+	for range slow.C {
+		drained++
+	}
+	if drained != subscriberBuffer {
+		t.Errorf("slow subscriber drained %d events, want %d", drained, subscriberBuffer)
+	}
+	if !slow.Lagged() {
+		t.Error("slow subscriber not marked as lagged")
+	}
+	if fast.Lagged() {
+		t.Error("fast subscriber marked as lagged")
+	}
+	if got := b.Subscribers(); got != 1 {
+		t.Errorf("Subscribers() = %d, want 1", got)
+	}
+	slow.Close()
+}
+
+func TestWriteEvent(t *testing.T) {
+	var sb strings.Builder
+	err := WriteEvent(&sb, Event{ID: EventID{Epoch: 3, Seq: 7}, Type: EventChirpDeleted, Data: []byte("{\"id\":1}\n{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id: 3-7\nevent: chirp.deleted\ndata: {\"id\":1}\ndata: {}\n\n"
+	if sb.String() != want {
+		t.Errorf("WriteEvent() = %q, want %q", sb.String(), want)
+	}
+}
@@ -0,0 +1,131 @@
+// Package subscription is the Chirpy Red state machine. It turns Polka billing events into
+// the next subscription state; storing that state is left to the caller.
+package subscription
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusExpired  = "expired"
+)
+
+// Billing events sent by Polka, plus the one we record ourselves when a period lapses.
+const (
+	EventUpgraded      = "user.upgraded"
+	EventDowngraded    = "user.downgraded"
+	EventCanceled      = "subscription.canceled"
+	EventRenewed       = "subscription.renewed"
+	EventPaymentFailed = "payment.failed"
+	EventExpired       = "subscription.expired"
+)
+
+const DefaultPlan = "chirpy_red"
+
+var ErrNoSubscription = errors.New("user has no subscription")
+
+// State is a subscription. A zero PeriodEnd is an open-ended period: Polka hasn't said when
+// it runs out, so only a downgrade ends it.
+type State struct {
+	Plan        string
+	Status      string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// OpenEnded reports whether the current period has no end.
+func (s State) OpenEnded() bool {
+	return s.PeriodEnd.IsZero()
+}
+
+// Event is a billing event. Plan and the period are optional: a missing plan keeps the current
+// one or falls back to the default, a missing start is now and a missing end leaves the period
+// open-ended.
+type Event struct {
+	Type        string
+	Plan        string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// Known reports whether eventType is a billing event Apply understands.
+func Known(eventType string) bool {
+	switch eventType {
+	case EventUpgraded, EventDowngraded, EventCanceled, EventRenewed, EventPaymentFailed:
+		return true
+	}
+	return false
+}
+
+// Entitled reports whether the subscription still unlocks Chirpy Red at now. Canceled and
+// past due subscriptions keep their benefits until the paid period runs out.
+func (s State) Entitled(now time.Time) bool {
+	return s.Status != StatusExpired && (s.OpenEnded() || now.Before(s.PeriodEnd))
+}
+
+// Apply returns the state after ev. current is nil when the user has never subscribed, in
+// which case only an upgrade is allowed.
+func Apply(current *State, ev Event, now time.Time) (State, error) {
+	if ev.Type == EventUpgraded {
+		next := State{Plan: ev.Plan, Status: StatusActive}
+		if next.Plan == "" {
+			next.Plan = DefaultPlan
+			if current != nil {
+				next.Plan = current.Plan
+			}
+		}
+		next.PeriodStart, next.PeriodEnd = period(ev, now)
+		return next, nil
+	}
+
+	if current == nil {
+		return State{}, ErrNoSubscription
+	}
+	next := *current
+	switch ev.Type {
+	case EventRenewed:
+		start := now
+		if current.Status != StatusExpired && !current.OpenEnded() && current.PeriodEnd.After(now) {
+			start = current.PeriodEnd // renewing early extends the current period
+		}
+		next.PeriodStart, next.PeriodEnd = period(ev, start)
+		next.Status = StatusActive
+		if ev.Plan != "" {
+			next.Plan = ev.Plan
+		}
+	case EventDowngraded:
+		next.Status = StatusExpired
+		if next.OpenEnded() || next.PeriodEnd.After(now) {
+			next.PeriodEnd = now
+		}
+	case EventCanceled:
+		if next.Status != StatusExpired {
+			next.Status = StatusCanceled
+		}
+		// There's no paid period left to run out, so canceling an open-ended one ends it
+		if next.OpenEnded() {
+			next.PeriodEnd = now
+		}
+	case EventPaymentFailed:
+		if next.Status != StatusExpired {
+			next.Status = StatusPastDue
+		}
+	case EventExpired:
+		next.Status = StatusExpired
+	default:
+		return State{}, errors.New("unknown subscription event " + ev.Type)
+	}
+	return next, nil
+}
+
+func period(ev Event, defaultStart time.Time) (time.Time, time.Time) {
+	start := ev.PeriodStart
+	if start.IsZero() {
+		start = defaultStart
+	}
+	return start, ev.PeriodEnd
+}
@@ -0,0 +1,115 @@
+package subscription
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	month := 30 * 24 * time.Hour
+
+	state, err := Apply(nil, Event{Type: EventUpgraded, PeriodEnd: now.Add(month)}, now)
+	if err != nil {
+		t.Fatalf("error upgrading: %v", err)
+	}
+	if state.Status != StatusActive || state.Plan != DefaultPlan || !state.PeriodEnd.Equal(now.Add(month)) {
+		t.Fatalf("unexpected state after upgrade: %+v", state)
+	}
+
+	// renewing before the period ends extends it from the current end
+	renewAt := now.Add(20 * 24 * time.Hour)
+	state, err = Apply(&state, Event{Type: EventRenewed, PeriodEnd: now.Add(2 * month)}, renewAt)
+	if err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+	if !state.PeriodStart.Equal(now.Add(month)) || !state.PeriodEnd.Equal(now.Add(2*month)) {
+		t.Errorf("renewal should extend the period: %+v", state)
+	}
+
+	state, err = Apply(&state, Event{Type: EventPaymentFailed}, renewAt)
+	if err != nil {
+		t.Fatalf("error applying payment failure: %v", err)
+	}
+	if state.Status != StatusPastDue || !state.Entitled(renewAt) {
+		t.Errorf("past due subscriptions keep benefits until the period ends: %+v", state)
+	}
+
+	state, err = Apply(&state, Event{Type: EventCanceled}, renewAt)
+	if err != nil {
+		t.Fatalf("error canceling: %v", err)
+	}
+	if state.Status != StatusCanceled || !state.Entitled(renewAt) {
+		t.Errorf("canceled subscriptions keep benefits until the period ends: %+v", state)
+	}
+	if state.Entitled(state.PeriodEnd) {
+		t.Error("benefits should stop once the period ends")
+	}
+
+	state, err = Apply(&state, Event{Type: EventDowngraded}, renewAt)
+	if err != nil {
+		t.Fatalf("error downgrading: %v", err)
+	}
+	if state.Status != StatusExpired || state.Entitled(renewAt) {
+		t.Errorf("downgrade should end benefits immediately: %+v", state)
+	}
+
+	state, err = Apply(&state, Event{Type: EventCanceled}, renewAt)
+	if err != nil || state.Status != StatusExpired {
+		t.Errorf("canceling an expired subscription should leave it expired: %+v %v", state, err)
+	}
+}
+
+func TestOpenEndedPeriod(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(10 * 365 * 24 * time.Hour)
+
+	state, err := Apply(nil, Event{Type: EventUpgraded}, now)
+	if err != nil {
+		t.Fatalf("error upgrading: %v", err)
+	}
+	if !state.OpenEnded() || !state.Entitled(later) {
+		t.Fatalf("an upgrade without a period end should never lapse: %+v", state)
+	}
+
+	state, err = Apply(&state, Event{Type: EventPaymentFailed}, now)
+	if err != nil || !state.OpenEnded() || !state.Entitled(later) {
+		t.Errorf("a failed payment shouldn't end an open-ended period: %+v %v", state, err)
+	}
+
+	canceled, err := Apply(&state, Event{Type: EventCanceled}, now)
+	if err != nil || canceled.OpenEnded() || canceled.Entitled(now) {
+		t.Errorf("canceling an open-ended period should end it: %+v %v", canceled, err)
+	}
+
+	downgraded, err := Apply(&state, Event{Type: EventDowngraded}, now)
+	if err != nil || downgraded.OpenEnded() || downgraded.Entitled(now) {
+		t.Errorf("downgrading an open-ended period should end it: %+v %v", downgraded, err)
+	}
+}
+
+func TestApplyUsesEventPeriodAndPlan(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	start, end := now.Add(-time.Hour), now.Add(365*24*time.Hour)
+
+	state, err := Apply(nil, Event{Type: EventUpgraded, Plan: "chirpy_red_yearly", PeriodStart: start, PeriodEnd: end}, now)
+	if err != nil {
+		t.Fatalf("error upgrading: %v", err)
+	}
+	if state.Plan != "chirpy_red_yearly" || !state.PeriodStart.Equal(start) || !state.PeriodEnd.Equal(end) {
+		t.Errorf("event values should win over defaults: %+v", state)
+	}
+}
+
+func TestApplyWithoutSubscription(t *testing.T) {
+	now := time.Now()
+	for _, eventType := range []string{EventRenewed, EventDowngraded, EventCanceled, EventPaymentFailed} {
+		if _, err := Apply(nil, Event{Type: eventType}, now); !errors.Is(err, ErrNoSubscription) {
+			t.Errorf("%s without a subscription: got err %v, want ErrNoSubscription", eventType, err)
+		}
+	}
+	if Known("user.deleted") || !Known(EventRenewed) {
+		t.Error("Known reported the wrong events")
+	}
+}
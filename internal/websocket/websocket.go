@@ -0,0 +1,374 @@
+// Package websocket is a small RFC 6455 server implementation on top of net/http: the opening
+// handshake, framing, fragmentation and the ping/pong/close control frames. It has no
+// extensions or subprotocols, which is all the realtime API needs.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcodes from RFC 6455 section 5.2.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes from RFC 6455 section 7.4.1. 4000-4999 are free for applications.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooLarge        = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize limits a message, after reassembling its fragments.
+const DefaultMaxMessageSize = 64 << 10
+
+// handshakeGUID is appended to the client's key to make Sec-WebSocket-Accept.
+const handshakeGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrProtocol     = errors.New("websocket: protocol error")
+	ErrTooLarge     = errors.New("websocket: message too large")
+	ErrClosed       = errors.New("websocket: connection closed")
+)
+
+// CloseError is returned by ReadMessage once the peer sends a close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed by peer with %d %s", e.Code, e.Reason)
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(handshakeGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+type Upgrader struct {
+	// CheckOrigin rejects the handshake when it returns false. Nil allows every origin.
+	CheckOrigin func(*http.Request) bool
+	// MaxMessageSize defaults to DefaultMaxMessageSize.
+	MaxMessageSize int64
+}
+
+// Upgrade validates the opening handshake, answers it and takes over the connection. On
+// failure an HTTP error has already been written.
+func (u Upgrader) Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a websocket upgrade", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(req) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, ErrBadHandshake
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Clear any deadlines the HTTP server set; the caller manages them from here
+	netConn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	maxSize := u.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return newConn(netConn, rw.Reader, false, maxSize), nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is one websocket connection. Reads must come from a single goroutine; writes are safe
+// from any number of them.
+type Conn struct {
+	netConn net.Conn
+	br      *bufio.Reader
+	// client connections mask what they send and expect unmasked frames back. Servers do the
+	// opposite. Only tests act as the client.
+	client  bool
+	maxSize int64
+
+	// PongHandler, if set, is called from ReadMessage for every pong, which is where a server
+	// usually pushes its read deadline back.
+	PongHandler func()
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newConn(netConn net.Conn, br *bufio.Reader, client bool, maxSize int64) *Conn {
+	if br == nil {
+		br = bufio.NewReader(netConn)
+	}
+	return &Conn{netConn: netConn, br: br, client: client, maxSize: maxSize}
+}
+
+// SetReadDeadline sets the deadline for the next ReadMessage. Pings from the peer answered
+// during the read don't extend it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.netConn.SetReadDeadline(t)
+}
+
+// Close closes the underlying connection without a close handshake.
+func (c *Conn) Close() error {
+	return c.netConn.Close()
+}
+
+// ReadMessage returns the next text or binary message, reassembling fragments. Pings are
+// answered along the way. When the peer closes, the close is echoed and a *CloseError
+// returned; protocol violations close the connection with the matching code.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageOp := -1
+	var message []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				c.WriteClose(CloseProtocolError, "")
+			}
+			return 0, nil, err
+		}
+
+		switch f.op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			if c.PongHandler != nil {
+				c.PongHandler()
+			}
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: 1005}
+			if len(f.payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(f.payload))
+				closeErr.Reason = string(f.payload[2:])
+			}
+			c.WriteClose(CloseNormal, "")
+			return 0, nil, closeErr
+		case OpText, OpBinary:
+			if messageOp != -1 {
+				c.WriteClose(CloseProtocolError, "")
+				return 0, nil, fmt.Errorf("%w: new message before the last one finished", ErrProtocol)
+			}
+			messageOp = f.op
+		case OpContinuation:
+			if messageOp == -1 {
+				c.WriteClose(CloseProtocolError, "")
+				return 0, nil, fmt.Errorf("%w: continuation without a message", ErrProtocol)
+			}
+		default:
+			c.WriteClose(CloseProtocolError, "")
+			return 0, nil, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, f.op)
+		}
+
+		if int64(len(message))+int64(len(f.payload)) > c.maxSize {
+			c.WriteClose(CloseTooLarge, "")
+			return 0, nil, ErrTooLarge
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageOp == OpText && !utf8.Valid(message) {
+			c.WriteClose(CloseInvalidPayload, "")
+			return 0, nil, fmt.Errorf("%w: text message is not utf-8", ErrProtocol)
+		}
+		return messageOp, message, nil
+	}
+}
+
+type frame struct {
+	fin     bool
+	op      int
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: header[0]&0x80 != 0, op: int(header[0] & 0x0F)}
+	if header[0]&0x70 != 0 {
+		return frame{}, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return frame{}, fmt.Errorf("%w: wrong masking", ErrProtocol)
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.op >= OpClose && (!f.fin || length > 125) {
+		return frame{}, fmt.Errorf("%w: bad control frame", ErrProtocol)
+	}
+	if length > uint64(c.maxSize) {
+		c.WriteClose(CloseTooLarge, "")
+		return frame{}, ErrTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// WriteMessage sends data as a single text or binary frame.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// WritePing sends a ping; the peer's pong is swallowed by ReadMessage.
+func (c *Conn) WritePing() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+// WriteClose starts or answers the close handshake. Only the first call sends anything.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	return c.writeFrame(OpClose, payload)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if op == OpClose {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|byte(op))
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	c.netConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.netConn.Write(buf)
+	return err
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey() = %q", got)
+	}
+}
+
+// dial does the client half of the opening handshake against srv.
+func dial(t *testing.T, srv *httptest.Server, header http.Header) (*Conn, *http.Response) {
+	t.Helper()
+	netConn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { netConn.Close() })
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(netConn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	return newConn(netConn, br, true, DefaultMaxMessageSize), resp
+}
+
+func echoServer(t *testing.T, u Upgrader) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(op, data)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t, Upgrader{})
+	conn, resp := dial(t, srv, nil)
+	if conn == nil {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	for _, msg := range []string{"hello", strings.Repeat("x", 300), strings.Repeat("y", 40000)} {
+		if err := conn.WriteMessage(OpText, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if op != OpText || string(data) != msg {
+			t.Errorf("echo of %d bytes = op %d, %d bytes", len(msg), op, len(data))
+		}
+	}
+
+	if err := conn.WriteMessage(OpBinary, make([]byte, DefaultMaxMessageSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !isClose(err, CloseTooLarge) {
+		t.Errorf("oversized message got %v, want close %d", err, CloseTooLarge)
+	}
+}
+
+func isClose(err error, code int) bool {
+	var closeErr *CloseError
+	return errors.As(err, &closeErr) && closeErr.Code == code
+}
+
+func TestFragmentsAndPing(t *testing.T) {
+	srv := echoServer(t, Upgrader{})
+	conn, _ := dial(t, srv, nil)
+
+	// A ping between the fragments is answered without disturbing the message
+	conn.writeFrameFin(OpText, []byte("hel"), false)
+	conn.writeFrame(OpPing, []byte("p"))
+	conn.writeFrameFin(OpContinuation, []byte("lo"), true)
+
+	f, err := conn.readFrame()
+	if err != nil || f.op != OpPong || string(f.payload) != "p" {
+		t.Fatalf("expected pong, got %+v, %v", f, err)
+	}
+	op, data, err := conn.ReadMessage()
+	if err != nil || op != OpText || string(data) != "hello" {
+		t.Errorf("reassembled = %d %q %v", op, data, err)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	srv := echoServer(t, Upgrader{})
+	conn, _ := dial(t, srv, nil)
+
+	if err := conn.WriteClose(CloseNormal, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := conn.readFrame()
+	if err != nil || f.op != OpClose {
+		t.Fatalf("expected close echo, got %+v, %v", f, err)
+	}
+	if err := conn.WriteMessage(OpText, []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("write after close = %v, want ErrClosed", err)
+	}
+}
+
+func TestRejectsUnmaskedClientFrames(t *testing.T) {
+	srv := echoServer(t, Upgrader{})
+	conn, _ := dial(t, srv, nil)
+
+	// Pretend to be a server so the frame goes out unmasked
+	conn.client = false
+	conn.WriteMessage(OpText, []byte("hi"))
+	conn.client = true
+
+	f, err := conn.readFrame()
+	if err != nil || f.op != OpClose || len(f.payload) < 2 || int(f.payload[0])<<8|int(f.payload[1]) != CloseProtocolError {
+		t.Errorf("expected protocol error close, got %+v, %v", f, err)
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	srv := echoServer(t, Upgrader{CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "" || r.Header.Get("Origin") == "http://localhost:8080"
+	}})
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"old version", http.Header{"Sec-Websocket-Version": {"8"}}, http.StatusUpgradeRequired},
+		{"bad key", http.Header{"Sec-Websocket-Key": {"short"}}, http.StatusBadRequest},
+		{"not an upgrade", http.Header{"Upgrade": {"h2c"}}, http.StatusBadRequest},
+		{"foreign origin", http.Header{"Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"allowed origin", http.Header{"Origin": {"http://localhost:8080"}}, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		_, resp := dial(t, srv, tt.header)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// writeFrameFin is writeFrame with control over the FIN bit, for sending fragments.
+func (c *Conn) writeFrameFin(op int, payload []byte, fin bool) error {
+	if fin {
+		return c.writeFrame(op, payload)
+	}
+	var mask [4]byte
+	buf := []byte{byte(op), 0x80 | byte(len(payload))}
+	buf = append(buf, mask[:]...)
+	buf = append(buf, payload...)
+	_, err := c.netConn.Write(buf)
+	return err
+}
@@ -1,12 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/alexedwards/argon2id"
+	"github.com/cbrookscode/chirpy/internal/auth"
 	"github.com/cbrookscode/chirpy/internal/database"
+	"github.com/cbrookscode/chirpy/internal/entitlement"
+	"github.com/cbrookscode/chirpy/internal/linkpreview"
+	"github.com/cbrookscode/chirpy/internal/loginguard"
+	"github.com/cbrookscode/chirpy/internal/mail"
+	"github.com/cbrookscode/chirpy/internal/media"
+	"github.com/cbrookscode/chirpy/internal/outbound"
+	"github.com/cbrookscode/chirpy/internal/password"
+	"github.com/cbrookscode/chirpy/internal/ratelimit"
+	"github.com/cbrookscode/chirpy/internal/realtime"
+	"github.com/cbrookscode/chirpy/internal/stream"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -24,10 +40,74 @@ func main() {
 	}
 	dbQueries := database.New(db)
 
+	hashParams, err := loadHashParams()
+	if err != nil {
+		log.Fatalf("issue loading argon2id params: %v", err)
+	}
+	if err := auth.SetHashParams(hashParams); err != nil {
+		log.Fatalf("issue setting argon2id params: %v", err)
+	}
+
+	passwordChecker, err := loadPasswordChecker()
+	if err != nil {
+		log.Fatalf("issue loading password policy: %v", err)
+	}
+
+	magicLinkURL := os.Getenv("MAGIC_LINK_URL")
+	if magicLinkURL == "" {
+		magicLinkURL = "http://localhost:" + port + "/app/login/magic"
+	}
+
+	entitlements := entitlement.DefaultConfig()
+	if path := os.Getenv("ENTITLEMENTS_FILE"); path != "" {
+		entitlements, err = entitlement.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("issue loading entitlements: %v", err)
+		}
+	}
+
+	webhookLogRetention := 90 * 24 * time.Hour
+	if raw := os.Getenv("WEBHOOK_LOG_RETENTION_DAYS"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("WEBHOOK_LOG_RETENTION_DAYS: %v", err)
+		}
+		webhookLogRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	realtimeHub := realtime.NewHub()
+	realtimeBus, err := realtime.NewPostgresBus(db, dbURL, realtimeHub)
+	if err != nil {
+		log.Fatalf("issue listening for realtime messages: %v", err)
+	}
+	go realtimeBus.Run(context.Background())
+
 	myplatform := os.Getenv("PLATFORM")
 	theSauce := os.Getenv("SECRET_SAUCE")
 	polka := os.Getenv("POLKA_KEY")
-	cfg := &apiConfig{db: dbQueries, platform: myplatform, secret: theSauce, polkaKey: polka}
+	cfg := &apiConfig{
+		db:                  dbQueries,
+		dbConn:              db,
+		platform:            myplatform,
+		secret:              theSauce,
+		polkaKey:            polka,
+		loginGuard:          loginguard.New(loginguard.DefaultConfig()),
+		passwordChecker:     passwordChecker,
+		mailer:              loadMailer(),
+		magicLinkURL:        magicLinkURL,
+		entitlements:        entitlements,
+		chirpLimiter:        ratelimit.New(time.Hour),
+		mediaLimiter:        ratelimit.New(time.Hour),
+		webhookLimiter:      ratelimit.New(time.Minute),
+		magicLinkLimiter:    ratelimit.New(time.Hour),
+		webhookSender:       outbound.NewSender(nil),
+		chirpBroker:         stream.NewBroker(stream.DefaultReplaySize),
+		realtimeHub:         realtimeHub,
+		realtimeBus:         realtimeBus,
+		webhookLogRetention: webhookLogRetention,
+		mediaStore:          loadMediaStore(),
+		linkPreviews:        linkpreview.NewFetcher(nil),
+	}
 
 	// create log file to write all server logs to
 	logfile, err := os.OpenFile("server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -42,18 +122,89 @@ func main() {
 	srvmux := http.NewServeMux()
 	srvmux.Handle("/app/", cfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathroot)))))
 	srvmux.HandleFunc("GET /api/healthz", handlerReadiness)
-	srvmux.HandleFunc("GET /admin/metrics", cfg.handlerMetrics)
-	srvmux.HandleFunc("POST /admin/reset", cfg.handlerReset)
-	srvmux.HandleFunc("POST /api/chirps", cfg.handlerChirps)
+	srvmux.HandleFunc("GET /admin/metrics", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerMetrics))
+	srvmux.HandleFunc("POST /admin/reset", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerReset))
+	srvmux.HandleFunc("POST /admin/unlock", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerUnlockAccount))
+	srvmux.HandleFunc("GET /admin/webhooks/events", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerListWebhookEvents))
+	srvmux.HandleFunc("GET /admin/webhooks/events/{eventLogID}", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerGetWebhookEvent))
+	srvmux.HandleFunc("POST /admin/webhooks/events/{eventLogID}/replay", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerReplayWebhookEvent))
+	srvmux.HandleFunc("POST /api/chirps", cfg.middlewareScopedAuth(cfg.handlerChirps))
 	srvmux.HandleFunc("POST /api/users", cfg.handlerCreateUser)
-	srvmux.HandleFunc("GET /api/chirps", cfg.handlerGetChirps)
-	srvmux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerGetSingleChirp)
+	srvmux.HandleFunc("GET /api/chirps", cfg.middlewareOptionalAuth(cfg.handlerGetChirps))
+	srvmux.HandleFunc("GET /api/chirps/{chirpID}", cfg.middlewareOptionalAuth(cfg.handlerGetSingleChirp))
+	srvmux.HandleFunc("GET /api/chirps/scheduled", cfg.middlewareScopedAuth(cfg.handlerListScheduledChirps))
+	srvmux.HandleFunc("GET /api/stream/chirps", cfg.middlewareAuth(cfg.handlerStreamChirps))
+	srvmux.HandleFunc("GET /api/ws", cfg.handlerRealtime)
+	srvmux.HandleFunc("POST /api/chirps/{chirpID}/like", cfg.middlewareAuth(cfg.handlerLikeChirp))
+	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}/like", cfg.middlewareAuth(cfg.handlerUnlikeChirp))
+	srvmux.HandleFunc("POST /api/chirps/{chirpID}/vote", cfg.middlewareAuth(cfg.handlerVotePoll))
+	srvmux.HandleFunc("POST /api/chirps/{chirpID}/pin", cfg.middlewareScopedAuth(cfg.handlerPinChirp))
+	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}/pin", cfg.middlewareScopedAuth(cfg.handlerUnpinChirp))
+	srvmux.HandleFunc("PUT /api/chirps/{chirpID}/schedule", cfg.middlewareScopedAuth(cfg.handlerRescheduleChirp))
+	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}/schedule", cfg.middlewareScopedAuth(cfg.handlerCancelScheduledChirp))
+	srvmux.HandleFunc("POST /api/chirps/{chirpID}/bookmark", cfg.middlewareAuth(cfg.handlerBookmarkChirp))
+	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}/bookmark", cfg.middlewareAuth(cfg.handlerUnbookmarkChirp))
+	srvmux.HandleFunc("POST /api/drafts", cfg.middlewareScopedAuth(cfg.handlerCreateDraft))
+	srvmux.HandleFunc("GET /api/drafts", cfg.middlewareScopedAuth(cfg.handlerListDrafts))
+	srvmux.HandleFunc("GET /api/drafts/{draftID}", cfg.middlewareScopedAuth(cfg.handlerGetDraft))
+	srvmux.HandleFunc("PUT /api/drafts/{draftID}", cfg.middlewareScopedAuth(cfg.handlerUpdateDraft))
+	srvmux.HandleFunc("DELETE /api/drafts/{draftID}", cfg.middlewareScopedAuth(cfg.handlerDeleteDraft))
+	srvmux.HandleFunc("POST /api/drafts/{draftID}/publish", cfg.middlewareScopedAuth(cfg.handlerPublishDraft))
+	srvmux.HandleFunc("POST /api/media", cfg.middlewareScopedAuth(cfg.handlerUploadMedia))
+	srvmux.HandleFunc("GET /api/media/{mediaID}", cfg.middlewareOptionalAuth(cfg.handlerGetMedia))
+	srvmux.HandleFunc("GET /api/media/{mediaID}/thumbnail", cfg.middlewareOptionalAuth(cfg.handlerGetMediaThumbnail))
+	srvmux.HandleFunc("GET /api/bookmarks", cfg.middlewareAuth(cfg.handlerListBookmarks))
+	srvmux.HandleFunc("POST /api/bookmarks/folders", cfg.middlewareAuth(cfg.handlerCreateBookmarkFolder))
+	srvmux.HandleFunc("GET /api/bookmarks/folders", cfg.middlewareAuth(cfg.handlerListBookmarkFolders))
+	srvmux.HandleFunc("DELETE /api/bookmarks/folders/{folderID}", cfg.middlewareAuth(cfg.handlerDeleteBookmarkFolder))
 	srvmux.HandleFunc("POST /api/login", cfg.handlerValidateUser)
+	srvmux.HandleFunc("POST /api/login/session", cfg.handlerCookieLogin)
+	srvmux.HandleFunc("POST /api/session/refresh", cfg.handlerCookieRefresh)
+	srvmux.HandleFunc("POST /api/session/logout", cfg.handlerCookieLogout)
+	srvmux.HandleFunc("POST /api/login/magic", cfg.handlerRequestMagicLink)
+	srvmux.HandleFunc("POST /api/login/magic/verify", cfg.handlerVerifyMagicLink)
+	srvmux.HandleFunc("POST /api/oauth/clients", cfg.middlewareAuth(cfg.handlerCreateOAuthClient))
+	srvmux.HandleFunc("GET /oauth/authorize", cfg.handlerOAuthAuthorize)
+	srvmux.HandleFunc("POST /oauth/authorize", cfg.handlerOAuthConsent)
+	srvmux.HandleFunc("POST /oauth/token", cfg.handlerOAuthToken)
 	srvmux.HandleFunc("POST /api/refresh", cfg.handlerRefreshToken)
 	srvmux.HandleFunc("POST /api/revoke", cfg.handlerRevokeRefToken)
-	srvmux.HandleFunc("PUT /api/users", cfg.handlerUpdateUser)
-	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handlerDeleteChirp)
+	srvmux.HandleFunc("PUT /api/users", cfg.middlewareAuth(cfg.handlerUpdateUser))
+	srvmux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.middlewareScopedAuth(cfg.handlerEditChirp))
+	srvmux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.middlewareScopedAuth(cfg.handlerDeleteChirp))
 	srvmux.HandleFunc("/api/polka/webhooks", cfg.handlerChirpyRed)
+	srvmux.HandleFunc("POST /api/users/{userID}/follow", cfg.middlewareAuth(cfg.handlerFollowUser))
+	srvmux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.middlewareAuth(cfg.handlerUnfollowUser))
+	srvmux.HandleFunc("POST /api/users/{userID}/block", cfg.middlewareAuth(cfg.handlerBlockUser))
+	srvmux.HandleFunc("DELETE /api/users/{userID}/block", cfg.middlewareAuth(cfg.handlerUnblockUser))
+	srvmux.HandleFunc("POST /api/users/{userID}/mute", cfg.middlewareAuth(cfg.handlerMuteUser))
+	srvmux.HandleFunc("DELETE /api/users/{userID}/mute", cfg.middlewareAuth(cfg.handlerUnmuteUser))
+	srvmux.HandleFunc("POST /api/conversations", cfg.middlewareAuth(cfg.handlerCreateConversation))
+	srvmux.HandleFunc("GET /api/conversations", cfg.middlewareAuth(cfg.handlerListConversations))
+	srvmux.HandleFunc("GET /api/conversations/unread", cfg.middlewareAuth(cfg.handlerUnreadMessages))
+	srvmux.HandleFunc("GET /api/conversations/{conversationID}/messages", cfg.middlewareAuth(cfg.handlerListMessages))
+	srvmux.HandleFunc("POST /api/conversations/{conversationID}/messages", cfg.middlewareAuth(cfg.handlerSendMessage))
+	srvmux.HandleFunc("POST /api/conversations/{conversationID}/read", cfg.middlewareAuth(cfg.handlerMarkConversationRead))
+	srvmux.HandleFunc("GET /api/notifications", cfg.middlewareAuth(cfg.handlerListNotifications))
+	srvmux.HandleFunc("GET /api/notifications/unread", cfg.middlewareAuth(cfg.handlerUnreadNotifications))
+	srvmux.HandleFunc("POST /api/notifications/read", cfg.middlewareAuth(cfg.handlerMarkNotificationsRead))
+	srvmux.HandleFunc("GET /api/notifications/preferences", cfg.middlewareAuth(cfg.handlerGetNotificationPreferences))
+	srvmux.HandleFunc("PUT /api/notifications/preferences", cfg.middlewareAuth(cfg.handlerUpdateNotificationPreferences))
+	srvmux.HandleFunc("GET /api/users/me/subscription", cfg.middlewareAuth(cfg.handlerGetSubscription))
+	srvmux.HandleFunc("POST /api/webhooks", cfg.middlewareScopedAuth(cfg.handlerCreateWebhook))
+	srvmux.HandleFunc("GET /api/webhooks", cfg.middlewareScopedAuth(cfg.handlerListWebhooks))
+	srvmux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.middlewareScopedAuth(cfg.handlerDeleteWebhook))
+	srvmux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.middlewareScopedAuth(cfg.handlerListWebhookDeliveries))
+	srvmux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/retry", cfg.middlewareScopedAuth(cfg.handlerRetryWebhookDelivery))
+
+	go runPeriodically(context.Background(), time.Minute, "expiring subscriptions", cfg.expireSubscriptions)
+	go runPeriodically(context.Background(), 5*time.Second, "delivering webhooks", cfg.deliverWebhooks)
+	go runPeriodically(context.Background(), 10*time.Second, "publishing scheduled chirps", cfg.publishScheduledChirps)
+	go runPeriodically(context.Background(), time.Hour, "pruning unattached media", cfg.pruneUnattachedMedia)
+	go runPeriodically(context.Background(), 5*time.Second, "fetching link previews", cfg.fetchLinkPreviews)
+	if cfg.webhookLogRetention > 0 {
+		go runPeriodically(context.Background(), time.Hour, "pruning webhook log", cfg.pruneWebhookLog)
+	}
 
 	srv := http.Server{
 		Handler: srvmux,
@@ -63,3 +214,116 @@ func main() {
 	log.Printf("serving on port %v\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// loadHashParams reads ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM, falling back
+// to auth.DefaultHashParams for any that are unset. Run cmd/hashbench to pick values for a host.
+func loadHashParams() (argon2id.Params, error) {
+	params := auth.DefaultHashParams
+	envs := []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"ARGON2_MEMORY_KIB", 32, func(v uint64) { params.Memory = uint32(v) }},
+		{"ARGON2_ITERATIONS", 32, func(v uint64) { params.Iterations = uint32(v) }},
+		{"ARGON2_PARALLELISM", 8, func(v uint64) { params.Parallelism = uint8(v) }},
+	}
+	for _, env := range envs {
+		raw := os.Getenv(env.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(raw, 10, env.bits)
+		if err != nil {
+			return params, fmt.Errorf("%s: %v", env.name, err)
+		}
+		env.set(v)
+	}
+	return params, nil
+}
+
+// loadPasswordChecker builds the password policy from PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and
+// PASSWORD_MIN_SCORE, and loads BREACHED_PASSWORDS_FILE if it is set.
+func loadPasswordChecker() (*password.Checker, error) {
+	policy := password.DefaultPolicy()
+	envs := []struct {
+		name string
+		dest *int
+	}{
+		{"PASSWORD_MIN_LENGTH", &policy.MinLength},
+		{"PASSWORD_MAX_LENGTH", &policy.MaxLength},
+		{"PASSWORD_MIN_SCORE", &policy.MinScore},
+	}
+	for _, env := range envs {
+		raw := os.Getenv(env.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", env.name, err)
+		}
+		*env.dest = v
+	}
+
+	var breached *password.BreachedList
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		var err error
+		breached, err = password.OpenBreachedList(dir)
+		if err != nil {
+			return nil, fmt.Errorf("opening breached passwords: %v", err)
+		}
+		log.Printf("checking passwords against the breached password ranges in %s", dir)
+	}
+	return password.NewChecker(policy, breached), nil
+}
+
+// loadMailer sends through SMTP_HOST when it's set, otherwise mail is written to the server log.
+func loadMailer() mail.Sender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mail.LogSender{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@chirpy.local"
+	}
+	return &mail.SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// loadMediaStore keeps uploads in an S3 compatible bucket when MEDIA_STORAGE is "s3", otherwise
+// in MEDIA_DIR on this server.
+func loadMediaStore() media.Store {
+	if os.Getenv("MEDIA_STORAGE") == "s3" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &media.S3Store{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          region,
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+	}
+	dir := os.Getenv("MEDIA_DIR")
+	if dir == "" {
+		dir = "media"
+	}
+	store, err := media.NewLocalStore(dir)
+	if err != nil {
+		log.Fatalf("issue opening media directory: %v", err)
+	}
+	return store
+}
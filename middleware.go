@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	accessCookieName  = "chirpy_access"
+	refreshCookieName = "chirpy_refresh"
+	csrfCookieName    = "chirpy_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+type contextKey string
+
+const accessTokenKey contextKey = "accessToken"
+
+// middlewareAuth authenticates the request with a bearer token or, for browser sessions, the
+// access token cookie. Cookie authenticated requests that change state must also echo the CSRF
+// cookie in the X-CSRF-Token header. The validated token is available through accessTokenFrom.
+// Tokens issued to OAuth clients are refused; routes third-party apps may call use
+// middlewareScopedAuth instead.
+func (cfg *apiConfig) middlewareAuth(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareScopedAuth(func(resWriter http.ResponseWriter, req *http.Request) {
+		if !accessTokenFrom(req.Context()).FirstParty() {
+			respondWithError(resWriter, "Third-party apps can't use this endpoint", http.StatusForbidden, nil)
+			return
+		}
+		next(resWriter, req)
+	})
+}
+
+// middlewareScopedAuth authenticates like middlewareAuth but also lets OAuth client tokens
+// through. Handlers behind it must check the scopes they need with HasScope.
+func (cfg *apiConfig) middlewareScopedAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(resWriter http.ResponseWriter, req *http.Request) {
+		tokenString, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			cookie, cookieErr := req.Cookie(accessCookieName)
+			if cookieErr != nil {
+				respondWithError(resWriter, authHeaderErrorMsg(err), http.StatusUnauthorized, nil)
+				return
+			}
+			if !validCSRF(req) {
+				respondWithError(resWriter, "Missing or invalid CSRF token", http.StatusForbidden, nil)
+				return
+			}
+			tokenString = cookie.Value
+		}
+
+		accessToken, err := auth.ValidateAccessToken(tokenString, cfg.secret)
+		if err != nil {
+			respondWithError(resWriter, "Invalid token", http.StatusUnauthorized, nil)
+			return
+		}
+		ctx := context.WithValue(req.Context(), accessTokenKey, accessToken)
+		next(resWriter, req.WithContext(ctx))
+	}
+}
+
+// middlewareOptionalAuth lets anonymous requests through for endpoints that anyone can read but
+// that tailor what they return to a signed in caller. A token that's missing, expired or
+// otherwise invalid leaves the request anonymous rather than failing it, so a stale session
+// cookie doesn't lock a browser out of public pages.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(resWriter http.ResponseWriter, req *http.Request) {
+		tokenString, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			cookie, cookieErr := req.Cookie(accessCookieName)
+			if cookieErr != nil || !validCSRF(req) {
+				next(resWriter, req)
+				return
+			}
+			tokenString = cookie.Value
+		}
+
+		accessToken, err := auth.ValidateAccessToken(tokenString, cfg.secret)
+		if err != nil {
+			next(resWriter, req)
+			return
+		}
+		ctx := context.WithValue(req.Context(), accessTokenKey, accessToken)
+		next(resWriter, req.WithContext(ctx))
+	}
+}
+
+// middlewareRequireRole authenticates the request like middlewareAuth and then only lets
+// users whose role is at least role through.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareAuth(func(resWriter http.ResponseWriter, req *http.Request) {
+		if !accessTokenFrom(req.Context()).HasRole(role) {
+			respondWithError(resWriter, "You don't have permission to do that", http.StatusForbidden, nil)
+			return
+		}
+		next(resWriter, req)
+	})
+}
+
+// accessTokenFrom returns the token stored by middlewareAuth.
+func accessTokenFrom(ctx context.Context) auth.AccessToken {
+	accessToken, _ := ctx.Value(accessTokenKey).(auth.AccessToken)
+	return accessToken
+}
+
+// viewerFrom returns the caller behind middlewareOptionalAuth, or nothing for anonymous requests.
+func viewerFrom(ctx context.Context) uuid.NullUUID {
+	userID := accessTokenFrom(ctx).UserID
+	return uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil}
+}
+
+// validCSRF implements the double-submit check: safe methods pass, anything else needs the
+// header to match the CSRF cookie, which only pages on our own origin can read.
+func validCSRF(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := req.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := req.Header.Get(csrfHeaderName)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cbrookscode/chirpy/internal/auth"
+	"github.com/cbrookscode/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const testSecret = "middleware-test-secret"
+
+func TestMiddlewareOptionalAuth(t *testing.T) {
+	cfg := &apiConfig{secret: testSecret}
+	userID := uuid.New()
+	valid, err := auth.MakeJWT(userID, auth.RoleUser, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := auth.MakeJWT(userID, auth.RoleUser, "some other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		cookie     string
+		wantViewer bool
+	}{
+		{"anonymous", "", "", false},
+		{"bearer", "Bearer " + valid, "", true},
+		{"cookie", "", valid, true},
+		{"invalid bearer", "Bearer " + forged, "", false},
+		{"stale cookie", "", forged, false},
+		{"garbage header", "Basic abc", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var viewer uuid.NullUUID
+			called := false
+			handler := cfg.middlewareOptionalAuth(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				viewer = viewerFrom(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: accessCookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if !called {
+				t.Fatalf("handler not called, got status %d", rec.Code)
+			}
+			if viewer.Valid != tt.wantViewer || (tt.wantViewer && viewer.UUID != userID) {
+				t.Errorf("viewer = %v, want signed in %v", viewer, tt.wantViewer)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAuthCSRF(t *testing.T) {
+	cfg := &apiConfig{secret: testSecret}
+	valid, err := auth.MakeJWT(uuid.New(), auth.RoleUser, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		bearer     bool
+		csrfCookie string
+		csrfHeader string
+		wantStatus int
+	}{
+		{"safe method without token", http.MethodGet, false, "", "", http.StatusOK},
+		{"missing token", http.MethodPost, false, "", "", http.StatusForbidden},
+		{"missing header", http.MethodPost, false, "csrf-value", "", http.StatusForbidden},
+		{"missing cookie", http.MethodDelete, false, "", "csrf-value", http.StatusForbidden},
+		{"mismatched token", http.MethodPut, false, "csrf-value", "other-value", http.StatusForbidden},
+		{"matching token", http.MethodPost, false, "csrf-value", "csrf-value", http.StatusOK},
+		{"bearer needs no token", http.MethodPost, true, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := cfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, "/api/chirps", nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+valid)
+			} else {
+				req.AddCookie(&http.Cookie{Name: accessCookieName, Value: valid})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(csrfHeaderName, tt.csrfHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAuthScopedTokens(t *testing.T) {
+	cfg := &apiConfig{secret: testSecret}
+	userID := uuid.New()
+	firstParty, err := auth.MakeJWT(userID, auth.RoleUser, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := auth.MakeScopedJWT(userID, testSecret, "some-client", []string{oauth.ScopeChirpsWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	tests := []struct {
+		name       string
+		middleware func(http.HandlerFunc) http.HandlerFunc
+		token      string
+		wantStatus int
+	}{
+		{"first-party token", cfg.middlewareAuth, firstParty, http.StatusOK},
+		{"scoped token", cfg.middlewareAuth, scoped, http.StatusForbidden},
+		{"first-party token on scoped route", cfg.middlewareScopedAuth, firstParty, http.StatusOK},
+		{"scoped token on scoped route", cfg.middlewareScopedAuth, scoped, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			tt.middleware(ok)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
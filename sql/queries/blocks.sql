@@ -0,0 +1,45 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :exec
+DELETE FROM user_blocks
+WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: IsBlockedBetween :one
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = sqlc.arg(user_id) AND blocked_id = ANY(sqlc.arg(other_ids)::uuid[]))
+       OR (blocked_id = sqlc.arg(user_id) AND blocker_id = ANY(sqlc.arg(other_ids)::uuid[]))
+);
+
+-- name: MuteUser :exec
+INSERT INTO user_mutes (muter_id, muted_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnmuteUser :exec
+DELETE FROM user_mutes
+WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: ListHiddenAuthorIDs :many
+SELECT blocked_id AS user_id FROM user_blocks WHERE blocker_id = $1
+UNION
+SELECT blocker_id AS user_id FROM user_blocks WHERE blocked_id = $1
+UNION
+SELECT muted_id AS user_id FROM user_mutes WHERE muter_id = $1;
+
+-- name: DeleteUserBlocks :exec
+DELETE FROM user_blocks;
+
+-- name: DeleteUserMutes :exec
+DELETE FROM user_mutes;
\ No newline at end of file
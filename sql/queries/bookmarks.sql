@@ -0,0 +1,68 @@
+-- name: SaveBookmark :one
+INSERT INTO bookmarks (user_id, chirp_id, folder_id, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+ON CONFLICT (user_id, chirp_id) DO UPDATE SET folder_id = EXCLUDED.folder_id
+RETURNING *;
+
+-- name: DeleteBookmark :execrows
+DELETE FROM bookmarks
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: ListBookmarks :many
+SELECT b.created_at AS bookmarked_at, b.folder_id, sqlc.embed(c)
+FROM bookmarks b
+JOIN chirps c ON c.id = b.chirp_id
+WHERE b.user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(folder_id)::uuid IS NULL OR b.folder_id = sqlc.narg(folder_id))
+  AND (sqlc.narg(before_created_at)::timestamp IS NULL
+       OR (b.created_at, b.chirp_id) < (sqlc.narg(before_created_at)::timestamp, sqlc.narg(before_chirp_id)::uuid))
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks ub
+    WHERE (ub.blocker_id = c.user_id AND ub.blocked_id = sqlc.arg(user_id))
+       OR (ub.blocker_id = sqlc.arg(user_id) AND ub.blocked_id = c.user_id)
+  )
+ORDER BY b.created_at DESC, b.chirp_id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: CreateBookmarkFolder :one
+INSERT INTO bookmark_folders (id, user_id, name, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW()
+)
+RETURNING *;
+
+-- name: GetBookmarkFolder :one
+SELECT * FROM bookmark_folders
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListBookmarkFolders :many
+SELECT * FROM bookmark_folders
+WHERE user_id = $1
+ORDER BY name ASC;
+
+-- name: CountBookmarkFolders :one
+SELECT COUNT(*) FROM bookmark_folders
+WHERE user_id = $1;
+
+-- name: DeleteBookmarkFolder :execrows
+DELETE FROM bookmark_folders
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteBookmarks :exec
+DELETE FROM bookmarks;
+
+-- name: DeleteBookmarkFolders :exec
+DELETE FROM bookmark_folders;
+
+-- name: LockBookmarkFolders :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
\ No newline at end of file
@@ -1,24 +1,91 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to_id, publish_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
 RETURNING *;
 -- name: GetSingleChirp :one
 SELECT * FROM chirps
 WHERE id = $1;
 
--- name: GetChirpsAscByCreated :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
+-- name: GetVisibleChirp :one
+SELECT * FROM chirps c
+WHERE c.id = sqlc.arg(id)
+  AND (c.publish_at IS NULL OR c.user_id = sqlc.narg(viewer_id))
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks b
+    WHERE (b.blocker_id = c.user_id AND b.blocked_id = sqlc.narg(viewer_id))
+       OR (b.blocker_id = sqlc.narg(viewer_id) AND b.blocked_id = c.user_id)
+  );
+
+-- name: ListChirps :many
+SELECT sqlc.embed(c), (p.chirp_id IS NOT NULL)::bool AS pinned
+FROM chirps c
+LEFT JOIN pinned_chirps p
+    ON p.chirp_id = c.id AND p.user_id = sqlc.narg(author_id)
+WHERE (sqlc.narg(author_id)::uuid IS NULL OR c.user_id = sqlc.narg(author_id))
+  AND c.publish_at IS NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks b
+    WHERE (b.blocker_id = c.user_id AND b.blocked_id = sqlc.narg(viewer_id))
+       OR (b.blocker_id = sqlc.narg(viewer_id) AND b.blocked_id = c.user_id)
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM user_mutes m
+    WHERE m.muter_id = sqlc.narg(viewer_id) AND m.muted_id = c.user_id
+  )
+ORDER BY
+    p.position ASC NULLS LAST,
+    CASE WHEN sqlc.arg(newest_first)::bool THEN c.created_at END DESC,
+    CASE WHEN NOT sqlc.arg(newest_first)::bool THEN c.created_at END ASC;
 
 -- name: DeleteChirps :exec
 DELETE FROM chirps;
 
 -- name: DeleteSingleChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ListScheduledChirps :many
+SELECT * FROM chirps
+WHERE user_id = $1 AND publish_at IS NOT NULL
+ORDER BY publish_at, id;
+
+-- name: RescheduleChirp :one
+UPDATE chirps
+SET body = $2,
+    publish_at = $3,
+    updated_at = NOW()
+WHERE id = $1 AND publish_at IS NOT NULL
+RETURNING *;
+
+-- name: CancelScheduledChirp :execrows
+DELETE FROM chirps
+WHERE id = $1 AND publish_at IS NOT NULL;
+
+-- name: PublishDueChirps :many
+UPDATE chirps
+SET created_at = publish_at,
+    updated_at = NOW(),
+    publish_at = NULL
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE publish_at <= sqlc.arg(now)
+    ORDER BY publish_at
+    LIMIT sqlc.arg(max_results)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
\ No newline at end of file
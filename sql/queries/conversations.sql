@@ -0,0 +1,99 @@
+-- name: CreateConversation :one
+INSERT INTO conversations (id, is_group, title, created_by, created_at, updated_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW()
+)
+RETURNING *;
+
+-- name: AddConversationParticipant :exec
+INSERT INTO conversation_participants (conversation_id, user_id, joined_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+);
+
+-- name: FindDirectConversation :one
+SELECT c.* FROM conversations c
+WHERE NOT c.is_group
+  AND EXISTS (SELECT 1 FROM conversation_participants p WHERE p.conversation_id = c.id AND p.user_id = sqlc.arg(user_id))
+  AND EXISTS (SELECT 1 FROM conversation_participants p WHERE p.conversation_id = c.id AND p.user_id = sqlc.arg(other_id));
+
+-- name: LockDirectConversation :exec
+SELECT pg_advisory_xact_lock(hashtextextended(LEAST(sqlc.arg(user_id)::uuid, sqlc.arg(other_id)::uuid)::text || GREATEST(sqlc.arg(user_id)::uuid, sqlc.arg(other_id)::uuid)::text, 0));
+
+-- name: GetConversation :one
+SELECT * FROM conversations
+WHERE id = $1;
+
+-- name: IsConversationParticipant :one
+SELECT EXISTS (
+    SELECT 1 FROM conversation_participants
+    WHERE conversation_id = $1 AND user_id = $2
+);
+
+-- name: ListConversationParticipants :many
+SELECT conversation_id, user_id FROM conversation_participants
+WHERE conversation_id = ANY(sqlc.arg(conversation_ids)::uuid[])
+ORDER BY joined_at ASC;
+
+-- name: ListConversationsForUser :many
+SELECT c.id, c.is_group, c.title, c.created_by, c.created_at, c.updated_at, p.last_read_at,
+    (SELECT COUNT(*) FROM messages m
+     WHERE m.conversation_id = c.id
+       AND m.sender_id <> p.user_id
+       AND (p.last_read_at IS NULL OR m.created_at > p.last_read_at)) AS unread_count
+FROM conversations c
+JOIN conversation_participants p ON p.conversation_id = c.id
+WHERE p.user_id = $1
+ORDER BY c.updated_at DESC
+LIMIT $2;
+
+-- name: TouchConversation :exec
+UPDATE conversations
+SET updated_at = NOW()
+WHERE id = $1;
+
+-- name: CreateMessage :one
+INSERT INTO messages (id, conversation_id, sender_id, body, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+RETURNING *;
+
+-- name: ListMessages :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg(conversation_id)
+  AND (sqlc.narg(before_id)::uuid IS NULL
+       OR (created_at, id) < (SELECT m.created_at, m.id FROM messages m WHERE m.id = sqlc.narg(before_id) AND m.conversation_id = sqlc.arg(conversation_id)))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: MarkConversationRead :execrows
+UPDATE conversation_participants p
+SET last_read_at = GREATEST(p.last_read_at, m.created_at)
+FROM messages m
+WHERE p.conversation_id = sqlc.arg(conversation_id)
+  AND p.user_id = sqlc.arg(user_id)
+  AND m.id = sqlc.arg(message_id)
+  AND m.conversation_id = p.conversation_id;
+
+-- name: CountUnreadMessages :one
+SELECT COUNT(*) AS unread_messages, COUNT(DISTINCT m.conversation_id) AS unread_conversations
+FROM messages m
+JOIN conversation_participants p ON p.conversation_id = m.conversation_id
+WHERE p.user_id = $1
+  AND m.sender_id <> p.user_id
+  AND (p.last_read_at IS NULL OR m.created_at > p.last_read_at);
+
+-- name: DeleteConversations :exec
+DELETE FROM conversations;
\ No newline at end of file
@@ -0,0 +1,44 @@
+-- name: CreateDraft :one
+INSERT INTO drafts (id, user_id, body, reply_to_id, created_at, updated_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW()
+)
+RETURNING *;
+
+-- name: GetDraft :one
+SELECT * FROM drafts
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListDrafts :many
+SELECT * FROM drafts
+WHERE user_id = $1
+ORDER BY updated_at DESC, id DESC;
+
+-- name: CountDrafts :one
+SELECT COUNT(*) FROM drafts
+WHERE user_id = $1;
+
+-- name: UpdateDraft :one
+UPDATE drafts
+SET body = $3,
+    reply_to_id = $4,
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeleteDraft :execrows
+DELETE FROM drafts
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteDrafts :exec
+DELETE FROM drafts;
+
+-- name: LockDrafts :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
\ No newline at end of file
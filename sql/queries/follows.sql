@@ -0,0 +1,19 @@
+-- name: FollowUser :exec
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: ListFollowedUserIDs :many
+SELECT followee_id FROM follows
+WHERE follower_id = $1;
+
+-- name: DeleteFollows :exec
+DELETE FROM follows;
\ No newline at end of file
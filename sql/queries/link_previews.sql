@@ -0,0 +1,44 @@
+-- name: QueueLinkPreviews :exec
+INSERT INTO link_previews (chirp_id, position, url, status, next_attempt_at)
+SELECT sqlc.arg(chirp_id), l.position, l.url, 'pending', NOW() AT TIME ZONE 'UTC'
+FROM unnest(sqlc.arg(urls)::text[]) WITH ORDINALITY AS l(url, position);
+
+-- name: DeleteLinkPreviews :exec
+DELETE FROM link_previews
+WHERE chirp_id = $1;
+
+-- name: ClaimDueLinkPreviews :many
+UPDATE link_previews
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE (chirp_id, position) IN (
+    SELECT chirp_id, position FROM link_previews
+    WHERE status = 'pending' AND next_attempt_at <= sqlc.arg(now)
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg(max_results)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING chirp_id, position, url, attempts;
+
+-- name: RecordLinkPreview :exec
+UPDATE link_previews
+SET status = 'fetched',
+    attempts = attempts + 1,
+    title = $4,
+    description = $5,
+    image_url = $6,
+    site_name = $7,
+    fetched_at = NOW()
+WHERE chirp_id = $1 AND position = $2 AND url = $3;
+
+-- name: MarkLinkPreviewFailed :exec
+UPDATE link_previews
+SET status = $4,
+    attempts = attempts + 1,
+    next_attempt_at = $5
+WHERE chirp_id = $1 AND position = $2 AND url = $3;
+
+-- name: ListLinkPreviews :many
+SELECT chirp_id, position, url, title, description, image_url, site_name
+FROM link_previews
+WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]) AND status = 'fetched'
+ORDER BY chirp_id, position;
\ No newline at end of file
@@ -0,0 +1,21 @@
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens (id, user_id, fingerprint, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+);
+
+-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens
+SET used_at = NOW()
+WHERE id = sqlc.arg(id)
+    AND fingerprint = sqlc.arg(fingerprint)
+    AND used_at IS NULL
+    AND expires_at > sqlc.arg(now)
+RETURNING *;
+
+-- name: DeleteMagicLinkTokens :exec
+DELETE FROM magic_link_tokens;
\ No newline at end of file
@@ -0,0 +1,32 @@
+-- name: CreateMediaFile :one
+INSERT INTO media_files (id, user_id, content_type, size_bytes, width, height, storage_key, thumbnail_key, thumbnail_type, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW() AT TIME ZONE 'UTC')
+RETURNING *;
+
+-- name: GetMediaFile :one
+SELECT * FROM media_files
+WHERE id = $1;
+
+-- name: AttachMediaFiles :execrows
+UPDATE media_files m
+SET chirp_id = sqlc.arg(chirp_id), position = a.position, alt_text = a.alt_text
+FROM unnest(sqlc.arg(ids)::uuid[], sqlc.arg(alt_texts)::text[]) WITH ORDINALITY AS a(id, alt_text, position)
+WHERE m.id = a.id AND m.user_id = sqlc.arg(user_id) AND m.chirp_id IS NULL;
+
+-- name: ListChirpMediaFiles :many
+SELECT * FROM media_files
+WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[])
+ORDER BY chirp_id, position;
+
+-- name: ListStaleMediaFiles :many
+SELECT * FROM media_files
+WHERE chirp_id IS NULL AND created_at < $1
+ORDER BY created_at
+LIMIT $2;
+
+-- name: DeleteMediaFile :exec
+DELETE FROM media_files
+WHERE id = $1;
+
+-- name: DeleteMediaFiles :exec
+DELETE FROM media_files;
\ No newline at end of file
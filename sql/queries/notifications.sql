@@ -0,0 +1,66 @@
+-- name: CreateNotification :exec
+INSERT INTO notifications (id, user_id, type, actor_id, chirp_id, created_at)
+SELECT gen_random_uuid(), sqlc.arg(user_id), sqlc.arg(type), sqlc.arg(actor_id), sqlc.narg(chirp_id), NOW()
+WHERE sqlc.arg(user_id)::uuid <> sqlc.arg(actor_id)::uuid
+  AND NOT EXISTS (
+    SELECT 1 FROM notification_preferences
+    WHERE user_id = sqlc.arg(user_id) AND type = sqlc.arg(type) AND NOT enabled
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = sqlc.arg(user_id) AND blocked_id = sqlc.arg(actor_id))
+       OR (blocker_id = sqlc.arg(actor_id) AND blocked_id = sqlc.arg(user_id))
+  )
+ON CONFLICT (user_id, type, actor_id, (COALESCE(chirp_id, '00000000-0000-0000-0000-000000000000'::uuid))) DO NOTHING;
+
+-- name: DeleteUnreadNotification :exec
+DELETE FROM notifications
+WHERE user_id = sqlc.arg(user_id)
+  AND type = sqlc.arg(type)
+  AND actor_id = sqlc.arg(actor_id)
+  AND chirp_id IS NOT DISTINCT FROM sqlc.narg(chirp_id)
+  AND read_at IS NULL;
+
+-- name: ListNotificationGroups :many
+SELECT type, chirp_id,
+    COUNT(*) AS count,
+    array_agg(id ORDER BY created_at DESC)::uuid[] AS ids,
+    array_agg(actor_id ORDER BY created_at DESC)::uuid[] AS actor_ids,
+    MAX(created_at)::timestamp AS latest_at,
+    BOOL_OR(read_at IS NULL) AS unread
+FROM notifications
+WHERE user_id = sqlc.arg(user_id)
+  AND (NOT sqlc.arg(unread_only)::bool OR read_at IS NULL)
+GROUP BY type, chirp_id, CASE WHEN type = ANY(sqlc.arg(grouped_types)::text[]) THEN NULL ELSE id END
+ORDER BY latest_at DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+  AND read_at IS NULL
+  AND (COALESCE(cardinality(sqlc.arg(ids)::uuid[]), 0) = 0 OR id = ANY(sqlc.arg(ids)::uuid[]));
+
+-- name: ListNotificationPreferences :many
+SELECT type, enabled FROM notification_preferences
+WHERE user_id = $1;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled;
+
+-- name: DeleteNotifications :exec
+DELETE FROM notifications;
+
+-- name: DeleteNotificationPreferences :exec
+DELETE FROM notification_preferences;
\ No newline at end of file
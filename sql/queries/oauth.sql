@@ -0,0 +1,43 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, user_id, created_at, updated_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW(),
+    NOW()
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7
+);
+
+-- name: UseOAuthCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = sqlc.arg(code_hash)
+    AND used_at IS NULL
+    AND expires_at > sqlc.arg(now)
+RETURNING *;
+
+-- name: DeleteOAuthCodes :exec
+DELETE FROM oauth_authorization_codes;
+
+-- name: DeleteOAuthClients :exec
+DELETE FROM oauth_clients;
\ No newline at end of file
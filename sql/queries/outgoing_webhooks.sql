@@ -0,0 +1,95 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (id, user_id, client_id, url, secret, event_types, created_at, updated_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW(),
+    NOW()
+)
+RETURNING *;
+
+-- name: ListWebhookSubscriptionsByUser :many
+SELECT * FROM webhook_subscriptions
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions
+WHERE id = $1;
+
+-- name: DeleteWebhookSubscription :exec
+DELETE FROM webhook_subscriptions
+WHERE id = $1;
+
+-- name: DeleteWebhookSubscriptions :exec
+DELETE FROM webhook_subscriptions;
+
+-- name: EnqueueWebhookEvent :execrows
+INSERT INTO webhook_outbox (id, subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+SELECT gen_random_uuid(), s.id, sqlc.arg(event_id), sqlc.arg(event_type)::text, sqlc.arg(payload), 'pending', NOW() AT TIME ZONE 'UTC', NOW()
+FROM webhook_subscriptions s
+WHERE s.user_id = sqlc.arg(user_id) AND sqlc.arg(event_type)::text = ANY(s.event_types);
+
+-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_outbox o
+SET next_attempt_at = sqlc.arg(lease_until)
+FROM webhook_subscriptions s
+WHERE o.subscription_id = s.id
+  AND o.id IN (
+    SELECT id FROM webhook_outbox
+    WHERE status = 'pending' AND next_attempt_at <= sqlc.arg(now)
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg(max_results)
+    FOR UPDATE SKIP LOCKED
+  )
+RETURNING o.id, o.event_type, o.payload, o.attempts, s.url, s.secret;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, outbox_id, attempted_at, status_code, error, duration_ms)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_outbox
+SET status = 'delivered',
+    attempts = attempts + 1,
+    last_error = NULL,
+    delivered_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookDeliveryFailed :exec
+UPDATE webhook_outbox
+SET status = $2,
+    attempts = attempts + 1,
+    last_error = $3,
+    next_attempt_at = $4
+WHERE id = $1;
+
+-- name: RequeueWebhookDelivery :execrows
+UPDATE webhook_outbox
+SET status = 'pending',
+    attempts = 0,
+    next_attempt_at = NOW() AT TIME ZONE 'UTC'
+WHERE id = $1 AND subscription_id = $2 AND status = 'dead';
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_outbox
+WHERE subscription_id = sqlc.arg(subscription_id)
+  AND (sqlc.arg(status)::text = '' OR status = sqlc.arg(status)::text)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts
+WHERE outbox_id = ANY(sqlc.arg(outbox_ids)::uuid[])
+ORDER BY attempted_at ASC;
\ No newline at end of file
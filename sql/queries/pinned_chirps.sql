@@ -0,0 +1,25 @@
+-- name: LockPinnedChirps :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ListPinnedChirpIDs :many
+SELECT chirp_id FROM pinned_chirps
+WHERE user_id = $1
+ORDER BY position;
+
+-- name: ClearPinnedChirps :exec
+DELETE FROM pinned_chirps
+WHERE user_id = $1;
+
+-- name: PinChirps :exec
+INSERT INTO pinned_chirps (user_id, chirp_id, position)
+SELECT sqlc.arg(user_id), p.chirp_id, p.position
+FROM unnest(sqlc.arg(chirp_ids)::uuid[]) WITH ORDINALITY AS p(chirp_id, position);
+
+-- name: UnpinChirp :exec
+DELETE FROM pinned_chirps
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: DeletePinnedChirps :exec
+DELETE FROM pinned_chirps;
\ No newline at end of file
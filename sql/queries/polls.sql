@@ -0,0 +1,38 @@
+-- name: CreatePoll :exec
+INSERT INTO polls (chirp_id, closes_at, changeable_votes)
+VALUES ($1, $2, $3);
+
+-- name: CreatePollOptions :exec
+INSERT INTO poll_options (chirp_id, position, text)
+SELECT sqlc.arg(chirp_id), o.position, o.text
+FROM unnest(sqlc.arg(options)::text[]) WITH ORDINALITY AS o(text, position);
+
+-- name: GetPoll :one
+SELECT p.chirp_id, p.closes_at, p.changeable_votes,
+       (p.closes_at <= sqlc.arg(now))::bool AS closed,
+       (SELECT COUNT(*) FROM poll_options o WHERE o.chirp_id = p.chirp_id) AS option_count
+FROM polls p
+WHERE p.chirp_id = sqlc.arg(chirp_id);
+
+-- name: ListPollOptions :many
+SELECT p.chirp_id, p.closes_at, p.changeable_votes,
+       (p.closes_at <= sqlc.arg(now))::bool AS closed,
+       o.position, o.text,
+       (SELECT COUNT(*) FROM poll_votes v
+        WHERE v.chirp_id = o.chirp_id AND v.position = o.position) AS votes,
+       COALESCE((SELECT v.position FROM poll_votes v
+                 WHERE v.chirp_id = p.chirp_id AND v.user_id = sqlc.narg(viewer_id)), 0)::int AS viewer_choice
+FROM polls p
+JOIN poll_options o ON o.chirp_id = p.chirp_id
+WHERE p.chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[])
+ORDER BY p.chirp_id, o.position;
+
+-- name: VotePoll :execrows
+INSERT INTO poll_votes (chirp_id, user_id, position, voted_at)
+SELECT p.chirp_id, sqlc.arg(user_id), sqlc.arg(position), NOW()
+FROM polls p
+WHERE p.chirp_id = sqlc.arg(chirp_id) AND p.closes_at > sqlc.arg(now)
+ON CONFLICT (chirp_id, user_id) DO UPDATE
+SET position = EXCLUDED.position,
+    voted_at = EXCLUDED.voted_at
+WHERE sqlc.arg(changeable)::bool;
\ No newline at end of file
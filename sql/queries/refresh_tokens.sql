@@ -1,11 +1,13 @@
 -- name: StoreRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, client_id, scope)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
@@ -18,5 +20,14 @@ UPDATE refresh_tokens
 SET revoked_at = NOW()
 WHERE token = $1;
 
+-- name: ClaimRefreshToken :one
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE token = sqlc.arg(token)
+    AND client_id = sqlc.arg(client_id)::text
+    AND revoked_at IS NULL
+    AND expires_at > sqlc.arg(now)::timestamp
+RETURNING *;
+
 -- name: DeleteRefreshTokens :exec
 DELETE FROM refresh_tokens;
\ No newline at end of file
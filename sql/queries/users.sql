@@ -13,6 +13,14 @@ RETURNING *;
 SELECT * FROM users
 WHERE email = $1;
 
+-- name: GetExistingUserIDs :many
+SELECT id FROM users
+WHERE id = ANY(sqlc.arg(ids)::uuid[]);
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
@@ -26,4 +34,23 @@ RETURNING *;
 -- name: UpdateUserChirpyRedStatus :exec
 UPDATE users
 SET is_chirpy_red = $2
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id)
+    AND hashed_password = sqlc.arg(old_hash);
+
+-- name: SetUserRoleByEmail :one
+UPDATE users
+SET role = $2,
+    updated_at = NOW()
+WHERE email = $1
+RETURNING *;
\ No newline at end of file
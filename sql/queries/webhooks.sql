@@ -0,0 +1,51 @@
+-- name: MarkWebhookEventProcessed :one
+INSERT INTO processed_webhook_events (event_id, event_type, processed_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (event_id) DO NOTHING
+RETURNING *;
+
+-- name: DeleteProcessedWebhookEvents :exec
+DELETE FROM processed_webhook_events;
+
+-- name: CreateWebhookLogEntry :one
+INSERT INTO webhook_event_log (id, received_at, headers, raw_body, event_id, event_type, verified, outcome, error, processed_at, replay_of)
+VALUES (
+    gen_random_uuid(),
+    NOW() AT TIME ZONE 'UTC',
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING *;
+
+-- name: ListWebhookLogEntries :many
+SELECT * FROM webhook_event_log
+WHERE sqlc.arg(outcome)::text = '' OR outcome = sqlc.arg(outcome)::text
+ORDER BY received_at DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: GetWebhookLogEntry :one
+SELECT * FROM webhook_event_log
+WHERE id = $1;
+
+-- name: ListWebhookLogReplays :many
+SELECT * FROM webhook_event_log
+WHERE replay_of = $1
+ORDER BY received_at;
+
+-- name: DeleteWebhookLogEntriesBefore :execrows
+DELETE FROM webhook_event_log
+WHERE received_at < $1;
+
+-- name: DeleteWebhookLogEntries :exec
+DELETE FROM webhook_event_log;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose up
+CREATE TABLE magic_link_tokens (
+    id TEXT PRIMARY KEY NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose down
+DROP TABLE magic_link_tokens;
\ No newline at end of file
@@ -0,0 +1,34 @@
+-- +goose up
+CREATE TABLE oauth_clients (
+    id TEXT PRIMARY KEY NOT NULL,
+    secret_hash TEXT,
+    name TEXT NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY NOT NULL,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE,
+ADD COLUMN scope TEXT;
+
+-- +goose down
+ALTER TABLE refresh_tokens
+DROP COLUMN client_id,
+DROP COLUMN scope;
+
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
\ No newline at end of file
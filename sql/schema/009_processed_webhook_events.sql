@@ -0,0 +1,9 @@
+-- +goose up
+CREATE TABLE processed_webhook_events (
+    event_id TEXT PRIMARY KEY NOT NULL,
+    event_type TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL
+);
+
+-- +goose down
+DROP TABLE processed_webhook_events;
\ No newline at end of file
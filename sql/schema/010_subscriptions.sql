@@ -0,0 +1,35 @@
+-- +goose up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_start TIMESTAMP NOT NULL,
+    -- NULL when Polka hasn't said when the period ends; such a period never lapses on its own
+    current_period_end TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE subscription_events (
+    id UUID PRIMARY KEY,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Users who were upgraded before subscriptions were tracked get an active one, so later
+-- cancel, downgrade and payment events have something to apply to. Polka never told us when
+-- their periods end, so they're open-ended rather than guessed
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_start, current_period_end, created_at, updated_at)
+SELECT gen_random_uuid(), id, 'chirpy_red', 'active', NOW() AT TIME ZONE 'UTC', NULL, NOW(), NOW()
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose down
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
\ No newline at end of file
@@ -0,0 +1,21 @@
+-- +goose up
+CREATE TABLE webhook_event_log (
+    id UUID PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL,
+    headers JSONB NOT NULL,
+    raw_body BYTEA NOT NULL,
+    event_id TEXT,
+    event_type TEXT,
+    verified BOOLEAN NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT,
+    processed_at TIMESTAMP,
+    -- Set on the entry an admin replay records, pointing at the webhook that was replayed
+    replay_of UUID REFERENCES webhook_event_log(id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_event_log_received_at_idx ON webhook_event_log (received_at);
+CREATE INDEX webhook_event_log_replay_of_idx ON webhook_event_log (replay_of);
+
+-- +goose down
+DROP TABLE webhook_event_log;
\ No newline at end of file
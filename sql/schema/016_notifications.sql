@@ -0,0 +1,28 @@
+-- +goose up
+ALTER TABLE chirps ADD COLUMN reply_to_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('reply', 'like', 'mention', 'follow')),
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP
+);
+
+-- One notification per action, so liking, unliking and liking again doesn't notify twice
+CREATE UNIQUE INDEX notifications_action_idx ON notifications (user_id, type, actor_id, (COALESCE(chirp_id, '00000000-0000-0000-0000-000000000000'::uuid)));
+CREATE INDEX notifications_user_created_idx ON notifications (user_id, created_at DESC);
+
+CREATE TABLE notification_preferences (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('reply', 'like', 'mention', 'follow')),
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+
+-- +goose down
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
+ALTER TABLE chirps DROP COLUMN reply_to_id;
\ No newline at end of file
@@ -0,0 +1,14 @@
+-- +goose up
+CREATE TABLE user_mutes (
+    muter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    muted_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (muter_id, muted_id),
+    CHECK (muter_id <> muted_id)
+);
+
+CREATE INDEX chirps_user_created_idx ON chirps (user_id, created_at);
+
+-- +goose down
+DROP INDEX chirps_user_created_idx;
+DROP TABLE user_mutes;
\ No newline at end of file
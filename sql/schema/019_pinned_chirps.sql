@@ -0,0 +1,10 @@
+-- +goose up
+CREATE TABLE pinned_chirps (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (user_id, chirp_id)
+);
+
+-- +goose down
+DROP TABLE pinned_chirps;
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- +goose up
+CREATE TABLE drafts (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    -- No foreign key: if the chirp replied to is deleted the draft keeps its id, and
+    -- publishing it fails rather than posting the reply as a new thread
+    reply_to_id UUID,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX drafts_user_updated_idx ON drafts (user_id, updated_at DESC);
+
+-- +goose down
+DROP TABLE drafts;
\ No newline at end of file
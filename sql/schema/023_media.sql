@@ -0,0 +1,22 @@
+-- +goose up
+CREATE TABLE media_files (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+    position INTEGER,
+    alt_text TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    storage_key TEXT NOT NULL,
+    thumbnail_key TEXT,
+    thumbnail_type TEXT,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX media_files_chirp_idx ON media_files (chirp_id, position);
+CREATE INDEX media_files_unattached_idx ON media_files (created_at) WHERE chirp_id IS NULL;
+
+-- +goose down
+DROP TABLE media_files;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose up
+CREATE TABLE link_previews (
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    title TEXT,
+    description TEXT,
+    image_url TEXT,
+    site_name TEXT,
+    fetched_at TIMESTAMP,
+    PRIMARY KEY (chirp_id, position)
+);
+
+CREATE INDEX link_previews_due_idx ON link_previews (next_attempt_at) WHERE status = 'pending';
+
+-- +goose down
+DROP TABLE link_previews;
\ No newline at end of file
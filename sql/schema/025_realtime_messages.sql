@@ -0,0 +1,17 @@
+-- +goose up
+-- Realtime messages wait here for every instance to read them, since they're often too big
+-- for a NOTIFY payload. They're only kept for a few minutes.
+CREATE TABLE realtime_messages (
+    id UUID PRIMARY KEY,
+    topic TEXT NOT NULL,
+    event TEXT NOT NULL,
+    data JSONB NOT NULL,
+    sender UUID,
+    author UUID,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX realtime_messages_created_at_idx ON realtime_messages (created_at);
+
+-- +goose down
+DROP TABLE realtime_messages;
\ No newline at end of file